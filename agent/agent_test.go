@@ -98,7 +98,7 @@ func TestAgent_Stats_ReconnectingPTY(t *testing.T) {
 	//nolint:dogsled
 	conn, _, stats, _, _ := setupAgent(t, agentsdk.Manifest{}, 0)
 
-	ptyConn, err := conn.ReconnectingPTY(ctx, uuid.New(), 128, 128, "/bin/bash")
+	ptyConn, err := conn.ReconnectingPTY(ctx, uuid.New(), 128, 128, "/bin/bash", nil)
 	require.NoError(t, err)
 	defer ptyConn.Close()
 
@@ -1447,7 +1447,7 @@ func TestAgent_ReconnectingPTY(t *testing.T) {
 	//nolint:dogsled
 	conn, _, _, _, _ := setupAgent(t, agentsdk.Manifest{}, 0)
 	id := uuid.New()
-	netConn, err := conn.ReconnectingPTY(ctx, id, 100, 100, "/bin/bash")
+	netConn, err := conn.ReconnectingPTY(ctx, id, 100, 100, "/bin/bash", nil)
 	require.NoError(t, err)
 	defer netConn.Close()
 
@@ -1487,7 +1487,7 @@ func TestAgent_ReconnectingPTY(t *testing.T) {
 	expectLine(matchEchoOutput)
 
 	_ = netConn.Close()
-	netConn, err = conn.ReconnectingPTY(ctx, id, 100, 100, "/bin/bash")
+	netConn, err = conn.ReconnectingPTY(ctx, id, 100, 100, "/bin/bash", nil)
 	require.NoError(t, err)
 	defer netConn.Close()
 
@@ -1498,6 +1498,45 @@ func TestAgent_ReconnectingPTY(t *testing.T) {
 	expectLine(matchEchoOutput)
 }
 
+func TestAgent_ReconnectingPTY_Env(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("ConPTY appears to be inconsistent on Windows.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	//nolint:dogsled
+	conn, _, _, _, _ := setupAgent(t, agentsdk.Manifest{}, 0)
+	netConn, err := conn.ReconnectingPTY(ctx, uuid.New(), 100, 100, "/bin/bash", map[string]string{
+		"TERM": "dumb",
+	})
+	require.NoError(t, err)
+	defer netConn.Close()
+
+	bufRead := bufio.NewReader(netConn)
+
+	// Brief pause to reduce the likelihood that we send keystrokes while
+	// the shell is simultaneously sending a prompt.
+	time.Sleep(100 * time.Millisecond)
+
+	data, err := json.Marshal(codersdk.ReconnectingPTYRequest{
+		Data: "echo term is $TERM\r\n",
+	})
+	require.NoError(t, err)
+	_, err = netConn.Write(data)
+	require.NoError(t, err)
+
+	for {
+		line, err := bufRead.ReadString('\n')
+		require.NoError(t, err)
+		if strings.Contains(line, "term is dumb") {
+			break
+		}
+	}
+}
+
 func TestAgent_Dial(t *testing.T) {
 	t.Parallel()
 
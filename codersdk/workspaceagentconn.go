@@ -159,6 +159,11 @@ type WorkspaceAgentReconnectingPTYInit struct {
 	Height  uint16
 	Width   uint16
 	Command string
+	// EnvironmentVariables are added to the spawned command's environment,
+	// overriding any default the agent would otherwise set (e.g. TERM). Only
+	// applied when a new session is created; ignored when reconnecting to an
+	// existing one.
+	EnvironmentVariables map[string]string
 }
 
 // ReconnectingPTYRequest is sent from the client to the server
@@ -173,7 +178,7 @@ type ReconnectingPTYRequest struct {
 // ReconnectingPTY spawns a new reconnecting terminal session.
 // `ReconnectingPTYRequest` should be JSON marshaled and written to the returned net.Conn.
 // Raw terminal output will be read from the returned net.Conn.
-func (c *WorkspaceAgentConn) ReconnectingPTY(ctx context.Context, id uuid.UUID, height, width uint16, command string) (net.Conn, error) {
+func (c *WorkspaceAgentConn) ReconnectingPTY(ctx context.Context, id uuid.UUID, height, width uint16, command string, env map[string]string) (net.Conn, error) {
 	ctx, span := tracing.StartSpan(ctx)
 	defer span.End()
 	if !c.AwaitReachable(ctx) {
@@ -184,10 +189,11 @@ func (c *WorkspaceAgentConn) ReconnectingPTY(ctx context.Context, id uuid.UUID,
 		return nil, err
 	}
 	data, err := json.Marshal(WorkspaceAgentReconnectingPTYInit{
-		ID:      id,
-		Height:  height,
-		Width:   width,
-		Command: command,
+		ID:                   id,
+		Height:               height,
+		Width:                width,
+		Command:              command,
+		EnvironmentVariables: env,
 	})
 	if err != nil {
 		_ = conn.Close()
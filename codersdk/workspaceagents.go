@@ -393,6 +393,11 @@ type WorkspaceAgentReconnectingPTYOpts struct {
 	Height    uint16
 	Command   string
 
+	// Env is applied to the spawned command's environment, overriding any
+	// default the agent would otherwise set (e.g. TERM). Only applied when a
+	// new session is created; ignored when reconnecting to an existing one.
+	Env map[string]string
+
 	// SignedToken is an optional signed token from the
 	// issue-reconnecting-pty-signed-token endpoint. If set, the session token
 	// on the client will not be sent.
@@ -412,6 +417,9 @@ func (c *Client) WorkspaceAgentReconnectingPTY(ctx context.Context, opts Workspa
 	q.Set("width", strconv.Itoa(int(opts.Width)))
 	q.Set("height", strconv.Itoa(int(opts.Height)))
 	q.Set("command", opts.Command)
+	for k, v := range opts.Env {
+		q.Add("tty_env", k+"="+v)
+	}
 	// If we're using a signed token, set the query parameter.
 	if opts.SignedToken != "" {
 		q.Set(SignedAppTokenQueryParameter, opts.SignedToken)
@@ -38,6 +38,11 @@ type Config struct {
 	// avoid loadtest OOMs. All log output is still read and discarded if this
 	// is false.
 	LogOutput bool `json:"log_output"`
+	// Env is applied to the spawned command's environment, overriding any
+	// default the agent would otherwise set (e.g. TERM=xterm-256color), so
+	// that client-specific output behavior (affected by e.g. TERM or
+	// COLORTERM) can be reproduced.
+	Env map[string]string `json:"env"`
 }
 
 func (c Config) Validate() error {
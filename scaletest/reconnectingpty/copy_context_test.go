@@ -0,0 +1,36 @@
+package reconnectingpty
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// shortWriter writes at most max bytes per call, never erroring, to
+// exercise callers that must loop to handle short writes.
+type shortWriter struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	n, err := w.buf.Write(p)
+	return n, err
+}
+
+func Test_copyContext_ShortWrites(t *testing.T) {
+	t.Parallel()
+
+	dst := &shortWriter{max: 3}
+	src := bytes.NewBufferString("hello world\nsecond line\n")
+
+	matched, err := copyContext(context.Background(), dst, src, "")
+	require.NoError(t, err)
+	require.True(t, matched)
+	require.Equal(t, "\thello world\n\tsecond line\n", dst.buf.String())
+}
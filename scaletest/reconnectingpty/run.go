@@ -70,6 +70,7 @@ func (r *Runner) Run(ctx context.Context, _ string, logs io.Writer) error {
 		Width:     width,
 		Height:    height,
 		Command:   r.cfg.Init.Command,
+		Env:       r.cfg.Env,
 	})
 	if err != nil {
 		return xerrors.Errorf("open reconnecting PTY: %w", err)
@@ -108,6 +109,19 @@ func (r *Runner) Run(ctx context.Context, _ string, logs io.Writer) error {
 	return nil
 }
 
+// writeFull writes all of p to dst, looping to handle short writes since
+// io.Writer permits returning n < len(p) without an error.
+func writeFull(dst io.Writer, p []byte) error {
+	for len(p) > 0 {
+		n, err := dst.Write(p)
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
 func copyContext(ctx context.Context, dst io.Writer, src io.Reader, expectOutput string) (bool, error) {
 	var (
 		copyErr = make(chan error)
@@ -138,8 +152,7 @@ func copyContext(ctx context.Context, dst io.Writer, src io.Reader, expectOutput
 				matched = true
 			}
 
-			_, err := dst.Write([]byte("\t" + scanner.Text() + "\n"))
-			if err != nil {
+			if err := writeFull(dst, []byte("\t"+scanner.Text()+"\n")); err != nil {
 				copyErr <- xerrors.Errorf("write to logs: %w", err)
 				return
 			}
@@ -186,6 +186,33 @@ func Test_Runner(t *testing.T) {
 		})
 	})
 
+	t.Run("Env", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := reconnectingpty.NewRunner(client, reconnectingpty.Config{
+			AgentID: agentID,
+			Init: codersdk.WorkspaceAgentReconnectingPTYInit{
+				Command: "echo \"term is $TERM\"; sleep 1",
+			},
+			Env: map[string]string{
+				"TERM": "dumb",
+			},
+			ExpectOutput: "term is dumb",
+			LogOutput:    false,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.NoError(t, err)
+	})
+
 	t.Run("ExpectOutput", func(t *testing.T) {
 		t.Parallel()
 
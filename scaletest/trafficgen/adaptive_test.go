@@ -0,0 +1,95 @@
+package trafficgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_adaptiveController(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NoSampleHoldsSteady", func(t *testing.T) {
+		t.Parallel()
+
+		a := newAdaptiveController(1000, 100, 0.5, 10*time.Millisecond)
+		got := a.next(0, false)
+		require.Equal(t, int64(1000), got)
+		require.Equal(t, int64(1000), a.peak)
+	})
+
+	t.Run("HealthyTickGrows", func(t *testing.T) {
+		t.Parallel()
+
+		a := newAdaptiveController(1000, 100, 0.5, 10*time.Millisecond)
+		got := a.next(5*time.Millisecond, true)
+		require.Equal(t, int64(1100), got)
+		require.Equal(t, int64(1100), a.peak)
+	})
+
+	t.Run("UnhealthyTickBacksOff", func(t *testing.T) {
+		t.Parallel()
+
+		a := newAdaptiveController(1000, 100, 0.5, 10*time.Millisecond)
+		got := a.next(20*time.Millisecond, true)
+		require.Equal(t, int64(500), got)
+		// Backing off doesn't erase the peak reached before the backoff.
+		require.Equal(t, int64(1000), a.peak)
+	})
+
+	t.Run("PeakTracksHighestGrowthBeforeBackoff", func(t *testing.T) {
+		t.Parallel()
+
+		a := newAdaptiveController(1000, 100, 0.5, 10*time.Millisecond)
+		a.next(5*time.Millisecond, true)  // 1100
+		a.next(5*time.Millisecond, true)  // 1200
+		a.next(20*time.Millisecond, true) // backs off to 600
+		require.Equal(t, int64(600), a.bytesPerTick)
+		require.Equal(t, int64(1200), a.peak)
+	})
+
+	t.Run("DefaultsApplyWhenUnset", func(t *testing.T) {
+		t.Parallel()
+
+		a := newAdaptiveController(1000, 0, 0, 10*time.Millisecond)
+		require.Equal(t, int64(DefaultAdaptiveStepBytes), a.stepBytes)
+		require.Equal(t, DefaultAdaptiveBackoffFactor, a.backoffFactor)
+	})
+}
+
+// Test_countReadWriter_latestLatency_staleSamples guards against a stale
+// latency sample being treated as a fresh one on every call: without
+// sampleConsumed, a single slow echo would get punished by
+// adaptiveController.next on every subsequent tick until a new echo
+// arrives, eventually backing bytesPerTick off to zero and, since
+// writeRandomData no-ops on n <= 0, silencing the connection for good.
+func Test_countReadWriter_latestLatency_staleSamples(t *testing.T) {
+	t.Parallel()
+
+	crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, false)
+
+	_, ok := crw.latestLatency()
+	require.False(t, ok, "no sample recorded yet")
+
+	_, err := crw.Write([]byte("hello"))
+	require.NoError(t, err)
+	crw.mu.Lock()
+	crw.recordLatencyLocked(len("hello"))
+	crw.mu.Unlock()
+
+	_, ok = crw.latestLatency()
+	require.True(t, ok, "a freshly recorded sample is reported once")
+
+	_, ok = crw.latestLatency()
+	require.False(t, ok, "the same sample must not be reported again until a new echo arrives")
+
+	_, err = crw.Write([]byte("world!"))
+	require.NoError(t, err)
+	crw.mu.Lock()
+	crw.recordLatencyLocked(len("world!"))
+	crw.mu.Unlock()
+
+	_, ok = crw.latestLatency()
+	require.True(t, ok, "a second echo produces a second fresh sample")
+}
@@ -0,0 +1,167 @@
+package trafficgen
+
+import "time"
+
+// Aggregator combines the Results of multiple separate Runners - for
+// example, a fleet of hundreds of runners sharded across machines - into a
+// single fleet-wide summary, without requiring every Results to be held in
+// memory at once beyond what each Add call needs.
+type Aggregator struct {
+	totalRuns int
+	totalPass int
+	totalFail int
+	elapsed   time.Duration
+
+	bytesSent uint64
+	bytesRcvd uint64
+
+	durations      []time.Duration
+	latencySamples int
+	latencyP50s    []time.Duration
+	latencyP95s    []time.Duration
+	latencyP99s    []time.Duration
+	latencyMax     time.Duration
+
+	slowest *RunDuration
+	fastest *RunDuration
+}
+
+// NewAggregator returns an empty Aggregator ready to have Results added to
+// it.
+func NewAggregator() *Aggregator {
+	return &Aggregator{}
+}
+
+// RunDuration identifies the single slowest or fastest run seen by an
+// Aggregator, labeled by whatever the caller passed to Add, since Results
+// itself carries no run identity of its own.
+type RunDuration struct {
+	Label    string        `json:"label"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Add folds res, identified by label (an agent ID, a hostname, a
+// Config.Labels value - whatever the caller uses to tell runs apart), into
+// the running fleet-wide totals.
+func (a *Aggregator) Add(label string, res Results) {
+	a.totalRuns++
+	if res.Reason == ReasonError {
+		a.totalFail++
+	} else {
+		a.totalPass++
+	}
+	if res.Duration > a.elapsed {
+		a.elapsed = res.Duration
+	}
+
+	a.bytesSent += res.BytesSent
+	a.bytesRcvd += res.BytesRcvd
+
+	a.durations = append(a.durations, res.Duration)
+	if res.LatencySamples > 0 {
+		a.latencySamples += res.LatencySamples
+		a.latencyP50s = append(a.latencyP50s, res.LatencyP50)
+		a.latencyP95s = append(a.latencyP95s, res.LatencyP95)
+		a.latencyP99s = append(a.latencyP99s, res.LatencyP99)
+	}
+	if res.LatencyMax > a.latencyMax {
+		a.latencyMax = res.LatencyMax
+	}
+
+	if a.slowest == nil || res.Duration > a.slowest.Duration {
+		a.slowest = &RunDuration{Label: label, Duration: res.Duration}
+	}
+	if a.fastest == nil || res.Duration < a.fastest.Duration {
+		a.fastest = &RunDuration{Label: label, Duration: res.Duration}
+	}
+}
+
+// AggregatedResults is the fleet-wide summary produced by
+// Aggregator.Results.
+type AggregatedResults struct {
+	TotalRuns int `json:"total_runs"`
+	TotalPass int `json:"total_pass"`
+	TotalFail int `json:"total_fail"`
+
+	Elapsed time.Duration `json:"elapsed"`
+
+	BytesSent uint64 `json:"bytes_sent"`
+	BytesRcvd uint64 `json:"bytes_rcvd"`
+
+	// ThroughputSent and ThroughputRcvd are BytesSent/BytesRcvd combined
+	// across every added Results, divided by Elapsed (the longest
+	// individual run's Duration, standing in for the fleet's wall-clock
+	// time), in bytes/second.
+	ThroughputSent float64 `json:"throughput_sent"`
+	ThroughputRcvd float64 `json:"throughput_rcvd"`
+
+	DurationSamples int           `json:"duration_samples"`
+	DurationP50     time.Duration `json:"duration_p50"`
+	DurationP95     time.Duration `json:"duration_p95"`
+	DurationP99     time.Duration `json:"duration_p99"`
+	DurationMax     time.Duration `json:"duration_max"`
+
+	// LatencySamples is the sum of LatencySamples across every added
+	// Results. LatencyP50/P95/P99 are each the median of every added
+	// Results' own LatencyP50/P95/P99 respectively - an approximation
+	// of a true fleet-wide percentile, since Results doesn't retain raw
+	// per-echo samples past a single Runner's lifetime, but enough to show
+	// whether latency is uniform across the fleet or a handful of runners
+	// are dragging the aggregate up. LatencyMax is the true max across
+	// every added Results, since a single max doesn't need approximating
+	// the way a percentile does.
+	LatencySamples int           `json:"latency_samples"`
+	LatencyP50     time.Duration `json:"latency_p50"`
+	LatencyP95     time.Duration `json:"latency_p95"`
+	LatencyP99     time.Duration `json:"latency_p99"`
+	LatencyMax     time.Duration `json:"latency_max"`
+
+	// Slowest and Fastest identify the single longest- and
+	// shortest-running Results seen across every Add call. Nil if no
+	// Results have been added yet.
+	Slowest *RunDuration `json:"slowest,omitempty"`
+	Fastest *RunDuration `json:"fastest,omitempty"`
+}
+
+// Results returns the combined fleet-wide summary of every Results added so
+// far via Add.
+func (a *Aggregator) Results() AggregatedResults {
+	var throughputSent, throughputRcvd float64
+	if a.elapsed > 0 {
+		throughputSent = float64(a.bytesSent) / a.elapsed.Seconds()
+		throughputRcvd = float64(a.bytesRcvd) / a.elapsed.Seconds()
+	}
+
+	durP50, durP95, durP99, durMax := percentiles(a.durations)
+
+	// medianOf takes the 50th percentile of samples as a single
+	// representative value, used below to collapse each added Results' own
+	// P50/P95/P99 down to one merged figure per field.
+	medianOf := func(samples []time.Duration) time.Duration {
+		p50, _, _, _ := percentiles(samples)
+		return p50
+	}
+
+	return AggregatedResults{
+		TotalRuns:       a.totalRuns,
+		TotalPass:       a.totalPass,
+		TotalFail:       a.totalFail,
+		Elapsed:         a.elapsed,
+		BytesSent:       a.bytesSent,
+		BytesRcvd:       a.bytesRcvd,
+		ThroughputSent:  throughputSent,
+		ThroughputRcvd:  throughputRcvd,
+		DurationSamples: len(a.durations),
+		DurationP50:     durP50,
+		DurationP95:     durP95,
+		DurationP99:     durP99,
+		DurationMax:     durMax,
+		LatencySamples:  a.latencySamples,
+		LatencyP50:      medianOf(a.latencyP50s),
+		LatencyP95:      medianOf(a.latencyP95s),
+		LatencyP99:      medianOf(a.latencyP99s),
+		LatencyMax:      a.latencyMax,
+		Slowest:         a.slowest,
+		Fastest:         a.fastest,
+	}
+}
@@ -0,0 +1,74 @@
+package trafficgen_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/coder/scaletest/trafficgen"
+)
+
+func Test_Aggregator(t *testing.T) {
+	t.Parallel()
+
+	agg := trafficgen.NewAggregator()
+	agg.Add("fleet-a/0", trafficgen.Results{
+		Duration:       100 * time.Millisecond,
+		BytesSent:      1000,
+		BytesRcvd:      2000,
+		LatencySamples: 10,
+		LatencyP50:     10 * time.Millisecond,
+		LatencyP95:     20 * time.Millisecond,
+		LatencyP99:     30 * time.Millisecond,
+		LatencyMax:     40 * time.Millisecond,
+	})
+	agg.Add("fleet-a/1", trafficgen.Results{
+		Reason:         trafficgen.ReasonError,
+		Duration:       900 * time.Millisecond,
+		BytesSent:      9000,
+		BytesRcvd:      8000,
+		LatencySamples: 5,
+		LatencyP50:     50 * time.Millisecond,
+		LatencyP95:     60 * time.Millisecond,
+		LatencyP99:     70 * time.Millisecond,
+		LatencyMax:     200 * time.Millisecond,
+	})
+	agg.Add("fleet-b/0", trafficgen.Results{
+		Duration:  50 * time.Millisecond,
+		BytesSent: 500,
+		BytesRcvd: 500,
+	})
+
+	res := agg.Results()
+	require.Equal(t, 3, res.TotalRuns)
+	require.Equal(t, 2, res.TotalPass)
+	require.Equal(t, 1, res.TotalFail)
+	require.Equal(t, 900*time.Millisecond, res.Elapsed)
+
+	require.Equal(t, uint64(10500), res.BytesSent)
+	require.Equal(t, uint64(10500), res.BytesRcvd)
+	require.InDelta(t, 10500.0/0.9, res.ThroughputSent, 0.001)
+
+	require.Equal(t, 3, res.DurationSamples)
+	require.Equal(t, 900*time.Millisecond, res.DurationMax)
+
+	require.Equal(t, 15, res.LatencySamples)
+	require.Equal(t, 200*time.Millisecond, res.LatencyMax)
+
+	require.NotNil(t, res.Slowest)
+	require.Equal(t, "fleet-a/1", res.Slowest.Label)
+	require.NotNil(t, res.Fastest)
+	require.Equal(t, "fleet-b/0", res.Fastest.Label)
+}
+
+func Test_Aggregator_Empty(t *testing.T) {
+	t.Parallel()
+
+	agg := trafficgen.NewAggregator()
+	res := agg.Results()
+	require.Zero(t, res.TotalRuns)
+	require.Zero(t, res.ThroughputSent)
+	require.Nil(t, res.Slowest)
+	require.Nil(t, res.Fastest)
+}
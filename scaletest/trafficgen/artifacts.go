@@ -0,0 +1,148 @@
+package trafficgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// agentArtifact is the JSON summary written to an agent's artifact
+// directory's results.json when Config.ArtifactDir is set. It's a
+// deliberately small subset of agentResult - just enough for post-mortem
+// debugging of a single agent - rather than the full Results aggregate,
+// which mixes in fields meaningful only across a whole run.
+type agentArtifact struct {
+	AgentID          uuid.UUID     `json:"agent_id"`
+	StartedAt        time.Time     `json:"started_at"`
+	Duration         time.Duration `json:"duration"`
+	Reason           Reason        `json:"reason"`
+	Error            string        `json:"error,omitempty"`
+	BytesSent        uint64        `json:"bytes_sent"`
+	BytesRcvd        uint64        `json:"bytes_rcvd"`
+	Reconnects       int           `json:"reconnects"`
+	ForcedReconnects int           `json:"forced_reconnects"`
+	LatencyP50       time.Duration `json:"latency_p50"`
+	LatencyP95       time.Duration `json:"latency_p95"`
+	LatencyP99       time.Duration `json:"latency_p99"`
+	LatencyMax       time.Duration `json:"latency_max"`
+}
+
+// writeAgentArtifacts dumps cfg, a JSON summary of res, echoSample (whatever
+// this agent read back, up to Config.ArtifactEchoSampleMaxBytes), and a
+// per-read latency CSV into a subdirectory of cfg.ArtifactDir named after
+// agentID and start, once an agent session ends. Best-effort: every file is
+// written with writeFileAtomic, but the directory itself and any individual
+// file failing only returns an error for the caller to log, never one that
+// fails the run. Only called when cfg.ArtifactDir is non-empty.
+func writeAgentArtifacts(cfg Config, agentID uuid.UUID, start time.Time, duration time.Duration, res agentResult, echoSample []byte) error {
+	dir := filepath.Join(cfg.ArtifactDir, fmt.Sprintf("%s-%s", agentID, start.UTC().Format("20060102T150405.000000000Z")))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return xerrors.Errorf("create artifact dir: %w", err)
+	}
+
+	configJSON, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("marshal config: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "config.json"), configJSON); err != nil {
+		return xerrors.Errorf("write config.json: %w", err)
+	}
+
+	errStr := ""
+	if res.err != nil {
+		errStr = res.err.Error()
+	}
+	p50, p95, p99, max := percentiles(res.latencies)
+	artifact := agentArtifact{
+		AgentID:          agentID,
+		StartedAt:        start,
+		Duration:         duration,
+		Reason:           res.reason,
+		Error:            errStr,
+		BytesSent:        res.bytesSent,
+		BytesRcvd:        res.bytesRcvd,
+		Reconnects:       res.reconnects,
+		ForcedReconnects: res.forcedReconnects,
+		LatencyP50:       p50,
+		LatencyP95:       p95,
+		LatencyP99:       p99,
+		LatencyMax:       max,
+	}
+	resultsJSON, err := json.MarshalIndent(artifact, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("marshal results: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "results.json"), resultsJSON); err != nil {
+		return xerrors.Errorf("write results.json: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "echo.sample"), echoSample); err != nil {
+		return xerrors.Errorf("write echo.sample: %w", err)
+	}
+
+	latencyCSV, err := latencyCSVBytes(res.latencies)
+	if err != nil {
+		return xerrors.Errorf("encode latency.csv: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "latency.csv"), latencyCSV); err != nil {
+		return xerrors.Errorf("write latency.csv: %w", err)
+	}
+
+	return nil
+}
+
+// latencyCSVBytes renders samples as a single-column CSV, one
+// latency_ms row per sample, for loading a single agent's raw latency
+// samples (rather than just the percentiles in results.json) into a
+// spreadsheet or plotting tool.
+func latencyCSVBytes(samples []time.Duration) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := writeCSVRecord(buf, []string{"latency_ms"}); err != nil {
+		return nil, err
+	}
+	for _, s := range samples {
+		if err := writeCSVRecord(buf, []string{strconv.FormatFloat(float64(s.Microseconds())/1000, 'f', -1, 64)}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic writes data to a temp file in filepath.Dir(path) and
+// renames it into place, so a process killed mid-write (disk full, OOM
+// kill, etc.) never leaves path holding a truncated artifact. Mirrors the
+// temp-file-plus-rename approach cli's writeWithTempFileAndMove uses for
+// the same reason.
+func writeFileAtomic(path string, data []byte) (err error) {
+	dir := filepath.Dir(path)
+	name := filepath.Base(path)
+
+	f, err := os.CreateTemp(dir, fmt.Sprintf(".%s.", name))
+	if err != nil {
+		return xerrors.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return xerrors.Errorf("write temp file: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return xerrors.Errorf("close temp file: %w", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return xerrors.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package trafficgen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeFileAtomic(t *testing.T) {
+	t.Parallel()
+
+	t.Run("WritesContent", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "out.txt")
+		require.NoError(t, writeFileAtomic(path, []byte("hello")))
+
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(got))
+	})
+
+	t.Run("NoLeftoverTempFileOnSuccess", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		require.NoError(t, writeFileAtomic(filepath.Join(dir, "out.txt"), []byte("hello")))
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, "out.txt", entries[0].Name())
+	})
+
+	t.Run("OverwritesExisting", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "out.txt")
+		require.NoError(t, writeFileAtomic(path, []byte("first")))
+		require.NoError(t, writeFileAtomic(path, []byte("second")))
+
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "second", string(got))
+	})
+}
+
+func Test_latencyCSVBytes(t *testing.T) {
+	t.Parallel()
+
+	out, err := latencyCSVBytes([]time.Duration{time.Millisecond, 2500 * time.Microsecond})
+	require.NoError(t, err)
+	require.Equal(t, "latency_ms\n1\n2.5\n", string(out))
+}
+
+func Test_writeAgentArtifacts(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	agentID := uuid.New()
+	start := time.Now()
+	cfg := Config{ArtifactDir: dir}
+	res := agentResult{
+		agentID:   agentID,
+		bytesSent: 100,
+		bytesRcvd: 200,
+		latencies: []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		reason:    ReasonDeadline,
+	}
+
+	require.NoError(t, writeAgentArtifacts(cfg, agentID, start, time.Second, res, []byte("echoed back")))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	sessionDir := filepath.Join(dir, entries[0].Name())
+
+	for _, name := range []string{"config.json", "results.json", "echo.sample", "latency.csv"} {
+		_, err := os.Stat(filepath.Join(sessionDir, name))
+		require.NoError(t, err, "expected %s to exist", name)
+	}
+
+	echoed, err := os.ReadFile(filepath.Join(sessionDir, "echo.sample"))
+	require.NoError(t, err)
+	require.Equal(t, "echoed back", string(echoed))
+}
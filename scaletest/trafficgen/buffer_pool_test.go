@@ -0,0 +1,36 @@
+package trafficgen
+
+import "testing"
+
+// Benchmark_genPayload_pooled and Benchmark_genPayload_unpooled drive the
+// same reconnect-heavy shape - many short-lived payload generations, as
+// happens once per tick across many reconnects - through the same
+// genPayload, the one handing it a buffer drawn from payloadBufPool and the
+// other a fresh slice every call, so the allocs/op reported by
+// `go test -bench . -benchmem` shows what the pool buys.
+func Benchmark_genPayload_pooled(b *testing.B) {
+	gen := newPayloadGenerator(1, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := payloadBufPool.get(0)
+		payload, err := gen(buf, 256)
+		if err != nil {
+			b.Fatal(err)
+		}
+		payloadBufPool.put(payload)
+	}
+}
+
+func Benchmark_genPayload_unpooled(b *testing.B) {
+	gen := newPayloadGenerator(1, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := gen(nil, 256); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package trafficgen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newByteBudget(t *testing.T) {
+	t.Parallel()
+
+	require.Nil(t, newByteBudget(0))
+	require.Nil(t, newByteBudget(-1))
+	require.NotNil(t, newByteBudget(1))
+}
+
+func Test_byteBudget_take(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NilIsUnlimited", func(t *testing.T) {
+		t.Parallel()
+
+		var b *byteBudget
+		require.EqualValues(t, 100, b.take(100))
+	})
+
+	t.Run("ClampsToRemaining", func(t *testing.T) {
+		t.Parallel()
+
+		b := newByteBudget(150)
+		require.EqualValues(t, 100, b.take(100))
+		require.EqualValues(t, 50, b.take(100))
+		require.Zero(t, b.take(100))
+	})
+
+	t.Run("RecordsDoneAtOnceExhausted", func(t *testing.T) {
+		t.Parallel()
+
+		b := newByteBudget(100)
+		require.True(t, b.doneAt.IsZero())
+
+		require.EqualValues(t, 100, b.take(100))
+		require.False(t, b.doneAt.IsZero())
+
+		doneAt := b.doneAt
+		require.Zero(t, b.take(1))
+		require.Equal(t, doneAt, b.doneAt)
+	})
+}
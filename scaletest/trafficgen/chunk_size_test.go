@@ -0,0 +1,36 @@
+package trafficgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_countReadWriter_chunkSizeCounts(t *testing.T) {
+	t.Parallel()
+
+	crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, false)
+
+	crw.recordChunkSizeLocked(64)
+	crw.recordChunkSizeLocked(100)
+	crw.recordChunkSizeLocked(65536)
+	crw.recordChunkSizeLocked(100000)
+
+	counts := crw.chunkSizeCounts()
+	require.Len(t, counts, len(chunkSizeBucketBounds)+1)
+	require.EqualValues(t, 1, counts[0])                            // 64 falls in the first bucket (<=64)
+	require.EqualValues(t, 1, counts[1])                            // 100 falls in the second bucket (<=128)
+	require.EqualValues(t, 1, counts[len(chunkSizeBucketBounds)-1]) // 65536 is the last finite bucket
+	require.EqualValues(t, 1, counts[len(counts)-1])                // 100000 overflows
+}
+
+func Test_mergeChunkSizeCounts(t *testing.T) {
+	t.Parallel()
+
+	var into []int64
+	into = mergeChunkSizeCounts(into, []int64{1, 2, 3})
+	into = mergeChunkSizeCounts(into, []int64{10, 20, 30})
+
+	require.Equal(t, []int64{11, 22, 33}, into)
+}
@@ -0,0 +1,82 @@
+package trafficgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pickWeightedCommand(t *testing.T) {
+	t.Parallel()
+
+	t.Run("SingleEntryAlwaysPicked", func(t *testing.T) {
+		t.Parallel()
+
+		mix := []CommandWeight{{Command: "top", Weight: 1}}
+		for i := 0; i < 20; i++ {
+			require.Equal(t, "top", pickWeightedCommand(mix))
+		}
+	})
+
+	t.Run("DistributionRoughlyMatchesWeights", func(t *testing.T) {
+		t.Parallel()
+
+		mix := []CommandWeight{
+			{Command: "idle", Weight: 8},
+			{Command: "top", Weight: 1},
+			{Command: "cat", Weight: 1},
+		}
+
+		const trials = 10000
+		counts := map[string]int{}
+		for i := 0; i < trials; i++ {
+			counts[pickWeightedCommand(mix)]++
+		}
+
+		require.InDelta(t, 0.8, float64(counts["idle"])/trials, 0.05)
+		require.InDelta(t, 0.1, float64(counts["top"])/trials, 0.05)
+		require.InDelta(t, 0.1, float64(counts["cat"])/trials, 0.05)
+	})
+}
+
+func Test_aggregateByCommand(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NilForSingleCommand", func(t *testing.T) {
+		t.Parallel()
+
+		results := []agentResult{
+			{command: "/bin/sh", bytesSent: 100, bytesRcvd: 100},
+			{command: "/bin/sh", bytesSent: 200, bytesRcvd: 200},
+		}
+		require.Nil(t, aggregateByCommand(results, time.Second))
+	})
+
+	t.Run("GroupsAndSortsByCommand", func(t *testing.T) {
+		t.Parallel()
+
+		results := []agentResult{
+			{command: "top", bytesSent: 100, bytesRcvd: 50, latencies: []time.Duration{10 * time.Millisecond}},
+			{command: "cat", bytesSent: 300, bytesRcvd: 300, latencies: []time.Duration{20 * time.Millisecond}},
+			{command: "top", bytesSent: 100, bytesRcvd: 50, latencies: []time.Duration{30 * time.Millisecond}},
+		}
+
+		out := aggregateByCommand(results, 2*time.Second)
+		require.Len(t, out, 2)
+
+		require.Equal(t, "cat", out[0].Command)
+		require.Equal(t, 1, out[0].Sessions)
+		require.EqualValues(t, 300, out[0].BytesSent)
+		require.EqualValues(t, 300, out[0].BytesRcvd)
+		require.InDelta(t, 150.0, out[0].ThroughputSent, 0.001)
+		require.Equal(t, 1, out[0].LatencySamples)
+
+		require.Equal(t, "top", out[1].Command)
+		require.Equal(t, 2, out[1].Sessions)
+		require.EqualValues(t, 200, out[1].BytesSent)
+		require.EqualValues(t, 100, out[1].BytesRcvd)
+		require.Equal(t, 2, out[1].LatencySamples)
+		require.Equal(t, 30*time.Millisecond, out[1].LatencyMax)
+	})
+}
@@ -0,0 +1,1259 @@
+package trafficgen
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
+
+	"cdr.dev/slog"
+	"github.com/coder/coder/coderd/httpapi"
+)
+
+const (
+	// DefaultWidth and DefaultHeight are the terminal dimensions used when
+	// Config.Width and Config.Height are unset.
+	DefaultWidth  = 80
+	DefaultHeight = 24
+
+	// DefaultThroughputWarnThreshold is the ratio of achieved to target
+	// throughput below which a run is considered saturated, used when
+	// Config.ThroughputWarnThreshold is unset.
+	DefaultThroughputWarnThreshold = 0.9
+
+	// DefaultRateDriftWarnThreshold is the fraction of per-tick rate
+	// truncation tolerated before Run warns about it at startup, used when
+	// Config.RateDriftWarnThreshold is unset.
+	DefaultRateDriftWarnThreshold = 0.02
+
+	// DefaultReadBufferSize is the size of the buffer drainContext reads
+	// into when Config.ReadBufferSize is unset.
+	DefaultReadBufferSize = 4096
+
+	// DefaultThroughputSampleInterval is the width of each throughput time
+	// window used when Config.ThroughputSampleInterval is unset.
+	DefaultThroughputSampleInterval = time.Second
+
+	// DefaultPayloadPrefix is prepended to every generated payload when
+	// Config.PayloadPrefix is unset. It causes /bin/sh to treat the payload
+	// as a comment instead of attempting to execute it.
+	DefaultPayloadPrefix = "#"
+
+	// DefaultConnectTimeout bounds how long dialing a single agent may take
+	// when Config.ConnectTimeout is unset.
+	DefaultConnectTimeout = 30 * time.Second
+
+	// DefaultTERM is the TERM value set on the reconnecting PTY's command
+	// environment when Config.Env doesn't set one, matching the agent's own
+	// implicit default (see agent.Agent's PTY exec path).
+	DefaultTERM = "xterm-256color"
+
+	// DefaultConnectRetryBackoffFloor and DefaultConnectRetryBackoffCeil
+	// bound the exponential backoff between initial connection attempts
+	// when Config.ConnectRetries is set and Config.ConnectRetryBackoffFloor
+	// or Config.ConnectRetryBackoffCeil is unset.
+	DefaultConnectRetryBackoffFloor = 100 * time.Millisecond
+	DefaultConnectRetryBackoffCeil  = 5 * time.Second
+
+	// DefaultLeakCheckGrace bounds how long Config.LeakCheck waits for the
+	// read goroutine to exit before reporting it as leaked, when
+	// Config.LeakCheckGrace is unset.
+	DefaultLeakCheckGrace = 5 * time.Second
+
+	// DefaultGracefulShutdownGrace bounds how long Config.GracefulShutdown
+	// waits for the exit command to be written before closing the connection
+	// anyway, when Config.GracefulShutdownGrace is unset.
+	DefaultGracefulShutdownGrace = 2 * time.Second
+
+	// DefaultExitSequence is written to each session's connection by
+	// Config.GracefulShutdown when Config.ExitSequence is unset. The leading
+	// newline clears any partial command line left over from the write loop
+	// so "exit" is interpreted as its own command rather than being appended
+	// to one.
+	DefaultExitSequence = "\nexit\n"
+
+	// DefaultMarkerTimeout bounds how long the write loop waits for a
+	// script command's completion marker to be echoed back before giving up,
+	// when Config.ScriptWaitForMarker is set and Config.MarkerTimeout is
+	// unset.
+	DefaultMarkerTimeout = 30 * time.Second
+
+	// DefaultAdaptiveStepBytes is how much bytesPerTick grows on every
+	// healthy tick when Config.Pattern is TrafficPatternAdaptive and
+	// Config.AdaptiveStepBytes is unset.
+	DefaultAdaptiveStepBytes = 1024
+	// DefaultAdaptiveBackoffFactor is the fraction bytesPerTick is cut to
+	// as soon as latency degrades, when Config.Pattern is
+	// TrafficPatternAdaptive and Config.AdaptiveBackoffFactor is unset.
+	DefaultAdaptiveBackoffFactor = 0.5
+
+	// DefaultEchoCaptureMaxBytes caps how much is written to
+	// Config.EchoCapture when Config.EchoCaptureMaxBytes is unset.
+	DefaultEchoCaptureMaxBytes = 10 << 20 // 10 MiB
+
+	// DefaultArtifactEchoSampleMaxBytes caps how much of what an agent read
+	// back is saved to its echo sample artifact when Config.ArtifactDir is
+	// set and Config.ArtifactEchoSampleMaxBytes is unset.
+	DefaultArtifactEchoSampleMaxBytes = 1 << 20 // 1 MiB
+
+	// DefaultSteadyStateWindow is the width of each throughput comparison
+	// window used when Config.SteadyState is set and Config.SteadyStateWindow
+	// is unset.
+	DefaultSteadyStateWindow = 5 * time.Second
+
+	// DefaultConfigBytesPerSecond, DefaultConfigTicksPerSecond, and
+	// DefaultConfigDuration are the values NewConfig starts from before
+	// applying opts, in place of Config{}'s dangerous zero values - most
+	// notably TicksPerSecond, which Validate rejects at zero but which a
+	// hand-built Config can still leave unset by mistake.
+	DefaultConfigBytesPerSecond = 1024
+	DefaultConfigTicksPerSecond = 10
+	DefaultConfigDuration       = 30 * time.Second
+)
+
+// TrafficPattern controls how bytesPerTick is distributed over time.
+type TrafficPattern string
+
+const (
+	// TrafficPatternConstant sends a steady stream of data every tick,
+	// optionally ramped up via RampUp. This is the default if Pattern is
+	// unset.
+	TrafficPatternConstant TrafficPattern = "constant"
+	// TrafficPatternBurst sends Burst.Size bytes every Burst.IdleTicks+1
+	// ticks and nothing on the ticks in between, simulating bursty
+	// interactive terminal use rather than a steady rate.
+	TrafficPatternBurst TrafficPattern = "burst"
+	// TrafficPatternSine modulates bytesPerTick by a sine wave around
+	// BytesPerSecond, configured by Sine, simulating diurnal usage that
+	// rises and falls over a day without scripting external rate changes.
+	TrafficPatternSine TrafficPattern = "sine"
+	// TrafficPatternAdaptive replaces the fixed BytesPerSecond target with a
+	// closed feedback loop: bytesPerTick grows by AdaptiveStepBytes every
+	// tick while the connection's round-trip latency stays at or below
+	// AdaptiveLatencyThreshold, and is cut by AdaptiveBackoffFactor as soon
+	// as it doesn't, converging on (and then holding just below) the
+	// maximum throughput the agent can sustain without its latency
+	// degrading. BytesPerSecond is only used as the starting bytesPerTick;
+	// RampUp, Burst, and Sine are ignored. Results.
+	// AdaptivePeakBytesPerSecond reports the discovered sustainable rate.
+	TrafficPatternAdaptive TrafficPattern = "adaptive"
+	// TrafficPatternThinkTime waits a randomly sampled idle gap, configured
+	// by ThinkTime, between bursts instead of sending on every tick, then
+	// sends a burst sized to the elapsed gap so the long-run average still
+	// matches BytesPerSecond. This simulates a real user pausing between
+	// commands for a variable amount of time, rather than the fixed cadence
+	// of every other pattern.
+	TrafficPatternThinkTime TrafficPattern = "think_time"
+)
+
+// BurstConfig configures TrafficPatternBurst.
+type BurstConfig struct {
+	// Size is the number of bytes sent on each burst tick. If zero, it's
+	// computed automatically so the long-run average still matches
+	// BytesPerSecond given IdleTicks.
+	Size int64 `json:"size"`
+	// IdleTicks is the number of ticks with no data sent between bursts.
+	IdleTicks int64 `json:"idle_ticks"`
+}
+
+// SineConfig configures TrafficPatternSine.
+type SineConfig struct {
+	// Period is the duration of one full cycle. Must be greater than 0.
+	Period httpapi.Duration `json:"period"`
+	// Amplitude is the fraction of BytesPerSecond that bytesPerTick swings
+	// above and below the mean, e.g. 0.5 swings between 50% and 150% of
+	// BytesPerSecond. Must be in (0, 1].
+	Amplitude float64 `json:"amplitude"`
+}
+
+// ThinkTimeDistribution selects how the idle gap between bursts is sampled
+// for TrafficPatternThinkTime.
+type ThinkTimeDistribution string
+
+const (
+	// ThinkTimeConstant waits exactly ThinkTimeConfig.Mean between every
+	// burst. This is the default if Distribution is unset.
+	ThinkTimeConstant ThinkTimeDistribution = "constant"
+	// ThinkTimeUniform samples the gap uniformly from [Min, Max].
+	ThinkTimeUniform ThinkTimeDistribution = "uniform"
+	// ThinkTimeExponential samples the gap from an exponential distribution
+	// with mean Mean, modeling the memoryless pauses of real user think time
+	// better than a fixed or bounded-uniform gap: mostly short pauses with
+	// an occasional long one.
+	ThinkTimeExponential ThinkTimeDistribution = "exponential"
+)
+
+// ThinkTimeConfig configures TrafficPatternThinkTime.
+type ThinkTimeConfig struct {
+	// Distribution selects how each gap is sampled. Defaults to
+	// ThinkTimeConstant.
+	Distribution ThinkTimeDistribution `json:"distribution"`
+	// Mean is the idle gap for ThinkTimeConstant, and the distribution mean
+	// for ThinkTimeExponential. Must be greater than 0. Ignored for
+	// ThinkTimeUniform.
+	Mean httpapi.Duration `json:"mean"`
+	// Min and Max bound the gap for ThinkTimeUniform. Min must be less than
+	// Max. Ignored otherwise.
+	Min httpapi.Duration `json:"min"`
+	Max httpapi.Duration `json:"max"`
+}
+
+// SizeDistribution selects how each tick's payload size varies around the
+// bytesPerTick that Pattern would otherwise write unchanged, instead of
+// writing exactly that many bytes every tick.
+type SizeDistribution string
+
+const (
+	// SizeDistributionNone writes exactly bytesPerTick every tick, as before.
+	// This is the default.
+	SizeDistributionNone SizeDistribution = ""
+	// SizeDistributionUniform samples the size uniformly from
+	// [(1-SizeSpread)*bytesPerTick, (1+SizeSpread)*bytesPerTick].
+	SizeDistributionUniform SizeDistribution = "uniform"
+	// SizeDistributionNormal samples the size from a normal distribution
+	// centered on bytesPerTick with a standard deviation of
+	// SizeSpread*bytesPerTick, clamped at zero so a wide spread can't sample
+	// a negative size.
+	SizeDistributionNormal SizeDistribution = "normal"
+)
+
+// CommandWeight is one entry in Config.CommandMix: Command is launched in
+// roughly Weight/sum(Weight) of sessions.
+type CommandWeight struct {
+	// Command is the command to launch, the same as Config.Command.
+	Command string `json:"command"`
+	// Weight controls this entry's share of sessions relative to the other
+	// entries in CommandMix. Must be greater than 0.
+	Weight float64 `json:"weight"`
+}
+
+// PayloadEntropy controls how compressible the generated payload bytes are,
+// so traffic can exercise both the agent's raw-bytes path and whatever
+// websocket compression it negotiates.
+type PayloadEntropy string
+
+const (
+	// PayloadEntropyRandom generates cryptographically random (or, with Seed
+	// set, seeded pseudo-random) bytes, which are effectively incompressible.
+	// This is the default if PayloadEntropy is unset.
+	PayloadEntropyRandom PayloadEntropy = "random"
+	// PayloadEntropyRepeated fills the payload with a single repeated byte,
+	// which compresses extremely well, simulating output like a progress bar
+	// or a repeated log line.
+	PayloadEntropyRepeated PayloadEntropy = "repeated"
+	// PayloadEntropyText fills the payload with lorem-ipsum-like prose,
+	// which compresses moderately well, simulating typical terminal output.
+	PayloadEntropyText PayloadEntropy = "text"
+)
+
+// ConnectionType is the transport used to generate traffic against the
+// agent.
+type ConnectionType string
+
+const (
+	// ConnectionTypePTY drives the traffic loop over a reconnecting PTY
+	// session. This is the default if ConnectionType is unset.
+	ConnectionTypePTY ConnectionType = "pty"
+	// ConnectionTypeSSH drives the traffic loop over a shell opened via the
+	// agent's built-in SSH server.
+	ConnectionTypeSSH ConnectionType = "ssh"
+)
+
+// NetworkFamily forces which IP address family Run's dials resolve and
+// connect over, for reproducing and testing routing that differs between
+// IPv4 and IPv6 (for example a dual-stack agent that's only reachable over
+// IPv6, which a dial left to pick for itself might never try).
+type NetworkFamily string
+
+const (
+	// NetworkFamilyAuto lets the dial pick whichever family resolves and
+	// connects first. This is the default if NetworkFamily is unset.
+	NetworkFamilyAuto NetworkFamily = ""
+	// NetworkFamilyIPv4 forces every dial to resolve and connect over IPv4
+	// only.
+	NetworkFamilyIPv4 NetworkFamily = "ip4"
+	// NetworkFamilyIPv6 forces every dial to resolve and connect over IPv6
+	// only.
+	NetworkFamilyIPv6 NetworkFamily = "ip6"
+)
+
+// MetricsCompression selects an on-the-wire/disk encoding for the JSON
+// object written to Config.MetricsWriter. It only changes how that object is
+// encoded, never what it contains.
+type MetricsCompression string
+
+const (
+	// MetricsCompressionNone writes Results as plain JSON. This is the
+	// default if MetricsCompression is unset.
+	MetricsCompressionNone MetricsCompression = ""
+	// MetricsCompressionGzip wraps MetricsWriter in a gzip.Writer.
+	MetricsCompressionGzip MetricsCompression = "gzip"
+	// MetricsCompressionZstd wraps MetricsWriter in a zstd.Encoder.
+	MetricsCompressionZstd MetricsCompression = "zstd"
+)
+
+type Config struct {
+	// AgentIDs is the set of agents to generate traffic against. The
+	// aggregate BytesPerSecond is split evenly across each agent, each
+	// agent gets its own connection and goroutine, and their byte counts
+	// are combined in the final Results.
+	AgentIDs []uuid.UUID `json:"agent_ids"`
+	// WorkspaceName and AgentName, if both set, resolve to a single agent ID
+	// via the codersdk.Client passed to NewRunner and are appended to
+	// AgentIDs by Run before it dials anything. WorkspaceName is looked up
+	// under the authenticated user, matching `coder ping <workspace>`, not an
+	// arbitrary owner. This is a convenience for operators who think in terms
+	// of workspace/agent names rather than raw UUIDs; AgentIDs set directly
+	// is still honored and the two are additive. Run fails clearly if the
+	// workspace has zero or more than one agent named AgentName.
+	WorkspaceName string `json:"workspace_name"`
+	// AgentName is the name of the agent within WorkspaceName to resolve.
+	// Required, and only used, when WorkspaceName is set.
+	AgentName string `json:"agent_name"`
+	// SessionsPerAgent is the number of concurrent connections to open
+	// against each agent in AgentIDs, each with its own reconnect token,
+	// modeling a user with many open terminals and stressing the agent's
+	// session-multiplexing code path. The aggregate BytesPerSecond is split
+	// evenly across every session on every agent. Defaults to 1.
+	SessionsPerAgent int `json:"sessions_per_agent"`
+	// ReconnectToken, if set, is used as the reconnecting PTY session token
+	// for every connection this Runner opens, instead of a fresh uuid.New()
+	// per connection. This only makes sense with a single agent and
+	// SessionsPerAgent of 1: its purpose is letting a second Runner
+	// invocation reattach to the exact PTY session a prior run left open,
+	// to validate that the server replays whatever output the agent buffered
+	// while no client was attached. Ignored for ConnectionTypeSSH, which has
+	// no reconnect token. Zero generates a fresh token per connection, as
+	// before.
+	ReconnectToken uuid.UUID `json:"reconnect_token"`
+	// ConnectionType is the transport to generate traffic over. Defaults to
+	// ConnectionTypePTY.
+	ConnectionType ConnectionType `json:"connection_type"`
+	// DryRun, if set, skips load generation entirely. Run instead dials
+	// every agent in AgentIDs, confirms the PTY/SSH handshake by writing a
+	// single newline and reading back the response, then closes the
+	// connection and reports success or failure per agent. All of the load
+	// shaping fields below (BytesPerSecond, TicksPerSecond, Duration, etc.)
+	// are ignored, and not validated, when DryRun is set.
+	DryRun bool `json:"dry_run"`
+	// HandshakeOnly, if set, switches Run from generating data traffic to a
+	// connection setup/teardown benchmark: it repeatedly dials every agent in
+	// AgentIDs, confirms the PTY/SSH handshake the same way DryRun does, then
+	// closes the connection, Connections times per agent, measuring
+	// connections-per-second and handshake latency percentiles (reported via
+	// Results.ConnectP50/P95/P99/Max and Results.HandshakeConnectionsPerSecond)
+	// instead of data throughput. This stresses the server's session-creation
+	// path, which a steady-state traffic run barely exercises. All of the
+	// load shaping fields below are ignored, and not validated, when
+	// HandshakeOnly is set, the same as DryRun. Ignored when DryRun is also
+	// set, since DryRun takes priority.
+	HandshakeOnly bool `json:"handshake_only"`
+	// Connections is the number of times to dial, handshake, and close a
+	// connection against each agent in AgentIDs when HandshakeOnly is set.
+	// Required, and must be greater than 0, when HandshakeOnly is set;
+	// ignored otherwise.
+	Connections int `json:"connections"`
+	// BytesPerSecond is the target number of bytes per second to write to the
+	// connection.
+	BytesPerSecond int64 `json:"bytes_per_second"`
+	// TicksPerSecond is the number of times per second that data is written to
+	// the connection. BytesPerSecond is divided evenly across each tick.
+	// Ignored, and optional, when UseRateLimiter is set.
+	TicksPerSecond int64 `json:"ticks_per_second"`
+	// TickJitter randomizes each tick's interval by up to this fraction of
+	// 1/TicksPerSecond, so that many Runners started at the same instant
+	// with the same TicksPerSecond don't stay phase-locked and produce
+	// synchronized bursts that overstate peak load. The jitter is centered
+	// on zero, so the average tick interval, and therefore the average
+	// achieved rate, still converges to BytesPerSecond. Zero (the default)
+	// produces perfectly regular ticks. Ignored when UseRateLimiter is set,
+	// since there are no discrete ticks to jitter. Must be in [0, 1).
+	TickJitter float64 `json:"tick_jitter"`
+	// TotalTicks, if set, stops the tick-based write loop after exactly this
+	// many ticks have fired, instead of running until Duration elapses.
+	// Ticks still fire at TicksPerSecond's pace, but the loop no longer
+	// depends on wall-clock scheduling to decide when to stop, so the
+	// number of ticks - and therefore the total bytes written - is
+	// deterministic instead of varying with timer jitter. Duration still
+	// bounds the run as a backstop; set it generously enough that
+	// TotalTicks ticks can complete well within it. Ignored, and optional,
+	// when UseRateLimiter is set, since there are no discrete ticks to
+	// count.
+	TotalTicks int `json:"total_ticks"`
+	// MaxInFlight, if greater than 0, caps the number of written-but-not-yet-
+	// echoed bytes outstanding at once, modeling a client with bounded flow
+	// control instead of one that freely queues writes ahead of the agent's
+	// ability to echo them back. Once the cap is reached, the write loop
+	// blocks the next write until enough pending bytes are echoed to make
+	// room, rather than writing unconditionally every tick. Zero (the
+	// default) writes every tick regardless of how much is still
+	// outstanding, as before. See Results.ThrottledTicks for how often the
+	// cap actually blocked a write.
+	MaxInFlight int `json:"max_in_flight"`
+	// KeystrokeDelay, if set, spreads each write across individual
+	// single-byte writes with this much sleep between them, instead of
+	// writing the whole payload in one call, simulating the gaps between a
+	// human typist's keystrokes rather than instant bursts. Zero (the
+	// default) writes each payload in a single call. Large values can
+	// starve a write of its tick interval or rate-limiter budget; this is
+	// not validated against TicksPerSecond or BytesPerSecond.
+	KeystrokeDelay httpapi.Duration `json:"keystroke_delay"`
+	// LineLength, if set and less than the bytes being written in a given
+	// call, splits that write into multiple newline-terminated lines of at
+	// most this many bytes each, instead of one single payload. This
+	// exercises the agent's line buffering the way many short lines of real
+	// terminal input would, rather than one large blob. Zero (the default)
+	// disables splitting.
+	LineLength int64 `json:"line_length"`
+	// UseRateLimiter switches the write loop from discrete per-tick writes to
+	// a token-bucket rate.Limiter paced continuously against BytesPerSecond,
+	// avoiding the bursty, once-per-tick writes of the default tick-based
+	// loop. TicksPerSecond, RampUp, and Pattern/Burst are all ignored when
+	// this is set, since there are no longer discrete ticks to shape.
+	UseRateLimiter bool `json:"use_rate_limiter"`
+	// Duration is the total duration to generate traffic for.
+	Duration httpapi.Duration `json:"duration"`
+	// MaxBytes, if set, caps the aggregate number of bytes written across all
+	// agents, divided evenly across AgentIDs the same way BytesPerSecond is.
+	// The run stops as soon as either MaxBytes is reached or Duration
+	// elapses, whichever comes first. Results.MaxBytesElapsed reports how
+	// long it took to reach the cap, so throughput can still be computed
+	// when MaxBytes is the limiting factor. Zero (the default) means
+	// unbounded, aside from Duration itself.
+	MaxBytes int64 `json:"max_bytes"`
+	// ConnectTimeout bounds how long dialing a single agent's connection may
+	// take, independently of Duration. Applies to every dial, including
+	// DryRun and reconnects. Defaults to DefaultConnectTimeout.
+	ConnectTimeout httpapi.Duration `json:"connect_timeout"`
+	// RunTimeout, if set, is a hard ceiling on how long the entire Run call
+	// may take, covering connecting, generating traffic, and tearing down
+	// every agent's connection. It's enforced via a context deadline
+	// derived separately from the one driving Duration, so the two don't
+	// interfere with each other: if Duration is reached first, the run
+	// still ends normally; if RunTimeout is reached first (for example
+	// because a dial hung, or because RunTimeout was set shorter than
+	// Duration on purpose), the run is cut short instead of blocking the
+	// harness indefinitely. Zero means unbounded, aside from Duration
+	// itself. Ignored when DryRun is set, since dryRunTimeout already
+	// bounds that path.
+	RunTimeout httpapi.Duration `json:"run_timeout"`
+	// RampUp is the duration over which bytesPerTick is linearly scaled from
+	// zero up to its target value, to avoid slamming the agent with full load
+	// immediately. If RampUp is greater than or equal to Duration, the ramp is
+	// stretched across the entire run instead.
+	RampUp httpapi.Duration `json:"ramp_up"`
+	// Warmup is a duration at the start of the run during which traffic is
+	// still sent, but the bytes and latency it produces are excluded from
+	// the final results. This avoids skewing metrics with shell startup or
+	// TLS handshake overhead. Warmup does not affect the overall Duration or
+	// deadline of the run.
+	Warmup httpapi.Duration `json:"warmup"`
+	// SetupCommands, if non-empty, are sent one at a time - each as its own
+	// line - before the measured phase begins, waiting SetupSettleDelay
+	// after each before sending the next. This lets setup side effects (a
+	// cd, an exported variable, a started program) finish without that
+	// overhead being mistaken for the workload itself. Bytes written for
+	// SetupCommands, and whatever echoes they produce, are excluded from
+	// Results entirely - unlike Warmup, which still counts its traffic as
+	// having been sent, just outside the measured window. Only meaningful
+	// when the target command echoes its input; see Command.
+	SetupCommands []string `json:"setup_commands"`
+	// SetupSettleDelay is how long to wait after each of SetupCommands
+	// before sending the next, and after the last one before the measured
+	// phase begins. Ignored if SetupCommands is empty.
+	SetupSettleDelay httpapi.Duration `json:"setup_settle_delay"`
+	// Command is the command to launch in the reconnecting PTY. Defaults to
+	// /bin/sh. The command must echo its input back (e.g. a shell, or a
+	// binary like cat) or the read loop will never see any data. Only used
+	// when ConnectionType is ConnectionTypePTY. Ignored when CommandMix is
+	// non-empty.
+	Command string `json:"command"`
+	// CommandMix, if non-empty, overrides Command: each session independently
+	// picks one entry at random, weighted by CommandWeight.Weight, modeling a
+	// realistic mix of activity (for example mostly idle shells, with a few
+	// running top or tailing a log) instead of every session running the
+	// same command. The same command is kept across that session's
+	// reconnects. Results.ByCommand breaks throughput and latency down per
+	// command so the mix can be compared in aggregate analysis.
+	CommandMix []CommandWeight `json:"command_mix"`
+	// Env is applied to the spawned command's environment, overriding any
+	// default the agent would otherwise set, so that client-specific output
+	// behavior (affected by e.g. TERM or COLORTERM) can be reproduced. Only
+	// used when ConnectionType is ConnectionTypePTY; ignored for
+	// ConnectionTypeSSH, which dials through the tailnet and has no
+	// equivalent concept. If Env doesn't set "TERM", DefaultTERM is added so
+	// existing callers see no change in behavior.
+	Env map[string]string `json:"env"`
+	// Labels are arbitrary caller-defined dimensions (region, instance type,
+	// test name) attached to every metrics output (Results.Labels in the
+	// JSON written to MetricsWriter, a column in CSVWriter rows, constant
+	// labels on every MetricsRegistry series) and every log entry, so that
+	// downstream analysis across thousands of runs can group and filter by
+	// them without having to infer dimensions from AgentIDs.
+	Labels map[string]string `json:"labels"`
+	// Width and Height are the terminal dimensions to request, for both the
+	// reconnecting PTY and the SSH connection types. Defaults to
+	// DefaultWidth and DefaultHeight.
+	Width  uint16 `json:"width"`
+	Height uint16 `json:"height"`
+	// PayloadPrefix is prepended to every generated payload before it's
+	// written to the connection. It defaults to DefaultPayloadPrefix, which
+	// is safe for /bin/sh, but must be overridden to something that's a
+	// no-op for Command if a different shell or binary is configured,
+	// otherwise the generated traffic may be executed instead of just
+	// echoed back.
+	PayloadPrefix string `json:"payload_prefix"`
+	// Seed, if non-zero, is used to derive a deterministic payload generator
+	// so that repeated runs send byte-for-byte identical traffic. If zero, a
+	// cryptographically random payload generator is used instead. Ignored
+	// unless PayloadEntropy is PayloadEntropyRandom (the default).
+	Seed int64 `json:"seed"`
+	// PayloadEntropy selects how compressible the generated payload bytes
+	// are. Defaults to PayloadEntropyRandom.
+	PayloadEntropy PayloadEntropy `json:"payload_entropy"`
+	// ScriptPath, if set, points to a file of newline-separated commands
+	// that writeRandomData cycles through instead of generating payload
+	// bytes, padding or truncating to hit each tick's byte budget. Unlike
+	// the generated payloads, these lines are written as-is, without
+	// PayloadPrefix, so the target command actually executes them instead
+	// of treating them as a no-op comment. This drives real command
+	// execution in the PTY rather than echo-only traffic. Overrides
+	// PayloadEntropy and Seed.
+	ScriptPath string `json:"script_path"`
+	// ScriptWaitForMarker, if set, turns script mode into a request/response
+	// driver instead of writing ScriptPath's commands back-to-back on the
+	// usual BytesPerSecond/TicksPerSecond cadence: each command is followed
+	// by a unique marker echo, and the write loop blocks until that marker
+	// is read back (or MarkerTimeout elapses) before sending the next
+	// command. This measures how long each command actually took to
+	// complete instead of just how fast bytes could be pushed at it - see
+	// Results.ScriptCommandP50. Requires ScriptPath to be set, and only
+	// makes sense when Command echoes its input, since that's what lets the
+	// marker come back at all.
+	ScriptWaitForMarker bool `json:"script_wait_for_marker"`
+	// MarkerTimeout bounds how long the write loop waits for a command's
+	// completion marker before giving up and failing the run. Defaults to
+	// DefaultMarkerTimeout. Ignored unless ScriptWaitForMarker is set.
+	MarkerTimeout httpapi.Duration `json:"marker_timeout"`
+	// ReplayFile, if set, points to a capture of a real session's writes -
+	// JSON Lines of {"delay_ms": ..., "data": "..."} - that the write loop
+	// replays verbatim, waiting each event's delay_ms before writing its
+	// data, instead of generating payload bytes on a BytesPerSecond/
+	// TicksPerSecond cadence. This reproduces a specific incident's exact
+	// traffic shape rather than an approximation of it. Byte counting and
+	// latency measurement work exactly as with any other write loop. Takes
+	// priority over ScriptPath, PayloadEntropy, Seed, UseRateLimiter, and
+	// Pattern, since replay timing comes entirely from the capture. See
+	// ReplayLoop and loadReplay.
+	ReplayFile string `json:"replay_file"`
+	// ReplayLoop, if set, restarts ReplayFile's capture from its first event
+	// once the last one has been written, repeating it for the remainder of
+	// the run instead of going idle once the capture is exhausted. Ignored
+	// unless ReplayFile is set.
+	ReplayLoop bool `json:"replay_loop"`
+	// VerifyEcho, if set, checks every byte read back against the bytes
+	// written, in order, and reports mismatches as Results.CorruptedBytes.
+	// Bytes that are written but never echoed back before the connection
+	// closes are reported as Results.MissingBytes instead. Turns the tool
+	// from a pure throughput test into a data-integrity soak test, at the
+	// cost of buffering unacknowledged writes in memory until they're
+	// echoed back.
+	VerifyEcho bool `json:"verify_echo"`
+	// ThroughputWarnThreshold is the ratio of achieved to target throughput
+	// (BytesSent / duration versus BytesPerSecond) below which a warning is
+	// logged, flagging that the agent likely couldn't keep up. Defaults to
+	// DefaultThroughputWarnThreshold.
+	ThroughputWarnThreshold float64 `json:"throughput_warn_threshold"`
+	// RateDriftWarnThreshold is the fraction by which the per-tick rate
+	// actually achievable (BytesPerSecond / TicksPerSecond, an integer
+	// division that truncates any remainder) may fall short of the
+	// requested BytesPerSecond before Run logs a warning at startup.
+	// Defaults to DefaultRateDriftWarnThreshold. Ignored when UseRateLimiter
+	// is set, since rateLimitedWriteLoop paces continuously rather than in
+	// discrete per-tick chunks and has no truncation to warn about.
+	RateDriftWarnThreshold float64 `json:"rate_drift_warn_threshold"`
+	// DistributeRemainder, if set, spreads the bytes BytesPerSecond /
+	// TicksPerSecond would otherwise truncate away round-robin across ticks,
+	// so the long-run average throughput matches BytesPerSecond exactly
+	// instead of running slightly under it. Only affects the steady-state
+	// constant pattern with RampUp unset; TrafficPatternBurst, Sine, and
+	// Adaptive compute bytesPerTick dynamically and are unaffected, as is a
+	// run with RampUp set.
+	DistributeRemainder bool `json:"distribute_remainder"`
+	// ReadBytesPerSecond, if set, is the expected aggregate read-side
+	// throughput, used only to flag under-delivery via
+	// Results.ReadThroughputAchievedRatio and the same warning logged for
+	// ThroughputWarnThreshold. Unlike BytesPerSecond, this is never enforced:
+	// trafficgen doesn't control how fast Command produces output, so this
+	// is only useful when Command independently generates data at its own
+	// pace (for example a script that streams output on a timer) rather than
+	// simply echoing what was written to it, in which case the read rate is
+	// mechanically tied to the write rate and can never exceed it. Zero (the
+	// default) disables the read-side check.
+	ReadBytesPerSecond int64 `json:"read_bytes_per_second"`
+	// GenerateReadCommand, if set, ignores Command and CommandMix and instead
+	// launches a generated shell command sized to ReadBytesPerSecond that
+	// produces output at roughly that rate on its own, independent of
+	// anything written to it. This turns the tool into a pure
+	// download-throughput benchmark: the write side becomes irrelevant and
+	// the reader just measures delivery. Requires ReadBytesPerSecond to be
+	// set.
+	GenerateReadCommand bool `json:"generate_read_command"`
+	// LatencyThreshold, if set, is the round-trip latency above which a
+	// sample counts as a violation for Results.LatencyViolations and
+	// Results.LatencyViolationRatio. If the violation ratio exceeds
+	// LatencyViolationBudget, Run returns ErrLatencySLOExceeded, making the
+	// generator usable as a hard pass/fail SLO gate in CI. Zero (the
+	// default) disables the check entirely.
+	LatencyThreshold httpapi.Duration `json:"latency_threshold"`
+	// LatencyViolationBudget is the fraction of round-trip samples allowed to
+	// exceed LatencyThreshold before Run reports ErrLatencySLOExceeded.
+	// Defaults to 0, meaning no violations are tolerated. Ignored unless
+	// LatencyThreshold is set.
+	LatencyViolationBudget float64 `json:"latency_violation_budget"`
+	// FailOnZeroBytes, if set, makes Run report ErrZeroBytesTransferred when
+	// the run completes having sent or received zero bytes, which otherwise
+	// looks like an ordinary deadline-terminated success. This catches a
+	// target command that exited immediately instead of producing any
+	// output, or a connection that never actually carried traffic. Results
+	// logs a warning either way, regardless of this flag.
+	FailOnZeroBytes bool `json:"fail_on_zero_bytes"`
+	// ProgressInterval, if set together with ProgressFn, is how often
+	// ProgressFn is called with the current cumulative counters during the
+	// run. Useful for streaming live throughput into a dashboard during
+	// long-running soak tests.
+	ProgressInterval httpapi.Duration `json:"progress_interval"`
+	// ProgressFn, if set, is called every ProgressInterval with the bytes
+	// sent and received so far, and the elapsed run time. Not serialized;
+	// for in-process use only.
+	ProgressFn func(sent, rcvd int64, elapsed time.Duration) `json:"-"`
+	// FailFast, if set, cancels every agent's connection as soon as any
+	// single agent's connection fails. If unset (the default), the other
+	// agents keep running to completion and the failure is still reported
+	// in Results.Error alongside whatever they accumulated.
+	FailFast bool `json:"fail_fast"`
+	// Reconnect enables automatically redialing the agent when a read or
+	// write against the connection fails for a reason other than the run's
+	// deadline, mirroring how a real client would reconnect using the same
+	// PTY reconnect token. Accumulated byte counters carry over across
+	// reconnects.
+	Reconnect bool `json:"reconnect"`
+	// MaxReconnects caps the number of reconnect attempts made when
+	// Reconnect is true. Zero means unlimited.
+	MaxReconnects int `json:"max_reconnects"`
+	// MaxErrors, if set, caps the total number of read/write errors an
+	// agent's connection may encounter - whether or not Reconnect
+	// successfully recovers each one - before Run gives up on that agent
+	// instead of continuing to retry. This is an error budget rather than a
+	// retry limit: unlike MaxReconnects, which only bounds how many dial
+	// attempts are made, MaxErrors bounds how many failures are tolerated in
+	// total, so a connection that keeps failing and reconnecting
+	// successfully still eventually gives up. Zero (the default) tolerates
+	// an unlimited number of errors. See Results.TotalErrors and
+	// Results.ErrorBudgetExhausted.
+	MaxErrors int `json:"max_errors"`
+	// ReconnectInterval, if set, forces a clean reconnect on this cadence,
+	// independently of Reconnect: the connection is closed and redialed even
+	// though it's still healthy, to soak-test the reconnect code path itself
+	// under sustained load rather than waiting for a real transport error.
+	// Counted separately from error-driven reconnects in
+	// Results.ForcedReconnects; accumulated byte counters carry over across
+	// these reconnects the same way they do for Reconnect. Any throughput
+	// dip around a forced reconnect shows up in Results.ThroughputSamples if
+	// ThroughputSampleInterval is set.
+	ReconnectInterval httpapi.Duration `json:"reconnect_interval"`
+	// ConnectRetries is the number of additional attempts made to establish
+	// an agent's initial connection if the first attempt fails, each one
+	// separated by an exponential backoff with jitter (see
+	// ConnectRetryBackoffFloor and ConnectRetryBackoffCeil). Useful when many
+	// agents start at once and briefly overwhelm the server. Zero, the
+	// default, means the initial connection is never retried. Unrelated to
+	// Reconnect, which only covers a connection lost after it was
+	// established.
+	ConnectRetries int `json:"connect_retries"`
+	// ConnectRetryBackoffFloor and ConnectRetryBackoffCeil bound the
+	// exponential backoff between attempts when ConnectRetries is set.
+	// Default to DefaultConnectRetryBackoffFloor and
+	// DefaultConnectRetryBackoffCeil.
+	ConnectRetryBackoffFloor httpapi.Duration `json:"connect_retry_backoff_floor"`
+	ConnectRetryBackoffCeil  httpapi.Duration `json:"connect_retry_backoff_ceil"`
+	// LeakCheck, if set, verifies after each agent's connection closes that
+	// the background goroutine reading from it has exited and that closing
+	// the connection itself didn't error, waiting up to LeakCheckGrace and
+	// logging a warning naming whichever check failed. A run spawns several
+	// goroutines and a connection per agent; at the scale of thousands of
+	// runs, a regression that leaks either compounds into an exhausted
+	// process, which a single run would otherwise never surface.
+	LeakCheck bool `json:"leak_check"`
+	// LeakCheckGrace bounds how long LeakCheck waits for the read goroutine
+	// to exit before reporting it as leaked. Defaults to
+	// DefaultLeakCheckGrace. Ignored unless LeakCheck is set.
+	LeakCheckGrace httpapi.Duration `json:"leak_check_grace"`
+	// GracefulShutdown, if set, writes ExitSequence to each session's
+	// connection before closing it, then waits to see whether the remote
+	// shell acknowledges termination (a read returning io.EOF) instead of
+	// being severed mid-command by the underlying conn.Close. Bounded by
+	// GracefulShutdownGrace either way; see Results.GracefulExits and
+	// Results.ForcedExits for whether it actually exited cleanly.
+	GracefulShutdown bool `json:"graceful_shutdown"`
+	// GracefulShutdownGrace bounds how long GracefulShutdown waits for
+	// ExitSequence to be written and then for the shell to acknowledge
+	// termination, before closing the connection anyway. Defaults to
+	// DefaultGracefulShutdownGrace. Ignored unless GracefulShutdown is set.
+	GracefulShutdownGrace httpapi.Duration `json:"graceful_shutdown_grace"`
+	// ExitSequence is written to each session's connection by
+	// GracefulShutdown, e.g. "\nexit\n" for a shell or "\x04" for Ctrl-D.
+	// Defaults to DefaultExitSequence. Ignored unless GracefulShutdown is
+	// set.
+	ExitSequence string `json:"exit_sequence"`
+	// Pattern controls how bytesPerTick is distributed over time. Defaults
+	// to TrafficPatternConstant.
+	Pattern TrafficPattern `json:"pattern"`
+	// Burst configures TrafficPatternBurst. Ignored otherwise.
+	Burst BurstConfig `json:"burst"`
+	// Sine configures TrafficPatternSine. Ignored otherwise.
+	Sine SineConfig `json:"sine"`
+	// AdaptiveLatencyThreshold is the round-trip latency above which
+	// TrafficPatternAdaptive backs off bytesPerTick instead of growing it.
+	// Required when Pattern is TrafficPatternAdaptive; ignored otherwise.
+	AdaptiveLatencyThreshold httpapi.Duration `json:"adaptive_latency_threshold"`
+	// AdaptiveStepBytes is how much bytesPerTick grows on every tick where
+	// latency is healthy. Defaults to DefaultAdaptiveStepBytes. Ignored
+	// unless Pattern is TrafficPatternAdaptive.
+	AdaptiveStepBytes int64 `json:"adaptive_step_bytes"`
+	// AdaptiveBackoffFactor is the fraction bytesPerTick is multiplied by
+	// as soon as latency exceeds AdaptiveLatencyThreshold, e.g. 0.5 halves
+	// it. Defaults to DefaultAdaptiveBackoffFactor. Must be in (0, 1).
+	// Ignored unless Pattern is TrafficPatternAdaptive.
+	AdaptiveBackoffFactor float64 `json:"adaptive_backoff_factor"`
+	// ThinkTime configures TrafficPatternThinkTime. Ignored otherwise.
+	ThinkTime ThinkTimeConfig `json:"think_time"`
+	// SizeDistribution, if set, varies each tick's payload size around the
+	// bytesPerTick Pattern computes for that tick, so the long-run average
+	// still converges to BytesPerSecond but no two ticks write an identically
+	// sized payload - closer to the variable-length frames of real terminal
+	// output, and a better stress of the agent's buffer handling than a
+	// constant frame size. Zero (the default) writes bytesPerTick unchanged,
+	// as before. Requires SizeSpread.
+	SizeDistribution SizeDistribution `json:"size_distribution"`
+	// SizeSpread controls how far each tick's size strays from bytesPerTick
+	// when SizeDistribution is set: for SizeDistributionUniform, it's the
+	// fraction of bytesPerTick the size can swing above or below it, e.g. 0.5
+	// samples between 50% and 150% of bytesPerTick; for
+	// SizeDistributionNormal, it's the standard deviation as a fraction of
+	// bytesPerTick, e.g. 0.2 samples from a normal distribution with a
+	// standard deviation of 20% of bytesPerTick. Must be greater than 0.
+	// Ignored unless SizeDistribution is set.
+	SizeSpread float64 `json:"size_spread"`
+	// IdleTimeout, if set, ends an agent's read loop as soon as this much
+	// time passes without a single byte being read back, instead of waiting
+	// out the rest of Duration. This catches a connection that stays open
+	// but stops echoing much faster than a full run would. The agent's
+	// write loop and the other agents in AgentIDs are unaffected; Results.
+	// Reason reports ReasonStalled if this is what ended the run. Zero (the
+	// default) disables the check.
+	IdleTimeout httpapi.Duration `json:"idle_timeout"`
+	// DrainTimeout, if set, keeps an agent's read loop open for this long
+	// after its write loop stops (whether from Duration elapsing, MaxBytes
+	// being reached, or an error) before the connection is closed, so
+	// echoes still in flight at that moment have a chance to arrive and be
+	// counted. Without it, the connection can close out from under those
+	// trailing reads, undercounting Results.BytesRcvd and, with VerifyEcho,
+	// reporting bytes as missing that were actually just slow. Zero (the
+	// default) closes the connection as soon as the write loop stops, as
+	// before.
+	DrainTimeout httpapi.Duration `json:"drain_timeout"`
+	// KeepaliveInterval, if set, writes a small application-level ping
+	// payload on this cadence, independently of the regular data traffic,
+	// and times its echo round-trip separately from Results.LatencyP50.
+	// This is for measuring whether the connection itself stays alive and
+	// responsive during a run with long idle gaps between ordinary writes
+	// (for example TrafficPatternBurst or TrafficPatternThinkTime), where
+	// data-echo latency alone wouldn't catch a keepalive that silently
+	// stopped. The ping is a harmless comment line (see PayloadPrefix) so it
+	// doesn't affect Command's behavior. Zero (the default) disables it.
+	KeepaliveInterval httpapi.Duration `json:"keepalive_interval"`
+	// ReadBufferSize is the size, in bytes, of the buffer used to bulk-read
+	// the agent's echoed traffic in drainContext. Defaults to
+	// DefaultReadBufferSize; unrelated to BytesPerSecond or TicksPerSecond.
+	ReadBufferSize int `json:"read_buffer_size"`
+	// ThroughputSampleInterval is the width of the fixed-size time windows
+	// that read/write throughput is bucketed into for Results.
+	// ThroughputSamples, letting a stall or slowdown partway through a run
+	// show up in the data instead of being averaged away. Defaults to
+	// DefaultThroughputSampleInterval.
+	ThroughputSampleInterval httpapi.Duration `json:"throughput_sample_interval"`
+	// MetricsWriter, if set, receives a single JSON-encoded Results object
+	// when the run completes, independently of the human-readable log. This
+	// is meant for scraping into dashboards without having to parse slog
+	// output.
+	MetricsWriter io.Writer `json:"-"`
+	// MetricsCompression, if set, compresses the object written to
+	// MetricsWriter with gzip or zstd instead of writing plain JSON. The
+	// schema is unchanged; only the encoding on top of it differs. Ignored
+	// unless MetricsWriter is set.
+	MetricsCompression MetricsCompression `json:"metrics_compression"`
+	// ResultValidator, if set, is called with the run's final Results once
+	// they're computed. Its error, if any, becomes Run's returned error
+	// (wrapped), letting a caller enforce arbitrary pass/fail criteria —
+	// minimum throughput, maximum latency, byte-integrity — without forking
+	// this package. Not called if Run already failed for another reason.
+	ResultValidator func(Results) error `json:"-"`
+	// MetricsRegistry, if set, receives live counters for bytes written/read
+	// and a histogram of per-tick latency, labeled by agent_id so that
+	// multiple concurrent Runners can share a single registry.
+	MetricsRegistry *prometheus.Registry `json:"-"`
+	// GlobalLimiter, if set, is a token-bucket rate.Limiter that every write
+	// reserves bytes from before writing, in addition to this Runner's own
+	// BytesPerSecond pacing. Sharing one GlobalLimiter across multiple
+	// Runners (and therefore multiple Configs) in the same process caps
+	// their combined throughput at the limiter's configured rate, so the
+	// load generator itself can't exceed, say, the test client's own NIC
+	// capacity and corrupt the results with contention it introduced.
+	// Unset by default, meaning no cross-Runner cap.
+	GlobalLimiter *rate.Limiter `json:"-"`
+	// CSVWriter, if set, receives one CSV row per agent (agent_id, duration,
+	// bytes_sent, bytes_rcvd, throughput, error, labels) as each agent finishes, for
+	// loading hundreds of concurrent Runners' results into a spreadsheet.
+	// Writes are synchronized with loadtestutil.NewSyncWriter, so agents
+	// within a single Run don't interleave rows; sharing one CSVWriter
+	// across multiple Runner instances is only safe if the caller
+	// synchronizes those instances' Run calls itself.
+	CSVWriter io.Writer `json:"-"`
+	// CSVHeader, if set, writes a CSV header row to CSVWriter before any
+	// data rows. Callers sharing one CSVWriter across many Runner instances
+	// should set this on only one of them.
+	CSVHeader bool `json:"-"`
+	// EchoCapture, if set, receives a copy of every byte read back from the
+	// agent connection, for inspecting exactly what the agent echoed during
+	// a run instead of only seeing the counts and samples drainContext
+	// already derives from it. Writes stop once EchoCaptureMaxBytes is
+	// reached; see Results.EchoCaptureTruncated. Not serialized; for
+	// in-process use only.
+	EchoCapture io.Writer `json:"-"`
+	// EchoCaptureMaxBytes caps the total bytes written to EchoCapture, so a
+	// long run can't fill the disk with captured output. Defaults to
+	// DefaultEchoCaptureMaxBytes. Ignored unless EchoCapture is set.
+	EchoCaptureMaxBytes int64 `json:"echo_capture_max_bytes"`
+	// SelfProfile, if set, samples runtime.MemStats immediately before and
+	// after the run and reports the delta as Results.SelfProfile, so a
+	// throughput ceiling can be attributed to the generator's own GC
+	// pressure rather than the server under test. The samples are
+	// process-wide, so this is only meaningful when nothing else contends
+	// for the process's memory during the run.
+	SelfProfile bool `json:"self_profile"`
+	// LogLevel is the minimum level logged to the human-readable log passed
+	// to Run. Defaults to slog.LevelDebug, which also enables logging every
+	// HTTP request/response body via codersdk.Client.LogBodies; that's
+	// appropriate for diagnosing a single run but is itself a bottleneck at
+	// high tick rates, so production soak tests should set this to
+	// slog.LevelInfo or higher.
+	LogLevel slog.Level `json:"log_level"`
+	// Dialer, if set, is used instead of client to open the reconnecting PTY
+	// session for ConnectionTypePTY, letting tests inject an in-memory
+	// implementation instead of requiring a live coderd server. Defaults to
+	// one that calls client.WorkspaceAgentReconnectingPTY. Has no effect on
+	// ConnectionTypeSSH, which always dials through client.
+	Dialer Dialer `json:"-"`
+	// FaultInjector, if set, wraps the io.ReadWriter returned by every dial
+	// attempt (the initial connection and every reconnect) before it's handed
+	// to the read and write loops, letting tests deterministically trigger
+	// errors - for example a write that fails after N bytes, or a read that
+	// stalls past a deadline - so Run's error reporting, Reconnect, and
+	// MaxErrors budget can be exercised without relying on a flaky real
+	// network to produce the failure. Applied on both ConnectionTypeSSH and
+	// ConnectionTypePTY. Has no effect if nil.
+	FaultInjector func(io.ReadWriter) io.ReadWriter `json:"-"`
+	// ResultsEndpoint, if set, is a URL that Run POSTs the final Results to
+	// as JSON once the run completes, for centralized collection across many
+	// Runners without each caller having to wire up its own MetricsWriter
+	// sink. The post is best-effort and bounded: a handful of quick retries
+	// with a short per-attempt timeout, after which a failure is only
+	// logged, never added to Run's returned error.
+	ResultsEndpoint string `json:"results_endpoint"`
+	// HealthAddr, if set, starts an HTTP server listening on this address for
+	// the duration of Run, serving /healthz (whether the run is currently
+	// active and how many connections are currently open, as JSON) and
+	// /metrics (the live counters registered on MetricsRegistry, in
+	// Prometheus exposition format; an empty registry if MetricsRegistry is
+	// unset). This is for running the generator as a long-lived process
+	// under an orchestrator like Kubernetes, which needs something to poll
+	// rather than treating the process as fire-and-forget. The server is
+	// shut down before Run returns.
+	HealthAddr string `json:"health_addr"`
+	// Resolver, if set, is used instead of net.DefaultResolver for every DNS
+	// lookup Run's dials perform, letting tests point hostname resolution at
+	// a fake or restricted nameserver to reproduce a specific resolution
+	// failure or split-horizon setup.
+	Resolver *net.Resolver `json:"-"`
+	// NetworkFamily, if set, forces every dial Run performs onto a single IP
+	// address family. See NetworkFamily. Every dial's resolved and used
+	// address is logged at debug level either way, so a run against a
+	// dual-stack deployment can confirm which family it actually reached
+	// without forcing one.
+	NetworkFamily NetworkFamily `json:"network_family"`
+	// SteadyState, if true, keeps Run going past Duration, polling aggregate
+	// throughput every SteadyStateWindow until two successive windows are
+	// within SteadyStateEpsilon of each other (steady state reached) or
+	// SteadyStateMaxDuration is hit, instead of always stopping as soon as
+	// Duration elapses. Duration is still the minimum run length -
+	// convergence isn't checked until it's passed. See
+	// Results.SteadyStateReached and Results.SteadyStateConvergedAfter.
+	SteadyState bool `json:"steady_state"`
+	// SteadyStateWindow is the width of each throughput window SteadyState
+	// compares against the one before it. Defaults to
+	// DefaultSteadyStateWindow if SteadyState is set and this is zero.
+	SteadyStateWindow httpapi.Duration `json:"steady_state_window"`
+	// SteadyStateEpsilon is the maximum relative change in aggregate
+	// throughput between successive SteadyStateWindow windows for the run to
+	// be considered converged, e.g. 0.05 means successive windows must be
+	// within 5% of each other. Required if SteadyState is set.
+	SteadyStateEpsilon float64 `json:"steady_state_epsilon"`
+	// SteadyStateMaxDuration caps how long SteadyState will keep extending
+	// the run past Duration looking for convergence. Required, and must be
+	// greater than Duration, if SteadyState is set.
+	SteadyStateMaxDuration httpapi.Duration `json:"steady_state_max_duration"`
+	// TransportRTT, if true, samples the underlying transport's RTT once per
+	// dial (the initial connection and every reconnect) and reports it
+	// separately from application-level echo latency (see LatencySamples).
+	// Only takes effect for ConnectionTypeSSH, which dials through the
+	// tailnet and exposes a Ping; ConnectionTypePTY dials a plain websocket
+	// directly over HTTP with no equivalent, so this is a no-op there and
+	// Results.TransportRTTSamples stays zero.
+	TransportRTT bool `json:"transport_rtt"`
+	// ArtifactDir, if set, makes every agent session write its config, a
+	// JSON summary of its own result, a sample of what it read back, and a
+	// per-read latency CSV into a subdirectory of ArtifactDir named after
+	// the agent ID and the session's start time, once that session ends.
+	// This is for post-mortem debugging of a single failing agent without
+	// combing through a shared MetricsWriter/log stream; it has no effect
+	// on Results. Each file is written atomically (temp file plus rename in
+	// the same directory), so a run killed mid-write never leaves a
+	// half-written artifact behind. Best-effort: a write failure is logged
+	// and otherwise ignored.
+	ArtifactDir string `json:"artifact_dir"`
+	// ArtifactEchoSampleMaxBytes caps how much of what an agent read back is
+	// saved to its echo sample file, so a long run can't fill the disk.
+	// Defaults to DefaultArtifactEchoSampleMaxBytes. Ignored unless
+	// ArtifactDir is set.
+	ArtifactEchoSampleMaxBytes int64 `json:"artifact_echo_sample_max_bytes"`
+}
+
+// Option configures a Config built by NewConfig.
+type Option func(*Config)
+
+// WithAgentID appends id to Config.AgentIDs.
+func WithAgentID(id uuid.UUID) Option {
+	return func(c *Config) {
+		c.AgentIDs = append(c.AgentIDs, id)
+	}
+}
+
+// WithBytesPerSecond sets Config.BytesPerSecond.
+func WithBytesPerSecond(bytesPerSecond int64) Option {
+	return func(c *Config) {
+		c.BytesPerSecond = bytesPerSecond
+	}
+}
+
+// WithTicksPerSecond sets Config.TicksPerSecond.
+func WithTicksPerSecond(ticksPerSecond int64) Option {
+	return func(c *Config) {
+		c.TicksPerSecond = ticksPerSecond
+	}
+}
+
+// WithDuration sets Config.Duration.
+func WithDuration(d time.Duration) Option {
+	return func(c *Config) {
+		c.Duration = httpapi.Duration(d)
+	}
+}
+
+// WithReconnect sets Config.Reconnect.
+func WithReconnect(reconnect bool) Option {
+	return func(c *Config) {
+		c.Reconnect = reconnect
+	}
+}
+
+// WithVerifyEcho sets Config.VerifyEcho.
+func WithVerifyEcho(verifyEcho bool) Option {
+	return func(c *Config) {
+		c.VerifyEcho = verifyEcho
+	}
+}
+
+// WithMetricsWriter sets Config.MetricsWriter.
+func WithMetricsWriter(w io.Writer) Option {
+	return func(c *Config) {
+		c.MetricsWriter = w
+	}
+}
+
+// NewConfig builds a Config from opts, starting from DefaultConfigBytesPerSecond,
+// DefaultConfigTicksPerSecond, and DefaultConfigDuration instead of Config{}'s
+// zero values, then validates the result the same way Run does. This spares a
+// programmatic caller building many Configs from having to remember every
+// field's safe default by hand, and surfaces an invalid combination of options
+// immediately instead of only once Run is called.
+func NewConfig(opts ...Option) (Config, error) {
+	cfg := Config{
+		BytesPerSecond: DefaultConfigBytesPerSecond,
+		TicksPerSecond: DefaultConfigTicksPerSecond,
+		Duration:       httpapi.Duration(DefaultConfigDuration),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c Config) Validate() error {
+	if (c.WorkspaceName == "") != (c.AgentName == "") {
+		return xerrors.New("workspace_name and agent_name must be set together")
+	}
+	if len(c.AgentIDs) == 0 && c.WorkspaceName == "" {
+		return xerrors.New("agent_ids must contain at least one agent, or workspace_name and agent_name must be set")
+	}
+	for _, agentID := range c.AgentIDs {
+		if agentID == uuid.Nil {
+			return xerrors.New("agent_ids must not contain a nil agent id")
+		}
+	}
+	if c.SessionsPerAgent < 0 {
+		return xerrors.New("sessions_per_agent must be a positive value")
+	}
+	switch c.ConnectionType {
+	case ConnectionTypePTY, ConnectionTypeSSH, "":
+	default:
+		return xerrors.Errorf("invalid connection_type: %q", c.ConnectionType)
+	}
+	if c.ConnectTimeout < 0 {
+		return xerrors.New("connect_timeout must be a positive value")
+	}
+	switch c.MetricsCompression {
+	case MetricsCompressionNone, MetricsCompressionGzip, MetricsCompressionZstd:
+	default:
+		return xerrors.Errorf("invalid metrics_compression: %q", c.MetricsCompression)
+	}
+	switch c.NetworkFamily {
+	case NetworkFamilyAuto, NetworkFamilyIPv4, NetworkFamilyIPv6:
+	default:
+		return xerrors.Errorf("invalid network_family: %q", c.NetworkFamily)
+	}
+	if c.DryRun {
+		return nil
+	}
+	if c.HandshakeOnly {
+		if c.Connections <= 0 {
+			return xerrors.New("connections must be greater than 0 when handshake_only is set")
+		}
+		return nil
+	}
+	if c.BytesPerSecond <= 0 {
+		return xerrors.New("bytes_per_second must be greater than 0")
+	}
+	if c.TicksPerSecond < 0 {
+		return xerrors.New("ticks_per_second must be greater than 0")
+	}
+	if c.TicksPerSecond == 0 && !c.UseRateLimiter {
+		return xerrors.New("ticks_per_second must be greater than 0")
+	}
+	if c.TickJitter < 0 || c.TickJitter >= 1 {
+		return xerrors.New("tick_jitter must be in [0, 1)")
+	}
+	if c.TotalTicks < 0 {
+		return xerrors.New("total_ticks must be a positive value")
+	}
+	if c.MaxInFlight < 0 {
+		return xerrors.New("max_in_flight must be a positive value")
+	}
+	if c.KeystrokeDelay < 0 {
+		return xerrors.New("keystroke_delay must be a positive value")
+	}
+	if c.LineLength < 0 {
+		return xerrors.New("line_length must be a positive value")
+	}
+	if c.Duration <= 0 {
+		return xerrors.New("duration must be greater than 0")
+	}
+	if c.MaxBytes < 0 {
+		return xerrors.New("max_bytes must be a positive value")
+	}
+	if c.RunTimeout < 0 {
+		return xerrors.New("run_timeout must be a positive value")
+	}
+	if c.RampUp < 0 {
+		return xerrors.New("ramp_up must be a positive value")
+	}
+	if c.Warmup < 0 {
+		return xerrors.New("warmup must be a positive value")
+	}
+	if time.Duration(c.Warmup) >= time.Duration(c.Duration) {
+		return xerrors.New("warmup must be less than duration")
+	}
+	if c.SetupSettleDelay < 0 {
+		return xerrors.New("setup_settle_delay must be a positive value")
+	}
+	if c.ScriptWaitForMarker && c.ScriptPath == "" {
+		return xerrors.New("script_wait_for_marker requires script_path to be set")
+	}
+	if c.MarkerTimeout < 0 {
+		return xerrors.New("marker_timeout must be a positive value")
+	}
+	if c.SteadyState {
+		if c.SteadyStateEpsilon <= 0 {
+			return xerrors.New("steady_state_epsilon must be greater than 0 when steady_state is set")
+		}
+		if c.SteadyStateWindow < 0 {
+			return xerrors.New("steady_state_window must be a positive value")
+		}
+		if time.Duration(c.SteadyStateMaxDuration) <= time.Duration(c.Duration) {
+			return xerrors.New("steady_state_max_duration must be greater than duration when steady_state is set")
+		}
+	}
+	if c.ProgressFn != nil && c.ProgressInterval <= 0 {
+		return xerrors.New("progress_interval must be greater than 0 when progress_fn is set")
+	}
+	if c.MaxReconnects < 0 {
+		return xerrors.New("max_reconnects must be a positive value")
+	}
+	if c.MaxErrors < 0 {
+		return xerrors.New("max_errors must be a positive value")
+	}
+	if time.Duration(c.ReconnectInterval) < 0 {
+		return xerrors.New("reconnect_interval must be a positive value")
+	}
+	if c.ConnectRetries < 0 {
+		return xerrors.New("connect_retries must be a positive value")
+	}
+	if c.ConnectRetryBackoffFloor < 0 {
+		return xerrors.New("connect_retry_backoff_floor must be a positive value")
+	}
+	if c.ConnectRetryBackoffCeil < 0 {
+		return xerrors.New("connect_retry_backoff_ceil must be a positive value")
+	}
+	if c.LeakCheckGrace < 0 {
+		return xerrors.New("leak_check_grace must be a positive value")
+	}
+	if c.GracefulShutdownGrace < 0 {
+		return xerrors.New("graceful_shutdown_grace must be a positive value")
+	}
+	switch c.Pattern {
+	case TrafficPatternConstant, TrafficPatternBurst, TrafficPatternSine, TrafficPatternAdaptive, TrafficPatternThinkTime, "":
+	default:
+		return xerrors.Errorf("invalid pattern: %q", c.Pattern)
+	}
+	switch c.PayloadEntropy {
+	case PayloadEntropyRandom, PayloadEntropyRepeated, PayloadEntropyText, "":
+	default:
+		return xerrors.Errorf("invalid payload_entropy: %q", c.PayloadEntropy)
+	}
+	for _, cw := range c.CommandMix {
+		if cw.Weight <= 0 {
+			return xerrors.New("command_mix weights must be greater than 0")
+		}
+	}
+	if c.Pattern == TrafficPatternBurst {
+		if c.Burst.Size < 0 {
+			return xerrors.New("burst.size must be a positive value")
+		}
+		if c.Burst.IdleTicks < 0 {
+			return xerrors.New("burst.idle_ticks must be a positive value")
+		}
+	}
+	if c.Pattern == TrafficPatternSine {
+		if time.Duration(c.Sine.Period) <= 0 {
+			return xerrors.New("sine.period must be greater than 0")
+		}
+		if c.Sine.Amplitude <= 0 || c.Sine.Amplitude > 1 {
+			return xerrors.New("sine.amplitude must be in (0, 1]")
+		}
+	}
+	if c.Pattern == TrafficPatternAdaptive {
+		if time.Duration(c.AdaptiveLatencyThreshold) <= 0 {
+			return xerrors.New("adaptive_latency_threshold must be greater than 0 when pattern is adaptive")
+		}
+		if c.AdaptiveStepBytes < 0 {
+			return xerrors.New("adaptive_step_bytes must be a positive value")
+		}
+		if c.AdaptiveBackoffFactor < 0 || c.AdaptiveBackoffFactor >= 1 {
+			return xerrors.New("adaptive_backoff_factor must be in (0, 1)")
+		}
+	}
+	if c.Pattern == TrafficPatternThinkTime {
+		switch c.ThinkTime.Distribution {
+		case ThinkTimeUniform:
+			if time.Duration(c.ThinkTime.Min) < 0 {
+				return xerrors.New("think_time.min must be a positive value")
+			}
+			if time.Duration(c.ThinkTime.Max) <= time.Duration(c.ThinkTime.Min) {
+				return xerrors.New("think_time.max must be greater than think_time.min")
+			}
+		case ThinkTimeConstant, ThinkTimeExponential, "":
+			if time.Duration(c.ThinkTime.Mean) <= 0 {
+				return xerrors.New("think_time.mean must be greater than 0")
+			}
+		default:
+			return xerrors.Errorf("invalid think_time.distribution: %q", c.ThinkTime.Distribution)
+		}
+	}
+	switch c.SizeDistribution {
+	case SizeDistributionUniform, SizeDistributionNormal:
+		if c.SizeSpread <= 0 {
+			return xerrors.New("size_spread must be greater than 0 when size_distribution is set")
+		}
+	case SizeDistributionNone:
+	default:
+		return xerrors.Errorf("invalid size_distribution: %q", c.SizeDistribution)
+	}
+	if c.IdleTimeout < 0 {
+		return xerrors.New("idle_timeout must be a positive value")
+	}
+	if c.DrainTimeout < 0 {
+		return xerrors.New("drain_timeout must be a positive value")
+	}
+	if c.KeepaliveInterval < 0 {
+		return xerrors.New("keepalive_interval must be a positive value")
+	}
+	if c.ReadBufferSize < 0 {
+		return xerrors.New("read_buffer_size must be a positive value")
+	}
+	if c.ThroughputSampleInterval < 0 {
+		return xerrors.New("throughput_sample_interval must be a positive value")
+	}
+	if c.ThroughputWarnThreshold < 0 || c.ThroughputWarnThreshold > 1 {
+		return xerrors.New("throughput_warn_threshold must be between 0 and 1")
+	}
+	if c.RateDriftWarnThreshold < 0 || c.RateDriftWarnThreshold > 1 {
+		return xerrors.New("rate_drift_warn_threshold must be between 0 and 1")
+	}
+	if c.ReadBytesPerSecond < 0 {
+		return xerrors.New("read_bytes_per_second must be a positive value")
+	}
+	if c.GenerateReadCommand && c.ReadBytesPerSecond <= 0 {
+		return xerrors.New("generate_read_command requires read_bytes_per_second to be set")
+	}
+	if c.LatencyThreshold < 0 {
+		return xerrors.New("latency_threshold must be a positive value")
+	}
+	if c.LatencyViolationBudget < 0 || c.LatencyViolationBudget > 1 {
+		return xerrors.New("latency_violation_budget must be between 0 and 1")
+	}
+	if c.EchoCaptureMaxBytes < 0 {
+		return xerrors.New("echo_capture_max_bytes must be a positive value")
+	}
+	if c.ArtifactEchoSampleMaxBytes < 0 {
+		return xerrors.New("artifact_echo_sample_max_bytes must be a positive value")
+	}
+
+	return nil
+}
@@ -0,0 +1,866 @@
+package trafficgen_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/scaletest/trafficgen"
+)
+
+func Test_Config(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.New()
+	cases := []struct {
+		name        string
+		config      trafficgen.Config
+		errContains string
+	}{
+		{
+			name: "OK",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+		},
+		{
+			name: "NoAgentIDs",
+			config: trafficgen.Config{
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: "agent_ids must contain at least one agent",
+		},
+		{
+			name: "WorkspaceNameOK",
+			config: trafficgen.Config{
+				WorkspaceName:  "myworkspace",
+				AgentName:      "main",
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+		},
+		{
+			name: "WorkspaceNameWithoutAgentName",
+			config: trafficgen.Config{
+				WorkspaceName:  "myworkspace",
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: "workspace_name and agent_name must be set together",
+		},
+		{
+			name: "AgentNameWithoutWorkspaceName",
+			config: trafficgen.Config{
+				AgentName:      "main",
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: "workspace_name and agent_name must be set together",
+		},
+		{
+			name: "NilAgentID",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{uuid.Nil},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: "agent_ids must not contain a nil agent id",
+		},
+		{
+			name: "DryRunSkipsLoadValidation",
+			config: trafficgen.Config{
+				AgentIDs: []uuid.UUID{id},
+				DryRun:   true,
+			},
+		},
+		{
+			name: "HandshakeOnlySkipsLoadValidation",
+			config: trafficgen.Config{
+				AgentIDs:      []uuid.UUID{id},
+				HandshakeOnly: true,
+				Connections:   5,
+			},
+		},
+		{
+			name: "HandshakeOnlyNoConnections",
+			config: trafficgen.Config{
+				AgentIDs:      []uuid.UUID{id},
+				HandshakeOnly: true,
+			},
+			errContains: "connections must be greater than 0 when handshake_only is set",
+		},
+		{
+			name: "NoBytesPerSecond",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: "bytes_per_second must be greater than 0",
+		},
+		{
+			name: "NoTicksPerSecond",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: "ticks_per_second must be greater than 0",
+		},
+		{
+			name: "NegativeTicksPerSecond",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: -1,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: "ticks_per_second must be greater than 0",
+		},
+		{
+			name: "NoTicksPerSecondWithRateLimiter",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				UseRateLimiter: true,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+		},
+		{
+			name: "NoDuration",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+			},
+			errContains: "duration must be greater than 0",
+		},
+		{
+			name: "InvalidConnectionType",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				ConnectionType: "telnet",
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: `invalid connection_type: "telnet"`,
+		},
+		{
+			name: "InvalidMetricsCompression",
+			config: trafficgen.Config{
+				AgentIDs:           []uuid.UUID{id},
+				MetricsCompression: "lz4",
+				BytesPerSecond:     1024,
+				TicksPerSecond:     10,
+				Duration:           httpapi.Duration(time.Minute),
+			},
+			errContains: `invalid metrics_compression: "lz4"`,
+		},
+		{
+			name: "InvalidNetworkFamily",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				NetworkFamily:  "ip5",
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+			},
+			errContains: `invalid network_family: "ip5"`,
+		},
+		{
+			name: "SteadyStateMissingEpsilon",
+			config: trafficgen.Config{
+				AgentIDs:               []uuid.UUID{id},
+				BytesPerSecond:         1024,
+				TicksPerSecond:         10,
+				Duration:               httpapi.Duration(time.Minute),
+				SteadyState:            true,
+				SteadyStateMaxDuration: httpapi.Duration(2 * time.Minute),
+			},
+			errContains: "steady_state_epsilon must be greater than 0 when steady_state is set",
+		},
+		{
+			name: "SteadyStateNegativeWindow",
+			config: trafficgen.Config{
+				AgentIDs:               []uuid.UUID{id},
+				BytesPerSecond:         1024,
+				TicksPerSecond:         10,
+				Duration:               httpapi.Duration(time.Minute),
+				SteadyState:            true,
+				SteadyStateEpsilon:     0.05,
+				SteadyStateWindow:      httpapi.Duration(-time.Second),
+				SteadyStateMaxDuration: httpapi.Duration(2 * time.Minute),
+			},
+			errContains: "steady_state_window must be a positive value",
+		},
+		{
+			name: "SteadyStateMaxDurationNotGreaterThanDuration",
+			config: trafficgen.Config{
+				AgentIDs:               []uuid.UUID{id},
+				BytesPerSecond:         1024,
+				TicksPerSecond:         10,
+				Duration:               httpapi.Duration(time.Minute),
+				SteadyState:            true,
+				SteadyStateEpsilon:     0.05,
+				SteadyStateMaxDuration: httpapi.Duration(time.Minute),
+			},
+			errContains: "steady_state_max_duration must be greater than duration when steady_state is set",
+		},
+		{
+			name: "NegativeRampUp",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				RampUp:         httpapi.Duration(-time.Second),
+			},
+			errContains: "ramp_up must be a positive value",
+		},
+		{
+			name: "InvalidThroughputWarnThreshold",
+			config: trafficgen.Config{
+				AgentIDs:                []uuid.UUID{id},
+				BytesPerSecond:          1024,
+				TicksPerSecond:          10,
+				Duration:                httpapi.Duration(time.Minute),
+				ThroughputWarnThreshold: 1.5,
+			},
+			errContains: "throughput_warn_threshold must be between 0 and 1",
+		},
+		{
+			name: "InvalidRateDriftWarnThreshold",
+			config: trafficgen.Config{
+				AgentIDs:               []uuid.UUID{id},
+				BytesPerSecond:         1024,
+				TicksPerSecond:         10,
+				Duration:               httpapi.Duration(time.Minute),
+				RateDriftWarnThreshold: 1.5,
+			},
+			errContains: "rate_drift_warn_threshold must be between 0 and 1",
+		},
+		{
+			name: "NegativeWarmup",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Warmup:         httpapi.Duration(-time.Second),
+			},
+			errContains: "warmup must be a positive value",
+		},
+		{
+			name: "WarmupExceedsDuration",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Second),
+				Warmup:         httpapi.Duration(time.Minute),
+			},
+			errContains: "warmup must be less than duration",
+		},
+		{
+			name: "NegativeSetupSettleDelay",
+			config: trafficgen.Config{
+				AgentIDs:         []uuid.UUID{id},
+				BytesPerSecond:   1024,
+				TicksPerSecond:   10,
+				Duration:         httpapi.Duration(time.Minute),
+				SetupCommands:    []string{"cd /tmp"},
+				SetupSettleDelay: httpapi.Duration(-time.Second),
+			},
+			errContains: "setup_settle_delay must be a positive value",
+		},
+		{
+			name: "ScriptWaitForMarkerWithoutScriptPath",
+			config: trafficgen.Config{
+				AgentIDs:            []uuid.UUID{id},
+				BytesPerSecond:      1024,
+				TicksPerSecond:      10,
+				Duration:            httpapi.Duration(time.Minute),
+				ScriptWaitForMarker: true,
+			},
+			errContains: "script_wait_for_marker requires script_path to be set",
+		},
+		{
+			name: "NegativeMarkerTimeout",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				MarkerTimeout:  httpapi.Duration(-time.Second),
+			},
+			errContains: "marker_timeout must be a positive value",
+		},
+		{
+			name: "ProgressFnWithoutInterval",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				ProgressFn:     func(int64, int64, time.Duration) {},
+			},
+			errContains: "progress_interval must be greater than 0 when progress_fn is set",
+		},
+		{
+			name: "NegativeMaxReconnects",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Reconnect:      true,
+				MaxReconnects:  -1,
+			},
+			errContains: "max_reconnects must be a positive value",
+		},
+		{
+			name: "NegativeMaxErrors",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Reconnect:      true,
+				MaxErrors:      -1,
+			},
+			errContains: "max_errors must be a positive value",
+		},
+		{
+			name: "NegativeReconnectInterval",
+			config: trafficgen.Config{
+				AgentIDs:          []uuid.UUID{id},
+				BytesPerSecond:    1024,
+				TicksPerSecond:    10,
+				Duration:          httpapi.Duration(time.Minute),
+				ReconnectInterval: httpapi.Duration(-time.Second),
+			},
+			errContains: "reconnect_interval must be a positive value",
+		},
+		{
+			name: "InvalidPattern",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Pattern:        "sawtooth",
+			},
+			errContains: `invalid pattern: "sawtooth"`,
+		},
+		{
+			name: "BurstNegativeIdleTicks",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Pattern:        trafficgen.TrafficPatternBurst,
+				Burst:          trafficgen.BurstConfig{IdleTicks: -1},
+			},
+			errContains: "burst.idle_ticks must be a positive value",
+		},
+		{
+			name: "SineZeroPeriod",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Pattern:        trafficgen.TrafficPatternSine,
+				Sine:           trafficgen.SineConfig{Amplitude: 0.5},
+			},
+			errContains: "sine.period must be greater than 0",
+		},
+		{
+			name: "SineAmplitudeOutOfRange",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Pattern:        trafficgen.TrafficPatternSine,
+				Sine:           trafficgen.SineConfig{Period: httpapi.Duration(time.Minute), Amplitude: 1.5},
+			},
+			errContains: "sine.amplitude must be in (0, 1]",
+		},
+		{
+			name: "AdaptiveMissingLatencyThreshold",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Pattern:        trafficgen.TrafficPatternAdaptive,
+			},
+			errContains: "adaptive_latency_threshold must be greater than 0",
+		},
+		{
+			name: "AdaptiveNegativeStepBytes",
+			config: trafficgen.Config{
+				AgentIDs:                 []uuid.UUID{id},
+				BytesPerSecond:           1024,
+				TicksPerSecond:           10,
+				Duration:                 httpapi.Duration(time.Minute),
+				Pattern:                  trafficgen.TrafficPatternAdaptive,
+				AdaptiveLatencyThreshold: httpapi.Duration(50 * time.Millisecond),
+				AdaptiveStepBytes:        -1,
+			},
+			errContains: "adaptive_step_bytes must be a positive value",
+		},
+		{
+			name: "AdaptiveBackoffFactorOutOfRange",
+			config: trafficgen.Config{
+				AgentIDs:                 []uuid.UUID{id},
+				BytesPerSecond:           1024,
+				TicksPerSecond:           10,
+				Duration:                 httpapi.Duration(time.Minute),
+				Pattern:                  trafficgen.TrafficPatternAdaptive,
+				AdaptiveLatencyThreshold: httpapi.Duration(50 * time.Millisecond),
+				AdaptiveBackoffFactor:    1.5,
+			},
+			errContains: "adaptive_backoff_factor must be in (0, 1)",
+		},
+		{
+			name: "ThinkTimeZeroMean",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Pattern:        trafficgen.TrafficPatternThinkTime,
+			},
+			errContains: "think_time.mean must be greater than 0",
+		},
+		{
+			name: "ThinkTimeUniformMaxNotGreaterThanMin",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Pattern:        trafficgen.TrafficPatternThinkTime,
+				ThinkTime: trafficgen.ThinkTimeConfig{
+					Distribution: trafficgen.ThinkTimeUniform,
+					Min:          httpapi.Duration(time.Second),
+					Max:          httpapi.Duration(time.Second),
+				},
+			},
+			errContains: "think_time.max must be greater than think_time.min",
+		},
+		{
+			name: "ThinkTimeInvalidDistribution",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				Pattern:        trafficgen.TrafficPatternThinkTime,
+				ThinkTime:      trafficgen.ThinkTimeConfig{Distribution: "bogus"},
+			},
+			errContains: `invalid think_time.distribution: "bogus"`,
+		},
+		{
+			name: "SizeDistributionZeroSpread",
+			config: trafficgen.Config{
+				AgentIDs:         []uuid.UUID{id},
+				BytesPerSecond:   1024,
+				TicksPerSecond:   10,
+				Duration:         httpapi.Duration(time.Minute),
+				SizeDistribution: trafficgen.SizeDistributionUniform,
+			},
+			errContains: "size_spread must be greater than 0 when size_distribution is set",
+		},
+		{
+			name: "SizeDistributionInvalid",
+			config: trafficgen.Config{
+				AgentIDs:         []uuid.UUID{id},
+				BytesPerSecond:   1024,
+				TicksPerSecond:   10,
+				Duration:         httpapi.Duration(time.Minute),
+				SizeDistribution: "bogus",
+			},
+			errContains: `invalid size_distribution: "bogus"`,
+		},
+		{
+			name: "NegativeIdleTimeout",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				IdleTimeout:    httpapi.Duration(-1),
+			},
+			errContains: "idle_timeout must be a positive value",
+		},
+		{
+			name: "NegativeDrainTimeout",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				DrainTimeout:   httpapi.Duration(-1),
+			},
+			errContains: "drain_timeout must be a positive value",
+		},
+		{
+			name: "NegativeKeepaliveInterval",
+			config: trafficgen.Config{
+				AgentIDs:          []uuid.UUID{id},
+				BytesPerSecond:    1024,
+				TicksPerSecond:    10,
+				Duration:          httpapi.Duration(time.Minute),
+				KeepaliveInterval: httpapi.Duration(-1),
+			},
+			errContains: "keepalive_interval must be a positive value",
+		},
+		{
+			name: "NegativeReadBufferSize",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				ReadBufferSize: -1,
+			},
+			errContains: "read_buffer_size must be a positive value",
+		},
+		{
+			name: "NegativeTickJitter",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				TickJitter:     -0.1,
+			},
+			errContains: "tick_jitter must be in [0, 1)",
+		},
+		{
+			name: "TickJitterTooLarge",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				TickJitter:     1,
+			},
+			errContains: "tick_jitter must be in [0, 1)",
+		},
+		{
+			name: "NegativeTotalTicks",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				TotalTicks:     -1,
+			},
+			errContains: "total_ticks must be a positive value",
+		},
+		{
+			name: "NegativeMaxInFlight",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				MaxInFlight:    -1,
+			},
+			errContains: "max_in_flight must be a positive value",
+		},
+		{
+			name: "NegativeKeystrokeDelay",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				KeystrokeDelay: httpapi.Duration(-time.Millisecond),
+			},
+			errContains: "keystroke_delay must be a positive value",
+		},
+		{
+			name: "NegativeLineLength",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				LineLength:     -1,
+			},
+			errContains: "line_length must be a positive value",
+		},
+		{
+			name: "NegativeConnectTimeout",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				ConnectTimeout: httpapi.Duration(-time.Second),
+			},
+			errContains: "connect_timeout must be a positive value",
+		},
+		{
+			name: "NegativeRunTimeout",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				RunTimeout:     httpapi.Duration(-time.Second),
+			},
+			errContains: "run_timeout must be a positive value",
+		},
+		{
+			name: "NegativeThroughputSampleInterval",
+			config: trafficgen.Config{
+				AgentIDs:                 []uuid.UUID{id},
+				BytesPerSecond:           1024,
+				TicksPerSecond:           10,
+				Duration:                 httpapi.Duration(time.Minute),
+				ThroughputSampleInterval: httpapi.Duration(-time.Second),
+			},
+			errContains: "throughput_sample_interval must be a positive value",
+		},
+		{
+			name: "NegativeReadBytesPerSecond",
+			config: trafficgen.Config{
+				AgentIDs:           []uuid.UUID{id},
+				BytesPerSecond:     1024,
+				TicksPerSecond:     10,
+				Duration:           httpapi.Duration(time.Minute),
+				ReadBytesPerSecond: -1,
+			},
+			errContains: "read_bytes_per_second must be a positive value",
+		},
+		{
+			name: "NegativeGracefulShutdownGrace",
+			config: trafficgen.Config{
+				AgentIDs:              []uuid.UUID{id},
+				BytesPerSecond:        1024,
+				TicksPerSecond:        10,
+				Duration:              httpapi.Duration(time.Minute),
+				GracefulShutdownGrace: httpapi.Duration(-time.Second),
+			},
+			errContains: "graceful_shutdown_grace must be a positive value",
+		},
+		{
+			name: "GenerateReadCommandWithoutReadBytesPerSecond",
+			config: trafficgen.Config{
+				AgentIDs:            []uuid.UUID{id},
+				BytesPerSecond:      1024,
+				TicksPerSecond:      10,
+				Duration:            httpapi.Duration(time.Minute),
+				GenerateReadCommand: true,
+			},
+			errContains: "generate_read_command requires read_bytes_per_second to be set",
+		},
+		{
+			name: "NegativeSessionsPerAgent",
+			config: trafficgen.Config{
+				AgentIDs:         []uuid.UUID{id},
+				BytesPerSecond:   1024,
+				TicksPerSecond:   10,
+				Duration:         httpapi.Duration(time.Minute),
+				SessionsPerAgent: -1,
+			},
+			errContains: "sessions_per_agent must be a positive value",
+		},
+		{
+			name: "NegativeLatencyThreshold",
+			config: trafficgen.Config{
+				AgentIDs:         []uuid.UUID{id},
+				BytesPerSecond:   1024,
+				TicksPerSecond:   10,
+				Duration:         httpapi.Duration(time.Minute),
+				LatencyThreshold: httpapi.Duration(-time.Millisecond),
+			},
+			errContains: "latency_threshold must be a positive value",
+		},
+		{
+			name: "LatencyViolationBudgetOutOfRange",
+			config: trafficgen.Config{
+				AgentIDs:               []uuid.UUID{id},
+				BytesPerSecond:         1024,
+				TicksPerSecond:         10,
+				Duration:               httpapi.Duration(time.Minute),
+				LatencyViolationBudget: 1.5,
+			},
+			errContains: "latency_violation_budget must be between 0 and 1",
+		},
+		{
+			name: "NegativeEchoCaptureMaxBytes",
+			config: trafficgen.Config{
+				AgentIDs:            []uuid.UUID{id},
+				BytesPerSecond:      1024,
+				TicksPerSecond:      10,
+				Duration:            httpapi.Duration(time.Minute),
+				EchoCaptureMaxBytes: -1,
+			},
+			errContains: "echo_capture_max_bytes must be a positive value",
+		},
+		{
+			name: "NegativeArtifactEchoSampleMaxBytes",
+			config: trafficgen.Config{
+				AgentIDs:                   []uuid.UUID{id},
+				BytesPerSecond:             1024,
+				TicksPerSecond:             10,
+				Duration:                   httpapi.Duration(time.Minute),
+				ArtifactEchoSampleMaxBytes: -1,
+			},
+			errContains: "artifact_echo_sample_max_bytes must be a positive value",
+		},
+		{
+			name: "NegativeConnectRetries",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				ConnectRetries: -1,
+			},
+			errContains: "connect_retries must be a positive value",
+		},
+		{
+			name: "NegativeConnectRetryBackoffFloor",
+			config: trafficgen.Config{
+				AgentIDs:                 []uuid.UUID{id},
+				BytesPerSecond:           1024,
+				TicksPerSecond:           10,
+				Duration:                 httpapi.Duration(time.Minute),
+				ConnectRetryBackoffFloor: httpapi.Duration(-time.Millisecond),
+			},
+			errContains: "connect_retry_backoff_floor must be a positive value",
+		},
+		{
+			name: "NegativeConnectRetryBackoffCeil",
+			config: trafficgen.Config{
+				AgentIDs:                []uuid.UUID{id},
+				BytesPerSecond:          1024,
+				TicksPerSecond:          10,
+				Duration:                httpapi.Duration(time.Minute),
+				ConnectRetryBackoffCeil: httpapi.Duration(-time.Millisecond),
+			},
+			errContains: "connect_retry_backoff_ceil must be a positive value",
+		},
+		{
+			name: "NegativeLeakCheckGrace",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				LeakCheckGrace: httpapi.Duration(-time.Millisecond),
+			},
+			errContains: "leak_check_grace must be a positive value",
+		},
+		{
+			name: "NonPositiveCommandMixWeight",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				CommandMix: []trafficgen.CommandWeight{
+					{Command: "/bin/sh", Weight: 1},
+					{Command: "top", Weight: 0},
+				},
+			},
+			errContains: "command_mix weights must be greater than 0",
+		},
+		{
+			name: "InvalidPayloadEntropy",
+			config: trafficgen.Config{
+				AgentIDs:       []uuid.UUID{id},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Minute),
+				PayloadEntropy: "bogus",
+			},
+			errContains: `invalid payload_entropy: "bogus"`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := c.config.Validate()
+			if c.errContains != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.errContains)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_NewConfig(t *testing.T) {
+	t.Parallel()
+
+	t.Run("DefaultsAreValid", func(t *testing.T) {
+		t.Parallel()
+
+		id := uuid.New()
+		cfg, err := trafficgen.NewConfig(trafficgen.WithAgentID(id))
+		require.NoError(t, err)
+		require.NoError(t, cfg.Validate())
+		require.Equal(t, []uuid.UUID{id}, cfg.AgentIDs)
+		require.EqualValues(t, trafficgen.DefaultConfigBytesPerSecond, cfg.BytesPerSecond)
+		require.EqualValues(t, trafficgen.DefaultConfigTicksPerSecond, cfg.TicksPerSecond)
+		require.Equal(t, httpapi.Duration(trafficgen.DefaultConfigDuration), cfg.Duration)
+	})
+
+	t.Run("OptionsOverrideDefaults", func(t *testing.T) {
+		t.Parallel()
+
+		cfg, err := trafficgen.NewConfig(
+			trafficgen.WithAgentID(uuid.New()),
+			trafficgen.WithBytesPerSecond(2048),
+			trafficgen.WithTicksPerSecond(20),
+			trafficgen.WithDuration(time.Minute),
+			trafficgen.WithReconnect(true),
+			trafficgen.WithVerifyEcho(true),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 2048, cfg.BytesPerSecond)
+		require.EqualValues(t, 20, cfg.TicksPerSecond)
+		require.Equal(t, httpapi.Duration(time.Minute), cfg.Duration)
+		require.True(t, cfg.Reconnect)
+		require.True(t, cfg.VerifyEcho)
+	})
+
+	t.Run("InvalidOptionsReturnError", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := trafficgen.NewConfig(
+			trafficgen.WithAgentID(uuid.New()),
+			trafficgen.WithBytesPerSecond(-1),
+		)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "bytes_per_second must be greater than 0")
+	})
+}
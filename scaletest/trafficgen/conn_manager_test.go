@@ -0,0 +1,150 @@
+package trafficgen
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+type fakeReadWriter struct {
+	writeErr   error
+	writeDelay time.Duration
+}
+
+func (f *fakeReadWriter) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func (f *fakeReadWriter) Write(p []byte) (int, error) {
+	if f.writeDelay > 0 {
+		time.Sleep(f.writeDelay)
+	}
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+	return len(p), nil
+}
+
+// readableReadWriter always has a byte ready to read, letting a test drive
+// connManager.Read past the zero-byte case fakeReadWriter's Read returns.
+type readableReadWriter struct{}
+
+func (readableReadWriter) Read(p []byte) (int, error) {
+	p[0] = 'x'
+	return 1, nil
+}
+
+func (readableReadWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func Test_connManager(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ReconnectsOnError", func(t *testing.T) {
+		t.Parallel()
+
+		failing := &fakeReadWriter{writeErr: xerrors.New("connection reset")}
+		healthy := &fakeReadWriter{}
+		dials := 0
+
+		cm := newConnManager(context.Background(), failing, func() error { return nil }, true, 0, 0, time.Now(), func(ctx context.Context) (io.ReadWriter, func() error, error) {
+			dials++
+			return healthy, func() error { return nil }, nil
+		})
+
+		n, err := cm.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+		require.Equal(t, 1, dials)
+		require.Equal(t, 1, cm.reconnectCount())
+	})
+
+	t.Run("ReturnsErrorWhenDisabled", func(t *testing.T) {
+		t.Parallel()
+
+		failing := &fakeReadWriter{writeErr: xerrors.New("connection reset")}
+
+		cm := newConnManager(context.Background(), failing, func() error { return nil }, false, 0, 0, time.Now(), func(ctx context.Context) (io.ReadWriter, func() error, error) {
+			t.Fatal("dial should not be called when reconnect is disabled")
+			return nil, nil, nil
+		})
+
+		_, err := cm.Write([]byte("hello"))
+		require.Error(t, err)
+		require.ErrorContains(t, err, "reconnect is disabled")
+	})
+
+	t.Run("ReturnsErrorWhenMaxReconnectsExceeded", func(t *testing.T) {
+		t.Parallel()
+
+		failing := &fakeReadWriter{writeErr: xerrors.New("connection reset")}
+		dials := 0
+
+		cm := newConnManager(context.Background(), failing, func() error { return nil }, true, 1, 0, time.Now(), func(ctx context.Context) (io.ReadWriter, func() error, error) {
+			dials++
+			return failing, func() error { return nil }, nil
+		})
+
+		_, err := cm.Write([]byte("hello"))
+		require.Error(t, err)
+		require.Equal(t, 1, dials)
+
+		_, err = cm.Write([]byte("hello"))
+		require.Error(t, err)
+		require.ErrorContains(t, err, "exceeded max_reconnects")
+		require.Equal(t, 1, dials)
+	})
+
+	t.Run("ReturnsErrorWhenMaxErrorsExceeded", func(t *testing.T) {
+		t.Parallel()
+
+		failing := &fakeReadWriter{writeErr: xerrors.New("connection reset")}
+		dials := 0
+
+		cm := newConnManager(context.Background(), failing, func() error { return nil }, true, 0, 2, time.Now(), func(ctx context.Context) (io.ReadWriter, func() error, error) {
+			dials++
+			return failing, func() error { return nil }, nil
+		})
+
+		for i := 0; i < 2; i++ {
+			_, err := cm.Write([]byte("hello"))
+			require.Error(t, err)
+		}
+		require.Equal(t, 2, dials)
+		require.Equal(t, 2, cm.errorCount())
+		require.False(t, cm.budgetExhausted())
+
+		_, err := cm.Write([]byte("hello"))
+		require.Error(t, err)
+		require.ErrorContains(t, err, "exceeded max_errors")
+		require.Equal(t, 2, dials, "budget exhausted before a third reconnect was attempted")
+		require.Equal(t, 3, cm.errorCount())
+		require.True(t, cm.budgetExhausted())
+	})
+
+	t.Run("TTFBResetsOnReconnect", func(t *testing.T) {
+		t.Parallel()
+
+		failing := &fakeReadWriter{writeErr: xerrors.New("connection reset")}
+		healthy := readableReadWriter{}
+
+		cm := newConnManager(context.Background(), failing, func() error { return nil }, true, 0, 0, time.Now(), func(ctx context.Context) (io.ReadWriter, func() error, error) {
+			return healthy, func() error { return nil }, nil
+		})
+
+		buf := make([]byte, 1)
+		_, err := cm.Read(buf)
+		require.NoError(t, err)
+		require.Len(t, cm.ttfbDurations(), 1, "first read, on the connection reconnect dialed in, produces one sample")
+
+		_, err = cm.Read(buf)
+		require.NoError(t, err)
+		require.Len(t, cm.ttfbDurations(), 1, "a second read on the same still-healthy connection doesn't add another sample")
+
+		require.NoError(t, cm.forceReconnect(context.Background()))
+		_, err = cm.Read(buf)
+		require.NoError(t, err)
+		require.Len(t, cm.ttfbDurations(), 2, "the forced reconnect's new connection gets its own sample")
+	})
+}
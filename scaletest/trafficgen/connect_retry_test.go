@@ -0,0 +1,114 @@
+package trafficgen
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func Test_connectWithRetry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("SucceedsFirstTry", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		rw, closeConn, attempts, err := connectWithRetry(context.Background(), time.Millisecond, time.Millisecond, 3,
+			func() (io.ReadWriter, func() error, error) {
+				calls++
+				return &fakeReadWriter{}, func() error { return nil }, nil
+			}, nil)
+		require.NoError(t, err)
+		require.NotNil(t, rw)
+		require.NotNil(t, closeConn)
+		require.Equal(t, 1, attempts)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("RetriesThenSucceeds", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		var retried []int
+		_, _, attempts, err := connectWithRetry(context.Background(), time.Millisecond, time.Millisecond, 3,
+			func() (io.ReadWriter, func() error, error) {
+				calls++
+				if calls < 3 {
+					return nil, nil, xerrors.New("dial failed")
+				}
+				return &fakeReadWriter{}, func() error { return nil }, nil
+			},
+			func(attempt int, err error) {
+				retried = append(retried, attempt)
+			})
+		require.NoError(t, err)
+		require.Equal(t, 3, attempts)
+		require.Equal(t, 3, calls)
+		require.Equal(t, []int{1, 2}, retried)
+	})
+
+	t.Run("ExhaustsRetries", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		_, _, attempts, err := connectWithRetry(context.Background(), time.Millisecond, time.Millisecond, 2,
+			func() (io.ReadWriter, func() error, error) {
+				calls++
+				return nil, nil, xerrors.New("dial failed")
+			}, nil)
+		require.Error(t, err)
+		require.Equal(t, 3, attempts)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("NoRetriesFailsImmediately", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		_, _, attempts, err := connectWithRetry(context.Background(), time.Millisecond, time.Millisecond, 0,
+			func() (io.ReadWriter, func() error, error) {
+				calls++
+				return nil, nil, xerrors.New("dial failed")
+			}, nil)
+		require.Error(t, err)
+		require.Equal(t, 1, attempts)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("ContextCanceledDuringBackoffStopsRetrying", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		_, _, attempts, err := connectWithRetry(ctx, time.Hour, time.Hour, 5,
+			func() (io.ReadWriter, func() error, error) {
+				calls++
+				cancel()
+				return nil, nil, xerrors.New("dial failed")
+			}, nil)
+		require.Error(t, err)
+		require.Equal(t, 1, attempts)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("BackoffCapsAtCeil", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		start := time.Now()
+		_, _, _, err := connectWithRetry(context.Background(), time.Millisecond, 5*time.Millisecond, 3,
+			func() (io.ReadWriter, func() error, error) {
+				calls++
+				return nil, nil, xerrors.New("dial failed")
+			}, nil)
+		require.Error(t, err)
+		require.Equal(t, 4, calls)
+		// 3 backoff waits (1ms, 2ms, 4ms, each capped at 5ms) should complete
+		// well under a second.
+		require.Less(t, time.Since(start), time.Second)
+	})
+}
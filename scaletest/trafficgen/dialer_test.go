@@ -0,0 +1,857 @@
+package trafficgen_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/codersdk"
+	"github.com/coder/coder/scaletest/trafficgen"
+	"github.com/coder/coder/testutil"
+)
+
+// echoPTYConn is an in-memory stand-in for a reconnecting PTY's net.Conn. It
+// decodes each write as a codersdk.ReconnectingPTYRequest, the same framing
+// reconnectingPTYWriter produces on the wire, and echoes its Data straight
+// back as raw read output, so a trafficgen.Dialer backed by it behaves like
+// a real agent shell echoing its input without needing a live coderd server
+// or agent process.
+type echoPTYConn struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func newEchoPTYConn() *echoPTYConn {
+	r, w := io.Pipe()
+	return &echoPTYConn{r: r, w: w}
+}
+
+func (c *echoPTYConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *echoPTYConn) Write(p []byte) (int, error) {
+	var req codersdk.ReconnectingPTYRequest
+	if err := json.Unmarshal(p, &req); err != nil {
+		return 0, xerrors.Errorf("decode reconnecting pty request: %w", err)
+	}
+	go func() {
+		_, _ = c.w.Write([]byte(req.Data))
+	}()
+	return len(p), nil
+}
+
+func (c *echoPTYConn) Close() error {
+	_ = c.w.Close()
+	return c.r.Close()
+}
+
+// blackHolePTYConn is an in-memory stand-in that accepts writes but never
+// echoes anything back, modeling a connection that stays open but stops
+// producing output, for testing Config.IdleTimeout.
+type blackHolePTYConn struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func newBlackHolePTYConn() *blackHolePTYConn {
+	r, w := io.Pipe()
+	return &blackHolePTYConn{r: r, w: w}
+}
+
+func (c *blackHolePTYConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *blackHolePTYConn) Write(p []byte) (int, error) { return len(p), nil }
+func (c *blackHolePTYConn) Close() error {
+	_ = c.w.Close()
+	return c.r.Close()
+}
+
+// blackHoleDialer implements trafficgen.Dialer, returning a fresh
+// blackHolePTYConn for every Dial call.
+type blackHoleDialer struct{}
+
+func (blackHoleDialer) Dial(_ context.Context, _ codersdk.WorkspaceAgentReconnectingPTYOpts) (io.ReadWriteCloser, error) {
+	return newBlackHolePTYConn(), nil
+}
+
+// delayedEchoPTYConn is an echoPTYConn whose echo of each write lands only
+// after delay, modeling an agent that's slow to produce output - e.g. a
+// command still running when the write loop stops - so a test can tell
+// whether a trailing echo was actually waited for or just missed the close.
+type delayedEchoPTYConn struct {
+	*echoPTYConn
+	delay time.Duration
+}
+
+func newDelayedEchoPTYConn(delay time.Duration) *delayedEchoPTYConn {
+	return &delayedEchoPTYConn{echoPTYConn: newEchoPTYConn(), delay: delay}
+}
+
+func (c *delayedEchoPTYConn) Write(p []byte) (int, error) {
+	var req codersdk.ReconnectingPTYRequest
+	if err := json.Unmarshal(p, &req); err != nil {
+		return 0, xerrors.Errorf("decode reconnecting pty request: %w", err)
+	}
+	go func() {
+		time.Sleep(c.delay)
+		_, _ = c.w.Write([]byte(req.Data))
+	}()
+	return len(p), nil
+}
+
+// delayedEchoDialer implements trafficgen.Dialer, returning a fresh
+// delayedEchoPTYConn for every Dial call.
+type delayedEchoDialer struct {
+	delay time.Duration
+}
+
+func (d delayedEchoDialer) Dial(_ context.Context, _ codersdk.WorkspaceAgentReconnectingPTYOpts) (io.ReadWriteCloser, error) {
+	return newDelayedEchoPTYConn(d.delay), nil
+}
+
+// exitOnSequencePTYConn is an echoPTYConn that closes itself instead of
+// echoing once it sees sequence written to it, modeling a shell that
+// actually terminates - and the reconnecting PTY closing the socket behind
+// it - when told to exit, so GracefulShutdown can observe a clean
+// acknowledgment instead of only ever timing out.
+type exitOnSequencePTYConn struct {
+	*echoPTYConn
+	sequence string
+}
+
+func newExitOnSequencePTYConn(sequence string) *exitOnSequencePTYConn {
+	return &exitOnSequencePTYConn{echoPTYConn: newEchoPTYConn(), sequence: sequence}
+}
+
+func (c *exitOnSequencePTYConn) Write(p []byte) (int, error) {
+	var req codersdk.ReconnectingPTYRequest
+	if err := json.Unmarshal(p, &req); err != nil {
+		return 0, xerrors.Errorf("decode reconnecting pty request: %w", err)
+	}
+	if req.Data == c.sequence {
+		return len(p), c.w.Close()
+	}
+	go func() {
+		_, _ = c.w.Write([]byte(req.Data))
+	}()
+	return len(p), nil
+}
+
+// exitOnSequenceDialer implements trafficgen.Dialer, returning a fresh
+// exitOnSequencePTYConn for every Dial call.
+type exitOnSequenceDialer struct {
+	sequence string
+}
+
+func (d exitOnSequenceDialer) Dial(_ context.Context, _ codersdk.WorkspaceAgentReconnectingPTYOpts) (io.ReadWriteCloser, error) {
+	return newExitOnSequencePTYConn(d.sequence), nil
+}
+
+// fakeDialer implements trafficgen.Dialer, returning a fresh echoPTYConn for
+// every Dial call unless err is set, in which case every call fails with it.
+type fakeDialer struct {
+	mu         sync.Mutex
+	calls      int
+	err        error
+	reconnects []uuid.UUID
+	envs       []map[string]string
+}
+
+func (d *fakeDialer) Dial(_ context.Context, opts codersdk.WorkspaceAgentReconnectingPTYOpts) (io.ReadWriteCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls++
+	d.reconnects = append(d.reconnects, opts.Reconnect)
+	d.envs = append(d.envs, opts.Env)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return newEchoPTYConn(), nil
+}
+
+// flakyOnceConn answers its first Write with success and every Write after
+// that with an error, modeling a connection that degrades right after a
+// reconnect picks it up. Paired with flakyOnceDialer, this keeps
+// connManager redialing a fresh (briefly healthy) connection every couple of
+// writes, driving up its error count without ever going so long without a
+// successful write that the write loop itself gives up.
+type flakyOnceConn struct {
+	calls int
+}
+
+func (c *flakyOnceConn) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func (c *flakyOnceConn) Write(p []byte) (int, error) {
+	c.calls++
+	if c.calls == 1 {
+		return len(p), nil
+	}
+	return 0, xerrors.New("write failed")
+}
+
+func (c *flakyOnceConn) Close() error { return nil }
+
+// flakyOnceDialer implements trafficgen.Dialer, returning a fresh
+// flakyOnceConn for every Dial call.
+type flakyOnceDialer struct{}
+
+func (flakyOnceDialer) Dial(_ context.Context, _ codersdk.WorkspaceAgentReconnectingPTYOpts) (io.ReadWriteCloser, error) {
+	return &flakyOnceConn{}, nil
+}
+
+// faultInjectingReadWriter wraps an io.ReadWriter, failing every Write from
+// the (failAfter+1)'th one onward with err, for Config.FaultInjector tests
+// that need a write to fail deterministically after some amount of
+// successful traffic rather than on the very first one. Reads pass through
+// unmodified.
+type faultInjectingReadWriter struct {
+	io.ReadWriter
+	failAfter int
+	err       error
+
+	writes int
+}
+
+func (w *faultInjectingReadWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > w.failAfter {
+		return 0, w.err
+	}
+	return w.ReadWriter.Write(p)
+}
+
+func Test_Runner_Dialer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("EchoesOverInMemoryPipe", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(200 * time.Millisecond),
+			Dialer:         &fakeDialer{},
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Greater(t, res.BytesSent, uint64(0))
+		require.Greater(t, res.BytesRcvd, uint64(0))
+	})
+
+	t.Run("EnvDefaultsTERM", func(t *testing.T) {
+		t.Parallel()
+
+		dialer := &fakeDialer{}
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(100 * time.Millisecond),
+			Dialer:         dialer,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		require.NotEmpty(t, dialer.envs)
+		require.Equal(t, trafficgen.DefaultTERM, dialer.envs[0]["TERM"])
+	})
+
+	t.Run("EnvOverridesTERM", func(t *testing.T) {
+		t.Parallel()
+
+		dialer := &fakeDialer{}
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(100 * time.Millisecond),
+			Dialer:         dialer,
+			Env:            map[string]string{"TERM": "dumb", "COLORTERM": "truecolor"},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		require.NotEmpty(t, dialer.envs)
+		require.Equal(t, "dumb", dialer.envs[0]["TERM"])
+		require.Equal(t, "truecolor", dialer.envs[0]["COLORTERM"])
+	})
+
+	t.Run("LabelsPropagateToResultsAndCSV", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		csvOut := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(100 * time.Millisecond),
+			Dialer:         &fakeDialer{},
+			MetricsWriter:  metrics,
+			CSVWriter:      csvOut,
+			Labels:         map[string]string{"region": "us-east", "az": "a"},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Equal(t, map[string]string{"region": "us-east", "az": "a"}, res.Labels)
+
+		require.Contains(t, csvOut.String(), "az=a,region=us-east")
+	})
+
+	t.Run("SetupCommandsExcludedFromResults", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		echoCapture := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:         []uuid.UUID{uuid.New()},
+			BytesPerSecond:   64,
+			TicksPerSecond:   5,
+			Duration:         httpapi.Duration(200 * time.Millisecond),
+			Dialer:           &fakeDialer{},
+			MetricsWriter:    metrics,
+			SetupCommands:    []string{"echo setup-one", "echo setup-two"},
+			SetupSettleDelay: httpapi.Duration(20 * time.Millisecond),
+			EchoCapture:      echoCapture,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+
+		captured := echoCapture.String()
+		require.Contains(t, captured, "setup-one")
+		require.Contains(t, captured, "setup-two")
+		// SetupCommands' own bytes were sent and echoed back (captured
+		// above), but must not count toward Results, even though the
+		// measured phase barely sends anything in 200ms at 64 bytes/s.
+		require.Less(t, res.BytesSent, uint64(len(captured)))
+	})
+
+	t.Run("ScriptWaitForMarkerReportsCompletionLatency", func(t *testing.T) {
+		t.Parallel()
+
+		scriptPath := filepath.Join(t.TempDir(), "script.txt")
+		require.NoError(t, os.WriteFile(scriptPath, []byte("echo cmd-one\necho cmd-two\n"), 0o600))
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:            []uuid.UUID{uuid.New()},
+			BytesPerSecond:      1024,
+			TicksPerSecond:      10,
+			Duration:            httpapi.Duration(100 * time.Millisecond),
+			Dialer:              &fakeDialer{},
+			MetricsWriter:       metrics,
+			ScriptPath:          scriptPath,
+			ScriptWaitForMarker: true,
+			MarkerTimeout:       httpapi.Duration(testutil.WaitShort),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Greater(t, res.ScriptCommandSamples, 0)
+		require.GreaterOrEqual(t, res.ScriptCommandP50, time.Duration(0))
+	})
+
+	t.Run("ArtifactDirWritesPerAgentArtifacts", func(t *testing.T) {
+		t.Parallel()
+
+		artifactDir := t.TempDir()
+		agentID := uuid.New()
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(100 * time.Millisecond),
+			Dialer:         &fakeDialer{},
+			ArtifactDir:    artifactDir,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		entries, err := os.ReadDir(artifactDir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Contains(t, entries[0].Name(), agentID.String())
+
+		sessionDir := filepath.Join(artifactDir, entries[0].Name())
+		for _, name := range []string{"config.json", "results.json", "echo.sample", "latency.csv"} {
+			_, err := os.Stat(filepath.Join(sessionDir, name))
+			require.NoError(t, err, "expected %s to exist", name)
+		}
+	})
+
+	t.Run("GracefulShutdownExitedCleanly", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:              []uuid.UUID{uuid.New()},
+			BytesPerSecond:        1024,
+			TicksPerSecond:        10,
+			Duration:              httpapi.Duration(100 * time.Millisecond),
+			Dialer:                exitOnSequenceDialer{sequence: "\nexit\n"},
+			MetricsWriter:         metrics,
+			GracefulShutdown:      true,
+			GracefulShutdownGrace: httpapi.Duration(testutil.WaitShort),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Equal(t, 1, res.GracefulExits)
+		require.Equal(t, 0, res.ForcedExits)
+	})
+
+	t.Run("GracefulShutdownForcedWhenUnacknowledged", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:              []uuid.UUID{uuid.New()},
+			BytesPerSecond:        1024,
+			TicksPerSecond:        10,
+			Duration:              httpapi.Duration(100 * time.Millisecond),
+			Dialer:                &fakeDialer{},
+			MetricsWriter:         metrics,
+			GracefulShutdown:      true,
+			GracefulShutdownGrace: httpapi.Duration(20 * time.Millisecond),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Equal(t, 0, res.GracefulExits)
+		require.Equal(t, 1, res.ForcedExits)
+	})
+
+	t.Run("DialErrorPropagates", func(t *testing.T) {
+		t.Parallel()
+
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			Dialer:         &fakeDialer{err: xerrors.New("dial boom")},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "dial boom")
+	})
+
+	t.Run("IdleTimeoutDetectsStalledConnection", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(testutil.WaitSuperLong),
+			IdleTimeout:    httpapi.Duration(50 * time.Millisecond),
+			Dialer:         blackHoleDialer{},
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		start := time.Now()
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), testutil.WaitSuperLong)
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Equal(t, trafficgen.ReasonStalled, res.Reason)
+	})
+
+	t.Run("RunTimeoutBoundsDeadline", func(t *testing.T) {
+		t.Parallel()
+
+		dialer := &fakeDialer{}
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(testutil.WaitSuperLong),
+			RunTimeout:     httpapi.Duration(100 * time.Millisecond),
+			Dialer:         dialer,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		start := time.Now()
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), testutil.WaitLong)
+
+		dialer.mu.Lock()
+		defer dialer.mu.Unlock()
+		require.Equal(t, 1, dialer.calls)
+	})
+
+	t.Run("SteadyStateDetectsConvergence", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:               []uuid.UUID{uuid.New()},
+			BytesPerSecond:         4096,
+			TicksPerSecond:         20,
+			Duration:               httpapi.Duration(100 * time.Millisecond),
+			SteadyState:            true,
+			SteadyStateWindow:      httpapi.Duration(50 * time.Millisecond),
+			SteadyStateEpsilon:     1.0,
+			SteadyStateMaxDuration: httpapi.Duration(testutil.WaitSuperLong),
+			Dialer:                 &fakeDialer{},
+			MetricsWriter:          metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		start := time.Now()
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), testutil.WaitSuperLong)
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.True(t, res.SteadyStateReached)
+		require.Equal(t, trafficgen.ReasonSteadyState, res.Reason)
+		require.Greater(t, res.SteadyStateConvergedAfter, time.Duration(0))
+	})
+
+	t.Run("SteadyStateMaxDurationReachedWithoutConvergence", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:               []uuid.UUID{uuid.New()},
+			BytesPerSecond:         2048,
+			TicksPerSecond:         10,
+			Duration:               httpapi.Duration(50 * time.Millisecond),
+			SteadyState:            true,
+			SteadyStateWindow:      httpapi.Duration(20 * time.Millisecond),
+			SteadyStateEpsilon:     0.0000001,
+			SteadyStateMaxDuration: httpapi.Duration(150 * time.Millisecond),
+			Dialer:                 &fakeDialer{},
+			MetricsWriter:          metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		start := time.Now()
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+		require.Less(t, time.Since(start), testutil.WaitSuperLong)
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.False(t, res.SteadyStateReached)
+		require.Equal(t, trafficgen.ReasonDeadline, res.Reason)
+	})
+
+	t.Run("ReconnectTokenReused", func(t *testing.T) {
+		t.Parallel()
+
+		token := uuid.New()
+		dialer := &fakeDialer{}
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(100 * time.Millisecond),
+			ReconnectToken: token,
+			Dialer:         dialer,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		// Run again against the same dialer with the same token, modeling a
+		// second invocation reattaching to the session the first left open.
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		dialer.mu.Lock()
+		defer dialer.mu.Unlock()
+		require.Len(t, dialer.reconnects, 2)
+		require.Equal(t, token, dialer.reconnects[0])
+		require.Equal(t, token, dialer.reconnects[1])
+	})
+
+	t.Run("MaxErrorsExhausted", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(testutil.WaitSuperLong),
+			Reconnect:      true,
+			MaxErrors:      2,
+			Dialer:         flakyOnceDialer{},
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "exceeded max_errors")
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.True(t, res.ErrorBudgetExhausted)
+		require.Greater(t, res.TotalErrors, 0)
+	})
+
+	t.Run("FaultInjectorWriteErrorTriggersReconnect", func(t *testing.T) {
+		t.Parallel()
+
+		var injectorCalls int
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(300 * time.Millisecond),
+			Reconnect:      true,
+			MaxErrors:      1000,
+			Dialer:         &fakeDialer{},
+			FaultInjector: func(rw io.ReadWriter) io.ReadWriter {
+				injectorCalls++
+				return &faultInjectingReadWriter{ReadWriter: rw, failAfter: 1, err: xerrors.New("injected write fault")}
+			},
+			MetricsWriter: metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Greater(t, res.TotalErrors, 0)
+		require.Greater(t, injectorCalls, 1, "FaultInjector should be re-applied on every reconnect, not just the initial dial")
+	})
+
+	t.Run("FaultInjectorExhaustsErrorBudget", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(testutil.WaitSuperLong),
+			Reconnect:      true,
+			MaxErrors:      2,
+			Dialer:         &fakeDialer{},
+			FaultInjector: func(rw io.ReadWriter) io.ReadWriter {
+				// Fails every write but the first on each connection, so
+				// connManager's post-reconnect retry succeeds and errors
+				// accumulate across reconnects instead of the raw write
+				// error surfacing on the very first failure.
+				return &faultInjectingReadWriter{ReadWriter: rw, failAfter: 1, err: xerrors.New("injected write fault")}
+			},
+			MetricsWriter: metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "exceeded max_errors")
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.True(t, res.ErrorBudgetExhausted)
+	})
+
+	t.Run("DrainTimeoutRecoversTrailingEcho", func(t *testing.T) {
+		t.Parallel()
+
+		// Two ticks fire during the 250ms run (at ~100ms and ~200ms), each
+		// echoed back only after a 300ms delay - well after the write loop
+		// stops at 250ms - modeling echoes still in flight when writing
+		// ends. Without a drain grace period, the connection closes right
+		// at 250ms and both echoes are missed entirely.
+		cfg := trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 100,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(250 * time.Millisecond),
+			Dialer:         delayedEchoDialer{delay: 300 * time.Millisecond},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		withoutDrain := bytes.NewBuffer(nil)
+		cfg.MetricsWriter = withoutDrain
+		require.NoError(t, trafficgen.NewRunner(&codersdk.Client{}, cfg).Run(ctx, "1", io.Discard))
+
+		var resWithoutDrain trafficgen.Results
+		require.NoError(t, json.Unmarshal(withoutDrain.Bytes(), &resWithoutDrain))
+		require.Zero(t, resWithoutDrain.BytesRcvd, "trailing echoes should be missed without a drain grace period")
+
+		cfg.DrainTimeout = httpapi.Duration(300 * time.Millisecond)
+		withDrain := bytes.NewBuffer(nil)
+		cfg.MetricsWriter = withDrain
+		require.NoError(t, trafficgen.NewRunner(&codersdk.Client{}, cfg).Run(ctx, "1", io.Discard))
+
+		var resWithDrain trafficgen.Results
+		require.NoError(t, json.Unmarshal(withDrain.Bytes(), &resWithDrain))
+		require.Greater(t, resWithDrain.BytesRcvd, uint64(0), "drain grace period should recover the trailing echoes")
+	})
+
+	t.Run("KeepaliveRTTReportedSeparatelyFromLatency", func(t *testing.T) {
+		t.Parallel()
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:          []uuid.UUID{uuid.New()},
+			BytesPerSecond:    2048,
+			TicksPerSecond:    10,
+			Duration:          httpapi.Duration(250 * time.Millisecond),
+			KeepaliveInterval: httpapi.Duration(50 * time.Millisecond),
+			Dialer:            &fakeDialer{},
+			MetricsWriter:     metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Greater(t, res.LatencySamples, 0)
+		require.Greater(t, res.KeepaliveSamples, 0, "keepalive pings sent every 50ms during a 250ms run should be timed")
+	})
+
+	t.Run("ReplayFileLoops", func(t *testing.T) {
+		t.Parallel()
+
+		replayPath := filepath.Join(t.TempDir(), "replay.jsonl")
+		replay := `{"delay_ms": 0, "data": "hello\n"}
+{"delay_ms": 10, "data": "world\n"}
+`
+		require.NoError(t, os.WriteFile(replayPath, []byte(replay), 0o600))
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(100 * time.Millisecond),
+			ReplayFile:     replayPath,
+			ReplayLoop:     true,
+			Dialer:         &fakeDialer{},
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		// "hello\n" + "world\n" is 12 bytes; looping for 100ms should send it
+		// several times over.
+		require.Greater(t, res.BytesSent, uint64(12), "ReplayLoop should replay the capture more than once during the run")
+	})
+
+	t.Run("ReconnectTokenDefaultsToFreshPerRun", func(t *testing.T) {
+		t.Parallel()
+
+		dialer := &fakeDialer{}
+		runner := trafficgen.NewRunner(&codersdk.Client{}, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(100 * time.Millisecond),
+			Dialer:         dialer,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+		require.NoError(t, runner.Run(ctx, "1", io.Discard))
+
+		dialer.mu.Lock()
+		defer dialer.mu.Unlock()
+		require.Len(t, dialer.reconnects, 2)
+		require.NotEqual(t, dialer.reconnects[0], dialer.reconnects[1])
+	})
+}
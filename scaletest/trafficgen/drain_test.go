@@ -0,0 +1,258 @@
+package trafficgen
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/coder/testutil"
+)
+
+// capTrackingReader records the capacity of every buffer passed to Read, so
+// tests can confirm a caller is reusing a single fixed-size buffer rather
+// than handing it a new, larger one over time. It returns io.EOF once reads
+// have been called, so the background goroutine inside drainContext always
+// terminates instead of spinning forever.
+type capTrackingReader struct {
+	mu    sync.Mutex
+	caps  []int
+	reads int
+}
+
+func (r *capTrackingReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reads++
+	r.caps = append(r.caps, cap(p))
+	if r.reads >= 100 {
+		return len(p), io.EOF
+	}
+	return len(p), nil
+}
+
+func Test_drainContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ReusesFixedBuffer", func(t *testing.T) {
+		t.Parallel()
+
+		r := &capTrackingReader{}
+		err := drainContext(context.Background(), r, 4096, nil, nil, 0)
+		require.ErrorIs(t, err, io.EOF)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		require.NotEmpty(t, r.caps)
+		for _, c := range r.caps {
+			require.Equal(t, 4096, c)
+		}
+	})
+
+	t.Run("ReturnsReadError", func(t *testing.T) {
+		t.Parallel()
+
+		err := drainContext(context.Background(), errReader{io.ErrClosedPipe}, 4096, nil, nil, 0)
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+	})
+
+	t.Run("TeesToCapture", func(t *testing.T) {
+		t.Parallel()
+
+		r := &scriptedReader{chunks: [][]byte{[]byte("hello "), []byte("world")}, err: io.EOF}
+		var capture bytes.Buffer
+		err := drainContext(context.Background(), r, 4096, nil, &capture, 0)
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, "hello world", capture.String())
+	})
+
+	t.Run("IdleTimeoutEndsReadLoop", func(t *testing.T) {
+		t.Parallel()
+
+		r := &blockingReader{unblock: make(chan struct{}), err: io.ErrClosedPipe}
+		defer close(r.unblock)
+
+		err := drainContext(context.Background(), r, 4096, nil, nil, 10*time.Millisecond)
+		require.ErrorIs(t, err, errReadIdleTimeout)
+	})
+
+	t.Run("IdleTimeoutResetsOnEveryRead", func(t *testing.T) {
+		t.Parallel()
+
+		r := &slowScriptedReader{
+			chunks: [][]byte{[]byte("a"), []byte("b"), []byte("c")},
+			delay:  20 * time.Millisecond,
+			err:    io.EOF,
+		}
+		err := drainContext(context.Background(), r, 4096, nil, nil, 50*time.Millisecond)
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("DeadlineExceededIsCleanStop", func(t *testing.T) {
+		t.Parallel()
+
+		r := &blockingReader{unblock: make(chan struct{}), err: io.ErrClosedPipe}
+		defer close(r.unblock)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+
+		err := drainContext(ctx, r, 4096, nil, nil, 0)
+		require.NoError(t, err)
+	})
+
+	t.Run("CanceledIsPropagated", func(t *testing.T) {
+		t.Parallel()
+
+		r := &blockingReader{unblock: make(chan struct{}), err: io.ErrClosedPipe}
+		defer close(r.unblock)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := drainContext(ctx, r, 4096, nil, nil, 0)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// slowScriptedReader is like scriptedReader, but sleeps for delay before
+// returning each chunk, so tests can confirm an idle timeout is reset by
+// each read rather than only checked once at the start.
+type slowScriptedReader struct {
+	mu     sync.Mutex
+	chunks [][]byte
+	delay  time.Duration
+	err    error
+}
+
+func (r *slowScriptedReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.chunks) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func Test_cappedWriter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("PassesThroughUnderCap", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		cw := newCappedWriter(&buf, 100)
+		n, err := cw.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+		require.Equal(t, "hello", buf.String())
+		require.False(t, cw.truncated)
+	})
+
+	t.Run("TruncatesAtCap", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		cw := newCappedWriter(&buf, 5)
+		n, err := cw.Write([]byte("hello world"))
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+		require.Equal(t, "hello", buf.String())
+		require.True(t, cw.truncated)
+	})
+
+	t.Run("DropsWritesAfterCapReached", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		cw := newCappedWriter(&buf, 5)
+		_, err := cw.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.False(t, cw.truncated)
+
+		n, err := cw.Write([]byte("world"))
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+		require.Equal(t, "hello", buf.String())
+		require.True(t, cw.truncated)
+	})
+}
+
+// scriptedReader returns each of chunks on successive calls to Read, then
+// fails with err once they're exhausted.
+type scriptedReader struct {
+	mu     sync.Mutex
+	chunks [][]byte
+	err    error
+}
+
+func (r *scriptedReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.chunks) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+// errReader is an io.Reader that always fails with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// blockingReader blocks in Read until unblock is closed, then returns err.
+// It models a connection whose Read is still in flight when ctx is
+// canceled, since Read has no way to be interrupted directly.
+type blockingReader struct {
+	unblock chan struct{}
+	err     error
+}
+
+func (r *blockingReader) Read([]byte) (int, error) {
+	<-r.unblock
+	return 0, r.err
+}
+
+func Test_drainContext_readExited(t *testing.T) {
+	t.Parallel()
+
+	r := &blockingReader{unblock: make(chan struct{}), err: io.ErrClosedPipe}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancel()
+
+	readExited := make(chan struct{})
+	err := drainContext(ctx, r, 4096, readExited, nil, 0)
+	require.ErrorIs(t, err, context.Canceled)
+
+	select {
+	case <-readExited:
+		t.Fatal("readExited closed before the blocked Read returned")
+	default:
+	}
+
+	close(r.unblock)
+
+	select {
+	case <-readExited:
+	case <-time.After(testutil.WaitShort):
+		t.Fatal("readExited never closed after the blocked Read returned")
+	}
+}
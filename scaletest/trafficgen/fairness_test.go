@@ -0,0 +1,33 @@
+package trafficgen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_coefficientOfVariation(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{name: "Empty", values: nil, want: 0},
+		{name: "SingleValue", values: []float64{42}, want: 0},
+		{name: "Identical", values: []float64{10, 10, 10}, want: 0},
+		{name: "ZeroMean", values: []float64{0, 0}, want: 0},
+		{name: "Uneven", values: []float64{0, 100}, want: 1},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.InDelta(t, c.want, coefficientOfVariation(c.values), 0.0001)
+		})
+	}
+}
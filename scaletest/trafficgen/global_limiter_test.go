@@ -0,0 +1,67 @@
+package trafficgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/coder/coder/testutil"
+)
+
+func Test_waitForGlobalLimiter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NilLimiterReturnsImmediately", func(t *testing.T) {
+		t.Parallel()
+
+		start := time.Now()
+		err := waitForGlobalLimiter(context.Background(), nil, 1<<20)
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("WaitsForReservation", func(t *testing.T) {
+		t.Parallel()
+
+		limiter := rate.NewLimiter(rate.Limit(100), 10)
+		require.True(t, limiter.AllowN(time.Now(), 10))
+
+		start := time.Now()
+		err := waitForGlobalLimiter(context.Background(), limiter, 10)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	})
+
+	t.Run("CanceledContextReturnsError", func(t *testing.T) {
+		t.Parallel()
+
+		limiter := rate.NewLimiter(rate.Limit(1), 10)
+		require.True(t, limiter.AllowN(time.Now(), 10))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := waitForGlobalLimiter(ctx, limiter, 10)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("ReservationLargerThanBurstIsChunked", func(t *testing.T) {
+		t.Parallel()
+
+		// Burst is only 1, far smaller than the 10 bytes requested in a
+		// single call: without chunking, ReserveN(now, 10) would return a
+		// reservation that can never be satisfied, and waitForGlobalLimiter
+		// would block forever instead of draining it 1 byte at a time.
+		limiter := rate.NewLimiter(rate.Limit(1000), 1)
+		require.True(t, limiter.AllowN(time.Now(), 1))
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitShort)
+		defer cancel()
+
+		err := waitForGlobalLimiter(ctx, limiter, 10)
+		require.NoError(t, err)
+	})
+}
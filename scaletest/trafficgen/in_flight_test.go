@@ -0,0 +1,109 @@
+package trafficgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInFlightObserver implements inFlightObserver, reporting a fixed
+// pendingBytes count that a test can mutate while waitForInFlightCapacity is
+// blocked on it.
+type fakeInFlightObserver struct {
+	pending int64
+}
+
+func (f *fakeInFlightObserver) pendingBytes() int64 { return f.pending }
+
+type fakeWriterWithObserver struct {
+	fakeReadWriter
+	*fakeInFlightObserver
+}
+
+func Test_waitForInFlightCapacity(t *testing.T) {
+	t.Parallel()
+
+	t.Run("DisabledWhenMaxInFlightIsZero", func(t *testing.T) {
+		t.Parallel()
+
+		rw := &fakeWriterWithObserver{fakeInFlightObserver: &fakeInFlightObserver{pending: 1000}}
+		throttled, err := waitForInFlightCapacity(context.Background(), rw, 0)
+		require.NoError(t, err)
+		require.False(t, throttled)
+	})
+
+	t.Run("DisabledWhenRWDoesNotImplementObserver", func(t *testing.T) {
+		t.Parallel()
+
+		rw := &fakeReadWriter{}
+		throttled, err := waitForInFlightCapacity(context.Background(), rw, 1)
+		require.NoError(t, err)
+		require.False(t, throttled)
+	})
+
+	t.Run("ReturnsImmediatelyWhenUnderCap", func(t *testing.T) {
+		t.Parallel()
+
+		rw := &fakeWriterWithObserver{fakeInFlightObserver: &fakeInFlightObserver{pending: 10}}
+		throttled, err := waitForInFlightCapacity(context.Background(), rw, 100)
+		require.NoError(t, err)
+		require.False(t, throttled)
+	})
+
+	t.Run("BlocksUntilPendingDrops", func(t *testing.T) {
+		t.Parallel()
+
+		observer := &fakeInFlightObserver{pending: 100}
+		rw := &fakeWriterWithObserver{fakeInFlightObserver: observer}
+
+		done := make(chan struct{})
+		var throttled bool
+		var err error
+		go func() {
+			throttled, err = waitForInFlightCapacity(context.Background(), rw, 100)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("waitForInFlightCapacity returned before pending dropped below the cap")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		observer.pending = 0
+		<-done
+		require.NoError(t, err)
+		require.True(t, throttled)
+	})
+
+	t.Run("ReturnsContextErrorWhenCtxDoneWhileBlocked", func(t *testing.T) {
+		t.Parallel()
+
+		rw := &fakeWriterWithObserver{fakeInFlightObserver: &fakeInFlightObserver{pending: 100}}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+
+		throttled, err := waitForInFlightCapacity(ctx, rw, 100)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.True(t, throttled)
+	})
+}
+
+func Test_countReadWriter_pendingBytes(t *testing.T) {
+	t.Parallel()
+
+	rw := &fakeReadWriter{}
+	crw := newCountReadWriter(rw, nil, 0, time.Now(), time.Second, false)
+
+	require.Zero(t, crw.pendingBytes())
+
+	_, err := crw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.EqualValues(t, 5, crw.pendingBytes())
+
+	_, err = crw.Write([]byte("!!"))
+	require.NoError(t, err)
+	require.EqualValues(t, 7, crw.pendingBytes())
+}
@@ -0,0 +1,98 @@
+package trafficgen
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingWriter records the length of every Write call and when it
+// happened, so tests can confirm writeRandomData's keystroke delay path
+// writes one byte at a time with a gap between each write.
+type recordingWriter struct {
+	buf   bytes.Buffer
+	lens  []int
+	times []time.Time
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.lens = append(w.lens, len(p))
+	w.times = append(w.times, time.Now())
+	return w.buf.Write(p)
+}
+
+func Test_writeRandomData_keystrokeDelay(t *testing.T) {
+	t.Parallel()
+
+	genPayload := newPayloadGenerator(1, "#", PayloadEntropyRepeated, nil)
+
+	t.Run("ZeroWritesInOneCall", func(t *testing.T) {
+		t.Parallel()
+
+		w := &recordingWriter{}
+		err := writeRandomData(context.Background(), w, 10, genPayload, nil, 0, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, []int{10}, w.lens)
+	})
+
+	t.Run("PositiveWritesOneByteAtATime", func(t *testing.T) {
+		t.Parallel()
+
+		const delay = 5 * time.Millisecond
+
+		w := &recordingWriter{}
+		err := writeRandomData(context.Background(), w, 5, genPayload, nil, delay, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, []int{1, 1, 1, 1, 1}, w.lens)
+		require.Equal(t, 5, w.buf.Len())
+
+		for i := 1; i < len(w.times); i++ {
+			require.GreaterOrEqual(t, w.times[i].Sub(w.times[i-1]), delay)
+		}
+	})
+
+	t.Run("ContextCanceledStopsPartway", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		w := &recordingWriter{}
+
+		go func() {
+			time.Sleep(15 * time.Millisecond)
+			cancel()
+		}()
+
+		err := writeRandomData(ctx, w, 1000, genPayload, nil, 10*time.Millisecond, 0, 0)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Less(t, len(w.lens), 1000)
+	})
+}
+
+func Test_writeRandomData_lineLength(t *testing.T) {
+	t.Parallel()
+
+	genPayload := newPayloadGenerator(1, "#", PayloadEntropyRepeated, nil)
+
+	t.Run("SplitsIntoMultipleLines", func(t *testing.T) {
+		t.Parallel()
+
+		w := &recordingWriter{}
+		err := writeRandomData(context.Background(), w, 25, genPayload, nil, 0, 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []int{10, 10, 5}, w.lens)
+		require.Equal(t, 25, w.buf.Len())
+		require.Equal(t, 2, bytes.Count(w.buf.Bytes()[:20], []byte("\n")))
+	})
+
+	t.Run("LineLengthAtLeastNWritesOneCall", func(t *testing.T) {
+		t.Parallel()
+
+		w := &recordingWriter{}
+		err := writeRandomData(context.Background(), w, 10, genPayload, nil, 0, 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []int{10}, w.lens)
+	})
+}
@@ -0,0 +1,576 @@
+package trafficgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/xerrors"
+)
+
+// resultsSchemaVersion is bumped whenever the shape of Results changes in a
+// backwards-incompatible way, so that consumers scraping MetricsWriter can
+// detect format changes.
+const resultsSchemaVersion = 1
+
+// Reason categorizes why a run ended, so callers can branch on it instead of
+// string-matching Results.Error.
+type Reason string
+
+const (
+	// ReasonDeadline means every agent stopped because Config.Duration (or
+	// the run's context) elapsed with no error.
+	ReasonDeadline Reason = "deadline"
+	// ReasonMaxBytes means Config.MaxBytes was reached before the deadline,
+	// on at least one agent, and no agent errored.
+	ReasonMaxBytes Reason = "max_bytes"
+	// ReasonEOF means the agent closed the connection (a clean read EOF)
+	// before the deadline or MaxBytes was reached, and no agent errored.
+	ReasonEOF Reason = "eof"
+	// ReasonStalled means Config.IdleTimeout is set and at least one agent's
+	// connection stopped echoing data for that long before the deadline,
+	// without closing outright. This is distinct from ReasonEOF: the
+	// connection stayed open, it just went quiet.
+	ReasonStalled Reason = "stalled"
+	// ReasonError means at least one agent returned an error; see Error for
+	// details.
+	ReasonError Reason = "error"
+	// ReasonCanceled means at least one agent's connection was interrupted by
+	// context cancellation before Config.Duration elapsed - typically another
+	// agent failing with Config.FailFast set - rather than stopping cleanly
+	// on its own. This is distinct from ReasonDeadline, which means the same
+	// context ended because its deadline was reached rather than because
+	// something canceled it early.
+	ReasonCanceled Reason = "canceled"
+	// ReasonSteadyState means Config.SteadyState detected that throughput had
+	// converged and stopped the run before Config.SteadyStateMaxDuration was
+	// reached. See Results.SteadyStateReached.
+	ReasonSteadyState Reason = "steady_state"
+)
+
+// Results is the machine-readable summary of a completed run, written as a
+// single JSON object to Config.MetricsWriter if set.
+type Results struct {
+	SchemaVersion int `json:"schema_version"`
+
+	// TraceID is the trace ID of the span Run started for this run, so
+	// Results posted to Config.ResultsEndpoint (or written to
+	// Config.MetricsWriter) can be correlated with the corresponding trace.
+	// Empty if tracing isn't configured.
+	TraceID string `json:"trace_id,omitempty"`
+
+	// Labels is a copy of Config.Labels, repeated here so downstream
+	// analysis can group and filter by them straight from this JSON object
+	// rather than having to correlate it back to the Config that produced
+	// it. Omitted entirely when Config.Labels is unset.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Reason categorizes why the run ended. See the Reason* constants.
+	Reason Reason `json:"reason"`
+
+	Duration time.Duration `json:"duration"`
+
+	BytesSent uint64 `json:"bytes_sent"`
+	BytesRcvd uint64 `json:"bytes_rcvd"`
+
+	// WireBytesSent is the total bytes actually put on the wire to send
+	// BytesSent worth of payload, including protocol framing (for
+	// ConnectionTypePTY, every write is wrapped in a JSON-encoded
+	// codersdk.ReconnectingPTYRequest). Always >= BytesSent; the gap between
+	// them is reported as FramingOverheadRatio. There's no equivalent for
+	// reads, since echoed output is passed through unmodified.
+	WireBytesSent uint64 `json:"wire_bytes_sent"`
+	// FramingOverheadRatio is (WireBytesSent - BytesSent) / BytesSent, so
+	// 0.25 means framing inflated the true application-level data rate by
+	// 25% on the wire. Zero if BytesSent is zero.
+	FramingOverheadRatio float64 `json:"framing_overhead_ratio"`
+
+	// MessagesSent is the number of discrete Write calls made across every
+	// agent (one codersdk.ReconnectingPTYRequest JSON message per write for
+	// ConnectionTypePTY, one raw stdin write for ConnectionTypeSSH).
+	// MessagesPerSecond reports this alongside ThroughputSent so a high
+	// TicksPerSecond that dominates the wire with small-message overhead
+	// shows up directly, rather than only as FramingOverheadRatio.
+	MessagesSent uint64 `json:"messages_sent"`
+	// FramesRcvd is the number of discrete Read calls that returned data
+	// across every agent. FramesPerSecond reports this alongside
+	// ThroughputRcvd the same way MessagesPerSecond does for writes.
+	FramesRcvd uint64 `json:"frames_rcvd"`
+	// MessagesPerSecond is MessagesSent divided by Duration.
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	// FramesPerSecond is FramesRcvd divided by Duration.
+	FramesPerSecond float64 `json:"frames_per_second"`
+
+	// ThroughputSent is BytesSent divided by Duration, in bytes/second.
+	ThroughputSent float64 `json:"throughput_sent"`
+	// WireThroughputSent is WireBytesSent divided by Duration, in
+	// bytes/second, reflecting the actual send rate on the wire rather than
+	// the intended application-level rate. See FramingOverheadRatio.
+	WireThroughputSent float64 `json:"wire_throughput_sent"`
+	// ThroughputRcvd is BytesRcvd divided by Duration, in bytes/second.
+	ThroughputRcvd float64 `json:"throughput_rcvd"`
+	// ThroughputAchievedRatio is ThroughputSent divided by the configured
+	// target BytesPerSecond, so that 1.0 means the target was fully
+	// sustained and values below 1.0 indicate the agent couldn't keep up.
+	ThroughputAchievedRatio float64 `json:"throughput_achieved_ratio"`
+	// ReadThroughputAchievedRatio is ThroughputRcvd divided by the configured
+	// target Config.ReadBytesPerSecond. Zero unless ReadBytesPerSecond was
+	// set, since there's no target to compare against otherwise.
+	ReadThroughputAchievedRatio float64 `json:"read_throughput_achieved_ratio,omitempty"`
+	// ThroughputSentP5, ThroughputSentP50, and ThroughputSentP95 are
+	// percentiles of the per-window send rate across every
+	// Config.ThroughputSampleInterval-wide window in ThroughputSamples,
+	// combined across agents, in bytes/second. Unlike ThroughputSent's flat
+	// average over the whole run, ThroughputSentP5 surfaces the slowest
+	// windows, which is a more meaningful sustained-capacity figure when the
+	// agent has periodic stalls: "95% of windows sent at least
+	// ThroughputSentP5 B/s." Zero if ThroughputSamples is empty.
+	ThroughputSentP5  float64 `json:"throughput_sent_p5"`
+	ThroughputSentP50 float64 `json:"throughput_sent_p50"`
+	ThroughputSentP95 float64 `json:"throughput_sent_p95"`
+	// ThroughputRcvdP5, ThroughputRcvdP50, and ThroughputRcvdP95 are the
+	// receive-side equivalents of ThroughputSentP5/P50/P95.
+	ThroughputRcvdP5  float64 `json:"throughput_rcvd_p5"`
+	ThroughputRcvdP50 float64 `json:"throughput_rcvd_p50"`
+	ThroughputRcvdP95 float64 `json:"throughput_rcvd_p95"`
+	// ReadRateFairnessCoV is the coefficient of variation (population
+	// standard deviation divided by mean) of bytes read per session, across
+	// every session opened by Config.SessionsPerAgent. It's a fairness
+	// metric for read-side fan-in: 0 means every session drained its
+	// connection at exactly the same rate, while a high value means some
+	// sessions starved others. Most meaningful with Config.SessionsPerAgent
+	// greater than 1; with a single session per agent it trivially reflects
+	// only variance across agents. Zero if fewer than two sessions ran.
+	ReadRateFairnessCoV float64 `json:"read_rate_fairness_cov"`
+
+	LatencySamples int           `json:"latency_samples"`
+	LatencyP50     time.Duration `json:"latency_p50"`
+	LatencyP95     time.Duration `json:"latency_p95"`
+	LatencyP99     time.Duration `json:"latency_p99"`
+	LatencyMax     time.Duration `json:"latency_max"`
+
+	// LatencyViolations and LatencyViolationRatio are only populated when
+	// Config.LatencyThreshold is set. LatencyViolations counts round-trip
+	// samples that exceeded LatencyThreshold; LatencyViolationRatio is that
+	// count divided by LatencySamples.
+	LatencyViolations     int     `json:"latency_violations,omitempty"`
+	LatencyViolationRatio float64 `json:"latency_violation_ratio,omitempty"`
+
+	// Reconnects is the number of times the connection was automatically
+	// redialed after a transport error. Always 0 unless Config.Reconnect is
+	// set.
+	Reconnects int `json:"reconnects"`
+	// ForcedReconnects is the number of times the connection was
+	// deliberately closed and redialed on Config.ReconnectInterval's
+	// cadence, counted separately from Reconnects since these weren't
+	// triggered by an error. Always 0 unless Config.ReconnectInterval is
+	// set.
+	ForcedReconnects int `json:"forced_reconnects"`
+
+	// TotalErrors is the number of read/write failures encountered across
+	// every agent, summed across however many of them Reconnect was able to
+	// recover from. Counted whether or not Config.MaxErrors is set.
+	TotalErrors int `json:"total_errors"`
+	// ErrorBudgetExhausted is true if any agent's errors exceeded
+	// Config.MaxErrors, meaning that agent gave up instead of continuing to
+	// reconnect. Check Error for which agent and what its last error was.
+	// Always false unless Config.MaxErrors is set.
+	ErrorBudgetExhausted bool `json:"error_budget_exhausted"`
+
+	// ConnectSamples is the number of connection establishments this run
+	// timed, covering both the initial dial for every agent and any
+	// subsequent reconnects during a normal run, or every dial in a
+	// Config.HandshakeOnly run's dial-handshake-close cycles.
+	ConnectSamples int           `json:"connect_samples"`
+	ConnectP50     time.Duration `json:"connect_p50"`
+	ConnectP95     time.Duration `json:"connect_p95"`
+	ConnectP99     time.Duration `json:"connect_p99"`
+	ConnectMax     time.Duration `json:"connect_max"`
+
+	// ConnectAttempts is the total number of initial-connection dial
+	// attempts across every agent/session, including the first. It only
+	// exceeds the number of sessions when Config.ConnectRetries is set and
+	// at least one attempt failed before succeeding, or before retries were
+	// exhausted. During a Config.HandshakeOnly run, it's the total number of
+	// dial-handshake-close cycles attempted, including failed ones.
+	ConnectAttempts int `json:"connect_attempts"`
+
+	// TTFBSamples is the number of time-to-first-byte measurements this run
+	// collected: one for the initial connection of every agent/session, plus
+	// one more for every reconnect, measuring from when that connection was
+	// established to when its first byte of echoed output was read. A
+	// connection that's replaced before reading anything contributes no
+	// sample.
+	TTFBSamples int           `json:"ttfb_samples"`
+	TTFBP50     time.Duration `json:"ttfb_p50"`
+	TTFBP95     time.Duration `json:"ttfb_p95"`
+	TTFBP99     time.Duration `json:"ttfb_p99"`
+	TTFBMax     time.Duration `json:"ttfb_max"`
+
+	// KeepaliveSamples is the number of application-level keepalive pings
+	// timed, each measuring the round-trip between writing the ping and
+	// reading its echo back, independently of data-echo latency (see
+	// LatencyP50). Always 0 unless Config.KeepaliveInterval is set.
+	KeepaliveSamples int           `json:"keepalive_samples"`
+	KeepaliveP50     time.Duration `json:"keepalive_p50"`
+	KeepaliveP95     time.Duration `json:"keepalive_p95"`
+	KeepaliveP99     time.Duration `json:"keepalive_p99"`
+	KeepaliveMax     time.Duration `json:"keepalive_max"`
+
+	// ScriptCommandSamples is the number of Config.ScriptPath commands that
+	// completed - their marker was read back before MarkerTimeout elapsed -
+	// each measuring the time from sending the command to observing its
+	// marker. Always 0 unless Config.ScriptWaitForMarker is set.
+	ScriptCommandSamples int           `json:"script_command_samples"`
+	ScriptCommandP50     time.Duration `json:"script_command_p50"`
+	ScriptCommandP95     time.Duration `json:"script_command_p95"`
+	ScriptCommandP99     time.Duration `json:"script_command_p99"`
+	ScriptCommandMax     time.Duration `json:"script_command_max"`
+
+	// HandshakeConnections is the number of successful dial-handshake-close
+	// cycles completed across every agent. Only populated when
+	// Config.HandshakeOnly is set.
+	HandshakeConnections int `json:"handshake_connections,omitempty"`
+	// HandshakeConnectionsPerSecond is HandshakeConnections divided by
+	// Duration, measuring connection setup/teardown rate independently of
+	// data throughput. Zero unless Config.HandshakeOnly is set.
+	HandshakeConnectionsPerSecond float64 `json:"handshake_connections_per_second,omitempty"`
+
+	// CorruptedBytes and MissingBytes are only populated when
+	// Config.VerifyEcho is set. CorruptedBytes counts echoed bytes that
+	// didn't match what was written; MissingBytes counts written bytes
+	// that were never echoed back before the connection closed.
+	CorruptedBytes int64 `json:"corrupted_bytes"`
+	MissingBytes   int64 `json:"missing_bytes"`
+
+	// MaxBytesElapsed is how long it took to reach Config.MaxBytes, the
+	// largest value across every agent once each stopped writing. Zero
+	// unless MaxBytes was set and actually reached before Duration elapsed;
+	// use it instead of Duration to compute throughput when MaxBytes was the
+	// limiting factor.
+	MaxBytesElapsed time.Duration `json:"max_bytes_elapsed,omitempty"`
+
+	// WriteBlockedDuration is the cumulative time spent inside Write calls
+	// across every agent, including time blocked by backpressure from a
+	// full receive buffer on the agent side.
+	WriteBlockedDuration time.Duration `json:"write_blocked_duration"`
+	// WriteBlockedRatio is WriteBlockedDuration divided by the total
+	// possible write time across every agent (Duration times the number of
+	// agents). A high ratio means writes spent most of the run blocked
+	// rather than completing quickly, a clear sign the agent side couldn't
+	// keep up.
+	WriteBlockedRatio float64 `json:"write_blocked_ratio"`
+
+	// OverrunTicks is the number of ticks, summed across every agent, whose
+	// Write took longer than the tick interval to complete, meaning that
+	// tick's write ran into the time budget meant for the next one. Always
+	// zero if Config.UseRateLimiter is set, since rateLimitedWriteLoop paces
+	// continuously rather than in discrete ticks.
+	OverrunTicks int64 `json:"overrun_ticks"`
+	// OverrunTickRatio is OverrunTicks divided by the total number of ticks
+	// that fired across every agent. A high ratio means the agent is
+	// consistently falling behind the target cadence rather than just
+	// occasionally running a slow write.
+	OverrunTickRatio float64 `json:"overrun_tick_ratio"`
+
+	// ThrottledTicks is the number of ticks, summed across every agent,
+	// whose write was delayed because Config.MaxInFlight was already
+	// reached, meaning the agent hadn't echoed back enough of what was
+	// already sent to make room for more. Always zero unless MaxInFlight is
+	// set.
+	ThrottledTicks int64 `json:"throttled_ticks"`
+	// ThrottledTickRatio is ThrottledTicks divided by the total number of
+	// ticks that fired across every agent. A high ratio means MaxInFlight is
+	// the binding constraint on throughput rather than BytesPerSecond.
+	ThrottledTickRatio float64 `json:"throttled_tick_ratio"`
+
+	// ChunkSizeBuckets is the distribution of bytes returned per Read call,
+	// bucketed by size. It reveals whether the agent is coalescing echoed
+	// output into large frames or dribbling it back in small chunks, which
+	// materially affects downstream parsing performance.
+	ChunkSizeBuckets []ChunkSizeBucket `json:"chunk_size_buckets,omitempty"`
+
+	// Error, if non-empty, is the reason the run ended early. The rest of
+	// Results still reflects whatever was accumulated before the failure.
+	Error string `json:"error,omitempty"`
+
+	// ThroughputSamples breaks BytesSent and BytesRcvd down into fixed-size
+	// time windows (Config.ThroughputSampleInterval wide) across the whole
+	// run, so a transient stall shows up as a dip instead of being
+	// averaged into the totals above.
+	ThroughputSamples []ThroughputSample `json:"throughput_samples,omitempty"`
+
+	// ByCommand breaks bytes sent/received, throughput, and latency
+	// percentiles down per command, one entry per distinct command actually
+	// run. Only populated when Config.CommandMix selected more than one
+	// distinct command; a run with a single Command (the common case) has
+	// nothing to compare it against, so it's omitted rather than duplicating
+	// the totals above.
+	ByCommand []CommandResult `json:"by_command,omitempty"`
+
+	// AdaptivePeakBytesPerSecond is the highest bytesPerTick reached by
+	// Config.Pattern TrafficPatternAdaptive's feedback loop, converted to
+	// bytes/second, across every agent, before any backoff brought it back
+	// down. This is the discovered sustainable throughput. Zero unless
+	// Pattern is TrafficPatternAdaptive.
+	AdaptivePeakBytesPerSecond float64 `json:"adaptive_peak_bytes_per_second,omitempty"`
+
+	// EchoCaptureTruncated is true if any agent's Config.EchoCaptureMaxBytes
+	// was reached, meaning EchoCapture doesn't hold everything that was
+	// echoed back. Always false unless Config.EchoCapture is set.
+	EchoCaptureTruncated bool `json:"echo_capture_truncated,omitempty"`
+
+	// GracefulExits is the number of sessions whose remote shell acknowledged
+	// Config.ExitSequence (a read returning io.EOF) within
+	// Config.GracefulShutdownGrace. ForcedExits is the rest: sessions whose
+	// connection had to be closed out from under a shell that never
+	// acknowledged. GracefulExits + ForcedExits always equals len(AgentIDs)
+	// times Config.SessionsPerAgent (at least 1)
+	// when Config.GracefulShutdown is set, and both are always zero
+	// otherwise.
+	GracefulExits int `json:"graceful_exits,omitempty"`
+	ForcedExits   int `json:"forced_exits,omitempty"`
+
+	// SelfProfile reports the generator's own allocation and GC overhead
+	// across the run. Nil unless Config.SelfProfile is set.
+	SelfProfile *SelfProfile `json:"self_profile,omitempty"`
+
+	// SteadyStateReached is true if Config.SteadyState detected convergence
+	// and stopped the run before Config.SteadyStateMaxDuration was reached.
+	// Always false unless Config.SteadyState is set.
+	SteadyStateReached bool `json:"steady_state_reached,omitempty"`
+	// SteadyStateConvergedAfter is how long the run took to reach
+	// convergence, measured from the start of Run. Zero unless
+	// SteadyStateReached is true.
+	SteadyStateConvergedAfter time.Duration `json:"steady_state_converged_after,omitempty"`
+
+	// TransportRTTSamples is the number of transport-level RTT measurements
+	// taken across every agent, one per dial (the initial connection and
+	// every reconnect), independently of application-level echo latency (see
+	// LatencyP50) or keepalive latency (see KeepaliveP50). Always 0 unless
+	// Config.TransportRTT is set and Config.ConnectionType is
+	// ConnectionTypeSSH.
+	TransportRTTSamples int           `json:"transport_rtt_samples,omitempty"`
+	TransportRTTP50     time.Duration `json:"transport_rtt_p50,omitempty"`
+	TransportRTTP95     time.Duration `json:"transport_rtt_p95,omitempty"`
+	TransportRTTP99     time.Duration `json:"transport_rtt_p99,omitempty"`
+	TransportRTTMax     time.Duration `json:"transport_rtt_max,omitempty"`
+}
+
+// SelfProfile is the delta in runtime.MemStats taken immediately before and
+// after a run's write/read loops, attributing allocations and GC pause time
+// to the generator itself rather than to the server under test. This is
+// what lets a throughput ceiling be told apart from client-side GC pressure
+// at very high tick rates.
+type SelfProfile struct {
+	// BytesAllocated is the total bytes allocated by the generator during
+	// the run (runtime.MemStats.TotalAlloc delta), including garbage that
+	// was since collected.
+	BytesAllocated uint64 `json:"bytes_allocated"`
+	// Mallocs is the number of heap allocations made during the run
+	// (runtime.MemStats.Mallocs delta).
+	Mallocs uint64 `json:"mallocs"`
+	// GCCycles is the number of completed garbage collections during the
+	// run (runtime.MemStats.NumGC delta). Process-wide, not specific to this
+	// run's goroutines.
+	GCCycles uint32 `json:"gc_cycles"`
+	// GCPauseTotal is the total stop-the-world pause time across GCCycles
+	// (runtime.MemStats.PauseTotalNs delta). Process-wide, like GCCycles.
+	GCPauseTotal time.Duration `json:"gc_pause_total"`
+}
+
+// CommandResult aggregates every session that ran Command, letting a run
+// configured with Config.CommandMix compare throughput and latency per
+// command instead of only seeing the blended totals in Results.
+type CommandResult struct {
+	Command  string `json:"command"`
+	Sessions int    `json:"sessions"`
+
+	BytesSent uint64 `json:"bytes_sent"`
+	BytesRcvd uint64 `json:"bytes_rcvd"`
+
+	ThroughputSent float64 `json:"throughput_sent"`
+	ThroughputRcvd float64 `json:"throughput_rcvd"`
+
+	LatencySamples int           `json:"latency_samples"`
+	LatencyP50     time.Duration `json:"latency_p50"`
+	LatencyP95     time.Duration `json:"latency_p95"`
+	LatencyP99     time.Duration `json:"latency_p99"`
+	LatencyMax     time.Duration `json:"latency_max"`
+}
+
+// ChunkSizeBucket is the count of Read calls whose returned byte count fell
+// at or below UpperBound, and above the previous bucket's UpperBound. The
+// final bucket has UpperBound -1, meaning unbounded, and catches everything
+// larger than the largest finite bound.
+type ChunkSizeBucket struct {
+	UpperBound int64 `json:"upper_bound"`
+	Count      int64 `json:"count"`
+}
+
+// ThroughputSample is the read/write byte counts accumulated during one
+// fixed-size time window of a run, where Time is the window's offset from
+// the start of the run.
+type ThroughputSample struct {
+	Time         time.Duration `json:"time"`
+	BytesRead    int64         `json:"bytes_read"`
+	BytesWritten int64         `json:"bytes_written"`
+}
+
+// writeMetrics marshals res as a single JSON object to w, compressed per
+// compression if set. The compressor, if any, is flushed and closed before
+// this returns so every byte reaches w; w itself is never closed, since it
+// may be a long-lived writer such as an open file.
+func writeMetrics(w io.Writer, res Results, compression MetricsCompression) error {
+	dst, closeDst, err := wrapMetricsWriter(w, compression)
+	if err != nil {
+		return xerrors.Errorf("wrap metrics writer: %w", err)
+	}
+	if err := json.NewEncoder(dst).Encode(res); err != nil {
+		return xerrors.Errorf("encode results: %w", err)
+	}
+	if err := closeDst(); err != nil {
+		return xerrors.Errorf("close metrics compressor: %w", err)
+	}
+	return nil
+}
+
+// wrapMetricsWriter wraps w in a gzip or zstd compressor per compression,
+// returning the writer to encode into and a close func that flushes and
+// closes the compressor. For MetricsCompressionNone, it returns w unchanged
+// and a no-op close func.
+func wrapMetricsWriter(w io.Writer, compression MetricsCompression) (io.Writer, func() error, error) {
+	switch compression {
+	case MetricsCompressionNone:
+		return w, func() error { return nil }, nil
+	case MetricsCompressionGzip:
+		gw := gzip.NewWriter(w)
+		return gw, gw.Close, nil
+	case MetricsCompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("create zstd writer: %w", err)
+		}
+		return zw, zw.Close, nil
+	default:
+		return nil, nil, xerrors.Errorf("invalid metrics_compression: %q", compression)
+	}
+}
+
+// csvColumns is the column order written by writeCSVHeader and writeCSVRow.
+var csvColumns = []string{"agent_id", "duration", "bytes_sent", "bytes_rcvd", "throughput", "error", "labels"}
+
+// writeCSVHeader writes a single CSV header row to w, matching csvColumns.
+func writeCSVHeader(w io.Writer) error {
+	return writeCSVRecord(w, csvColumns)
+}
+
+// writeCSVRow appends a single CSV row to w summarizing one agent's
+// contribution to a run: its ID, how long it ran, bytes sent/received,
+// achieved throughput in bytes/second, its error if any, and labels (see
+// formatLabels).
+func writeCSVRow(w io.Writer, agentID uuid.UUID, duration time.Duration, bytesSent, bytesRcvd uint64, throughputSent float64, errStr string, labels map[string]string) error {
+	return writeCSVRecord(w, []string{
+		agentID.String(),
+		duration.String(),
+		strconv.FormatUint(bytesSent, 10),
+		strconv.FormatUint(bytesRcvd, 10),
+		strconv.FormatFloat(throughputSent, 'f', -1, 64),
+		errStr,
+		formatLabels(labels),
+	})
+}
+
+// formatLabels renders labels as a single "key=value,key2=value2" string,
+// sorted by key for determinism, so Config.Labels - an arbitrary,
+// caller-defined set of dimensions - can fit into a single CSV column
+// alongside the other fixed ones in csvColumns. Empty if labels is empty.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + labels[k]
+	}
+	return strings.Join(parts, ",")
+}
+
+// writeCSVRecord encodes record as a single CSV row and writes it to w with
+// one Write call, so that concurrent writers sharing a synchronized w (see
+// loadtestutil.NewSyncWriter) don't interleave partial rows.
+func writeCSVRecord(w io.Writer, record []string) error {
+	buf := &bytes.Buffer{}
+	cw := csv.NewWriter(buf)
+	if err := cw.Write(record); err != nil {
+		return xerrors.Errorf("encode csv record: %w", err)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return xerrors.Errorf("flush csv writer: %w", err)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return xerrors.Errorf("write csv record: %w", err)
+	}
+	return nil
+}
+
+// promMetrics holds the live Prometheus collectors for a single run,
+// labeled by agent_id so that multiple concurrent Runners can share a
+// registry.
+type promMetrics struct {
+	bytesWritten prometheus.Counter
+	bytesRead    prometheus.Counter
+	latency      prometheus.Observer
+}
+
+// registerPromMetrics registers counters for bytes written/read and a
+// histogram of per-tick latency against reg, all labeled with agentID, plus
+// labels as constant labels on every series (see Config.Labels). The
+// returned promMetrics should be updated live during the run rather than
+// only at the end.
+func registerPromMetrics(reg *prometheus.Registry, agentID string, labels map[string]string) *promMetrics {
+	factory := promauto.With(prometheus.WrapRegistererWith(prometheus.Labels(labels), reg))
+
+	bytesWritten := factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "coder",
+		Subsystem: "trafficgen",
+		Name:      "bytes_written_total",
+		Help:      "Total number of bytes written to the agent connection.",
+	}, []string{"agent_id"})
+	bytesRead := factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "coder",
+		Subsystem: "trafficgen",
+		Name:      "bytes_read_total",
+		Help:      "Total number of bytes read back from the agent connection.",
+	}, []string{"agent_id"})
+	latency := factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "coder",
+		Subsystem: "trafficgen",
+		Name:      "tick_latency_seconds",
+		Help:      "Round-trip latency between a write and its echoed read.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"agent_id"})
+
+	return &promMetrics{
+		bytesWritten: bytesWritten.WithLabelValues(agentID),
+		bytesRead:    bytesRead.WithLabelValues(agentID),
+		latency:      latency.WithLabelValues(agentID),
+	}
+}
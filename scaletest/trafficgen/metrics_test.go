@@ -0,0 +1,97 @@
+package trafficgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeMetrics(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	err := writeMetrics(buf, Results{
+		SchemaVersion: resultsSchemaVersion,
+		Duration:      time.Second,
+		BytesSent:     100,
+		BytesRcvd:     90,
+	}, MetricsCompressionNone)
+	require.NoError(t, err)
+
+	var got Results
+	err = json.Unmarshal(buf.Bytes(), &got)
+	require.NoError(t, err)
+	require.Equal(t, resultsSchemaVersion, got.SchemaVersion)
+	require.EqualValues(t, 100, got.BytesSent)
+	require.EqualValues(t, 90, got.BytesRcvd)
+}
+
+func Test_writeMetrics_compression(t *testing.T) {
+	t.Parallel()
+
+	res := Results{
+		SchemaVersion: resultsSchemaVersion,
+		Duration:      time.Second,
+		BytesSent:     100,
+		BytesRcvd:     90,
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		t.Parallel()
+
+		buf := new(bytes.Buffer)
+		err := writeMetrics(buf, res, MetricsCompressionGzip)
+		require.NoError(t, err)
+
+		gr, err := gzip.NewReader(buf)
+		require.NoError(t, err)
+		defer gr.Close()
+
+		var got Results
+		require.NoError(t, json.NewDecoder(gr).Decode(&got))
+		require.Equal(t, res, got)
+	})
+
+	t.Run("Zstd", func(t *testing.T) {
+		t.Parallel()
+
+		buf := new(bytes.Buffer)
+		err := writeMetrics(buf, res, MetricsCompressionZstd)
+		require.NoError(t, err)
+
+		zr, err := zstd.NewReader(buf)
+		require.NoError(t, err)
+		defer zr.Close()
+
+		var got Results
+		require.NoError(t, json.NewDecoder(zr).Decode(&got))
+		require.Equal(t, res, got)
+	})
+}
+
+func Test_writeCSVRow(t *testing.T) {
+	t.Parallel()
+
+	agentID := uuid.New()
+	buf := new(bytes.Buffer)
+
+	err := writeCSVHeader(buf)
+	require.NoError(t, err)
+	err = writeCSVRow(buf, agentID, time.Second, 100, 90, 90.5, "", map[string]string{"region": "us", "az": "a"})
+	require.NoError(t, err)
+
+	r := csv.NewReader(buf)
+	records, err := r.ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		csvColumns,
+		{agentID.String(), "1s", "100", "90", "90.5", "", "az=a,region=us"},
+	}, records)
+}
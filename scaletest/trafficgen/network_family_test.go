@@ -0,0 +1,61 @@
+package trafficgen
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cdr.dev/slog"
+	"cdr.dev/slog/sloggers/slogtest"
+)
+
+func Test_withDialResolver(t *testing.T) {
+	t.Parallel()
+
+	t.Run("DialsThroughCustomTransport", func(t *testing.T) {
+		t.Parallel()
+
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				_ = conn.Close()
+			}
+		}()
+
+		transport := withDialResolver(nil, nil, NetworkFamilyIPv4, slogtest.Make(t, nil).Leveled(slog.LevelDebug))
+		conn, err := transport.(*http.Transport).DialContext(context.Background(), "tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+	})
+
+	t.Run("PreservesExistingTransportSettings", func(t *testing.T) {
+		t.Parallel()
+
+		base := &http.Transport{DisableKeepAlives: true}
+		transport := withDialResolver(base, nil, NetworkFamilyAuto, slogtest.Make(t, nil))
+		require.True(t, transport.(*http.Transport).DisableKeepAlives)
+		// base itself must be untouched, since a caller may still be using it.
+		require.NotSame(t, base, transport)
+	})
+
+	t.Run("ForcedFamilyMismatchFails", func(t *testing.T) {
+		t.Parallel()
+
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		transport := withDialResolver(nil, nil, NetworkFamilyIPv6, slogtest.Make(t, nil))
+		_, err = transport.(*http.Transport).DialContext(context.Background(), "tcp", ln.Addr().String())
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,220 @@
+package trafficgen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newPayloadGenerator(t *testing.T) {
+	t.Parallel()
+
+	t.Run("SeededIsDeterministic", func(t *testing.T) {
+		t.Parallel()
+
+		genA := newPayloadGenerator(1, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+		genB := newPayloadGenerator(1, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+
+		a, err := genA(nil, 64)
+		require.NoError(t, err)
+		b, err := genB(nil, 64)
+		require.NoError(t, err)
+
+		require.Equal(t, a, b)
+		require.Len(t, a, 64)
+	})
+
+	t.Run("DifferentSeedsDiffer", func(t *testing.T) {
+		t.Parallel()
+
+		genA := newPayloadGenerator(1, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+		genB := newPayloadGenerator(2, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+
+		a, err := genA(nil, 64)
+		require.NoError(t, err)
+		b, err := genB(nil, 64)
+		require.NoError(t, err)
+
+		require.NotEqual(t, a, b)
+	})
+
+	t.Run("ZeroSeedUsesCrypto", func(t *testing.T) {
+		t.Parallel()
+
+		gen := newPayloadGenerator(0, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+		a, err := gen(nil, 64)
+		require.NoError(t, err)
+		require.Len(t, a, 64)
+	})
+
+	t.Run("CustomPrefix", func(t *testing.T) {
+		t.Parallel()
+
+		gen := newPayloadGenerator(1, "//", PayloadEntropyRandom, nil)
+		a, err := gen(nil, 64)
+		require.NoError(t, err)
+		require.Len(t, a, 64)
+		require.True(t, bytes.HasPrefix(a, []byte("//")))
+	})
+
+	t.Run("PrefixLongerThanPayload", func(t *testing.T) {
+		t.Parallel()
+
+		gen := newPayloadGenerator(0, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+		a, err := gen(nil, 1)
+		require.NoError(t, err)
+		require.Equal(t, []byte("#\n"), a)
+	})
+
+	t.Run("Repeated", func(t *testing.T) {
+		t.Parallel()
+
+		gen := newPayloadGenerator(0, DefaultPayloadPrefix, PayloadEntropyRepeated, nil)
+		a, err := gen(nil, 64)
+		require.NoError(t, err)
+		require.Len(t, a, 64)
+		require.True(t, bytes.HasPrefix(a, []byte(DefaultPayloadPrefix)))
+
+		body := bytes.TrimPrefix(a, []byte(DefaultPayloadPrefix))
+		body = bytes.TrimSuffix(body, []byte("\n"))
+		for _, c := range body {
+			require.Equal(t, byte(repeatedPatternByte), c)
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		t.Parallel()
+
+		gen := newPayloadGenerator(0, DefaultPayloadPrefix, PayloadEntropyText, nil)
+		a, err := gen(nil, 64)
+		require.NoError(t, err)
+		require.Len(t, a, 64)
+		require.True(t, bytes.HasPrefix(a, []byte(DefaultPayloadPrefix)))
+	})
+
+	t.Run("ScriptCommandsOverrideEntropy", func(t *testing.T) {
+		t.Parallel()
+
+		gen := newPayloadGenerator(0, DefaultPayloadPrefix, PayloadEntropyRandom, []string{"echo hi"})
+		a, err := gen(nil, 8)
+		require.NoError(t, err)
+		require.Equal(t, []byte("echo hi\n"), a)
+		require.False(t, bytes.HasPrefix(a, []byte(DefaultPayloadPrefix)))
+	})
+}
+
+func Test_scriptPayload(t *testing.T) {
+	t.Parallel()
+
+	t.Run("PadsWithSpacesRatherThanSplitACommand", func(t *testing.T) {
+		t.Parallel()
+
+		// "echo hello world\n" is 17 bytes, longer than the 5-byte budget:
+		// it doesn't fit at all, so this call must emit padding instead of
+		// a prefix of the command, and idx must not advance past it.
+		idx := 0
+		out := scriptPayload(nil, []string{"echo hello world"}, &idx, 5)
+		require.Equal(t, []byte("     "), out)
+		require.Equal(t, 0, idx)
+	})
+
+	t.Run("PadsWhenShort", func(t *testing.T) {
+		t.Parallel()
+
+		// "ls\n" is 3 bytes, longer than the 2-byte budget, so it doesn't
+		// fit either - same as above, just with a command that would have
+		// fit had the budget not needed room for the trailing newline too.
+		idx := 0
+		out := scriptPayload(nil, []string{"ls"}, &idx, 2)
+		require.Equal(t, []byte("  "), out)
+		require.Equal(t, 0, idx)
+	})
+
+	t.Run("CyclesThroughCommands", func(t *testing.T) {
+		t.Parallel()
+
+		idx := 0
+		out := scriptPayload(nil, []string{"a", "b"}, &idx, 8)
+		require.Equal(t, []byte("a\nb\na\nb\n"), out)
+		require.Equal(t, 4, idx)
+
+		out = scriptPayload(nil, []string{"a", "b"}, &idx, 4)
+		require.Equal(t, []byte("a\nb\n"), out)
+		require.Equal(t, 6, idx)
+	})
+
+	t.Run("NeverSplitsACommandAcrossCalls", func(t *testing.T) {
+		t.Parallel()
+
+		// Regression test: "ls -l" is 5 bytes, 6 with its newline, leaving
+		// only 1 byte of an 7-byte budget free - not enough for "pwd\n" to
+		// follow. The old implementation would write both lines, then
+		// truncate to 7 bytes mid-"pwd", and since idx had already moved
+		// past both commands, the next call resumed at the command after
+		// "pwd", gluing it directly onto "pwd"'s truncated remainder with
+		// no separating newline.
+		idx := 0
+		first := scriptPayload(nil, []string{"ls -l", "pwd", "whoami"}, &idx, 7)
+		require.Equal(t, []byte("ls -l\n "), first)
+		require.Equal(t, 1, idx)
+
+		second := scriptPayload(nil, []string{"ls -l", "pwd", "whoami"}, &idx, 7)
+		require.Equal(t, []byte("pwd\n   "), second)
+		require.Equal(t, 2, idx)
+	})
+}
+
+func Test_loadScript(t *testing.T) {
+	t.Parallel()
+
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "script.txt")
+		require.NoError(t, os.WriteFile(path, []byte("echo one\n\n  echo two  \n"), 0o600))
+
+		commands, err := loadScript(path)
+		require.NoError(t, err)
+		require.Equal(t, []string{"echo one", "echo two"}, commands)
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := loadScript(filepath.Join(t.TempDir(), "does-not-exist.txt"))
+		require.Error(t, err)
+	})
+
+	t.Run("EmptyFile", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "script.txt")
+		require.NoError(t, os.WriteFile(path, []byte("\n  \n"), 0o600))
+
+		_, err := loadScript(path)
+		require.ErrorContains(t, err, "no commands")
+	})
+}
+
+func Test_mustPayload(t *testing.T) {
+	t.Parallel()
+
+	a := mustPayload(DefaultPayloadPrefix, 64)
+	require.Len(t, a, 64)
+	require.True(t, bytes.HasPrefix(a, []byte(DefaultPayloadPrefix)))
+}
+
+// mustPayload panics if payload generation fails, so tests that just need a
+// payload to exist don't have to thread error handling through setup.
+// Production code must use randPayload/seededRandPayload instead, which
+// return the error for the harness to report.
+func mustPayload(prefix string, n int64) []byte {
+	b, err := randPayload(nil, prefix, n)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
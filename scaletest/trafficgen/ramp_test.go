@@ -0,0 +1,240 @@
+package trafficgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_burstBytesPerTick(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		size      int64
+		idleTicks int64
+		tickIndex int64
+		want      int64
+	}{
+		{name: "NoIdleAlwaysBursts", size: 100, idleTicks: 0, tickIndex: 5, want: 100},
+		{name: "BurstTick", size: 100, idleTicks: 2, tickIndex: 0, want: 100},
+		{name: "IdleTick", size: 100, idleTicks: 2, tickIndex: 1, want: 0},
+		{name: "NextBurstTick", size: 100, idleTicks: 2, tickIndex: 3, want: 100},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := burstBytesPerTick(c.size, c.idleTicks, c.tickIndex)
+			require.Equal(t, c.want, got)
+		})
+	}
+}
+
+func Test_remainderBytesPerTick(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name           string
+		remainder      int64
+		ticksPerSecond int64
+		tickIndex      int64
+		want           int64
+	}{
+		{name: "NoRemainder", remainder: 0, ticksPerSecond: 3, tickIndex: 0, want: 0},
+		{name: "FirstTickGetsRemainder", remainder: 1, ticksPerSecond: 3, tickIndex: 0, want: 1},
+		{name: "LaterTickInSameSecondDoesNot", remainder: 1, ticksPerSecond: 3, tickIndex: 1, want: 0},
+		{name: "WrapsToNextSecond", remainder: 1, ticksPerSecond: 3, tickIndex: 3, want: 1},
+		{name: "MultipleRemainderTicks", remainder: 2, ticksPerSecond: 3, tickIndex: 1, want: 1},
+		{name: "MultipleRemainderTicksOutOfRange", remainder: 2, ticksPerSecond: 3, tickIndex: 2, want: 0},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := remainderBytesPerTick(c.remainder, c.ticksPerSecond, c.tickIndex)
+			require.Equal(t, c.want, got)
+		})
+	}
+}
+
+func Test_rampedBytesPerTick(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		target   int64
+		rampUp   time.Duration
+		duration time.Duration
+		elapsed  time.Duration
+		want     int64
+	}{
+		{
+			name:     "NoRampUp",
+			target:   100,
+			rampUp:   0,
+			duration: time.Minute,
+			elapsed:  0,
+			want:     100,
+		},
+		{
+			name:     "Halfway",
+			target:   100,
+			rampUp:   10 * time.Second,
+			duration: time.Minute,
+			elapsed:  5 * time.Second,
+			want:     50,
+		},
+		{
+			name:     "PastRampUp",
+			target:   100,
+			rampUp:   10 * time.Second,
+			duration: time.Minute,
+			elapsed:  20 * time.Second,
+			want:     100,
+		},
+		{
+			name:     "RampUpExceedsDuration",
+			target:   100,
+			rampUp:   time.Minute,
+			duration: 10 * time.Second,
+			elapsed:  5 * time.Second,
+			want:     50,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := rampedBytesPerTick(c.target, c.rampUp, c.duration, c.elapsed)
+			require.Equal(t, c.want, got)
+		})
+	}
+}
+
+func Test_sineBytesPerTick(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		target    int64
+		amplitude float64
+		period    time.Duration
+		elapsed   time.Duration
+		want      int64
+	}{
+		{name: "Start", target: 100, amplitude: 0.5, period: time.Minute, elapsed: 0, want: 100},
+		{name: "QuarterPeriodPeaks", target: 100, amplitude: 0.5, period: time.Minute, elapsed: 15 * time.Second, want: 150},
+		{name: "HalfPeriodReturnsToMean", target: 100, amplitude: 0.5, period: time.Minute, elapsed: 30 * time.Second, want: 100},
+		{name: "ThreeQuarterPeriodTroughs", target: 100, amplitude: 0.5, period: time.Minute, elapsed: 45 * time.Second, want: 50},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := sineBytesPerTick(c.target, c.amplitude, c.period, c.elapsed)
+			require.Equal(t, c.want, got)
+		})
+	}
+
+	t.Run("FullPeriodReturnsToMean", func(t *testing.T) {
+		t.Parallel()
+
+		got := sineBytesPerTick(100, 0.5, time.Minute, time.Minute)
+		require.InDelta(t, 100, got, 1)
+	})
+}
+
+func Test_jitteredInterval(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ZeroJitterIsUnchanged", func(t *testing.T) {
+		t.Parallel()
+
+		got := jitteredInterval(100*time.Millisecond, 0)
+		require.Equal(t, 100*time.Millisecond, got)
+	})
+
+	t.Run("WithinBounds", func(t *testing.T) {
+		t.Parallel()
+
+		const interval = 100 * time.Millisecond
+		const jitter = 0.2
+		min := interval - time.Duration(jitter*float64(interval))
+		max := interval + time.Duration(jitter*float64(interval))
+
+		for i := 0; i < 1000; i++ {
+			got := jitteredInterval(interval, jitter)
+			require.GreaterOrEqual(t, got, min)
+			require.LessOrEqual(t, got, max)
+		}
+	})
+}
+
+func Test_sampleTickSize(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NoneIsUnchanged", func(t *testing.T) {
+		t.Parallel()
+
+		got := sampleTickSize(100, SizeDistributionNone, 0.5)
+		require.EqualValues(t, 100, got)
+	})
+
+	t.Run("ZeroMeanIsUnchanged", func(t *testing.T) {
+		t.Parallel()
+
+		got := sampleTickSize(0, SizeDistributionUniform, 0.5)
+		require.Zero(t, got)
+	})
+
+	t.Run("UniformWithinBounds", func(t *testing.T) {
+		t.Parallel()
+
+		const mean = 1000
+		const spread = 0.5
+
+		for i := 0; i < 1000; i++ {
+			got := sampleTickSize(mean, SizeDistributionUniform, spread)
+			require.GreaterOrEqual(t, got, int64(mean*(1-spread)))
+			require.LessOrEqual(t, got, int64(mean*(1+spread)))
+		}
+	})
+
+	t.Run("NormalConvergesToMean", func(t *testing.T) {
+		t.Parallel()
+
+		const mean = 1000
+		const samples = 10000
+
+		var total int64
+		for i := 0; i < samples; i++ {
+			got := sampleTickSize(mean, SizeDistributionNormal, 0.2)
+			require.GreaterOrEqual(t, got, int64(0))
+			total += got
+		}
+		require.InDelta(t, mean, float64(total)/samples, mean*0.05)
+	})
+
+	t.Run("NeverNegative", func(t *testing.T) {
+		t.Parallel()
+
+		for i := 0; i < 1000; i++ {
+			got := sampleTickSize(10, SizeDistributionNormal, 5)
+			require.GreaterOrEqual(t, got, int64(0))
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package trafficgen
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_postResults(t *testing.T) {
+	t.Parallel()
+
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+
+		var received Results
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := json.NewDecoder(r.Body).Decode(&received)
+			require.NoError(t, err)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer srv.Close()
+
+		err := postResults(context.Background(), srv.URL, Results{TraceID: "abc123", BytesSent: 42})
+		require.NoError(t, err)
+		require.Equal(t, "abc123", received.TraceID)
+		require.EqualValues(t, 42, received.BytesSent)
+	})
+
+	t.Run("RetriesThenSucceeds", func(t *testing.T) {
+		t.Parallel()
+
+		var attempts int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt64(&attempts, 1) <= 2 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer srv.Close()
+
+		err := postResults(context.Background(), srv.URL, Results{})
+		require.NoError(t, err)
+		require.EqualValues(t, 3, atomic.LoadInt64(&attempts))
+	})
+
+	t.Run("ExhaustsRetries", func(t *testing.T) {
+		t.Parallel()
+
+		var attempts int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&attempts, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer srv.Close()
+
+		err := postResults(context.Background(), srv.URL, Results{})
+		require.Error(t, err)
+		require.EqualValues(t, resultsEndpointRetries+1, atomic.LoadInt64(&attempts))
+	})
+}
@@ -0,0 +1,4399 @@
+package trafficgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
+
+	"cdr.dev/slog"
+	"cdr.dev/slog/sloggers/sloghuman"
+	"github.com/coder/coder/coderd/tracing"
+	"github.com/coder/coder/codersdk"
+	"github.com/coder/coder/cryptorand"
+	"github.com/coder/coder/scaletest/harness"
+	"github.com/coder/coder/scaletest/loadtestutil"
+)
+
+type Runner struct {
+	client *codersdk.Client
+	cfg    Config
+
+	mu    sync.Mutex
+	conns map[uuid.UUID]*openConn
+	crws  map[uuid.UUID]*countReadWriter
+	rtts  map[uuid.UUID][]time.Duration
+
+	// active is true for the duration of a Run call, for Config.HealthAddr's
+	// /healthz to report whether the run has started and hasn't finished
+	// yet.
+	active atomic.Bool
+}
+
+// resolveAgentByName looks up the agent named cfg.AgentName in the workspace
+// named cfg.WorkspaceName, owned by the authenticated user, and appends its
+// ID to cfg.AgentIDs. No-op if cfg.WorkspaceName is unset, since Validate
+// already requires AgentIDs to be non-empty in that case.
+func (r *Runner) resolveAgentByName(ctx context.Context) error {
+	if r.cfg.WorkspaceName == "" {
+		return nil
+	}
+
+	workspace, err := r.client.WorkspaceByOwnerAndName(ctx, codersdk.Me, r.cfg.WorkspaceName, codersdk.WorkspaceOptions{})
+	if err != nil {
+		return xerrors.Errorf("get workspace %q: %w", r.cfg.WorkspaceName, err)
+	}
+
+	var matches []uuid.UUID
+	for _, resource := range workspace.LatestBuild.Resources {
+		for _, agent := range resource.Agents {
+			if agent.Name == r.cfg.AgentName {
+				matches = append(matches, agent.ID)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return xerrors.Errorf("workspace %q has no agent named %q", r.cfg.WorkspaceName, r.cfg.AgentName)
+	case 1:
+		r.cfg.AgentIDs = append(r.cfg.AgentIDs, matches[0])
+		return nil
+	default:
+		return xerrors.Errorf("workspace %q has %d agents named %q, expected exactly one", r.cfg.WorkspaceName, len(matches), r.cfg.AgentName)
+	}
+}
+
+// sessionsPerAgent returns cfg.SessionsPerAgent, defaulting to 1.
+func sessionsPerAgent(cfg Config) int {
+	if cfg.SessionsPerAgent <= 0 {
+		return 1
+	}
+	return cfg.SessionsPerAgent
+}
+
+// logRateDrift logs the per-agent send rate actually achievable once
+// bytesPerSecond is truncated down to a whole number of bytes per tick, and
+// warns if that truncation drifts from the requested rate by more than
+// warnThreshold (DefaultRateDriftWarnThreshold if zero). No-op when
+// useRateLimiter is set, since rateLimitedWriteLoop paces continuously
+// rather than in discrete per-tick chunks, or when ticksPerSecond is zero.
+func logRateDrift(ctx context.Context, logger slog.Logger, bytesPerSecond, ticksPerSecond int64, useRateLimiter bool, warnThreshold float64, distributeRemainder bool) {
+	if useRateLimiter || ticksPerSecond <= 0 {
+		return
+	}
+
+	actualBytesPerSecond := (bytesPerSecond / ticksPerSecond) * ticksPerSecond
+	if distributeRemainder {
+		actualBytesPerSecond = bytesPerSecond
+	}
+
+	var driftRatio float64
+	if bytesPerSecond > 0 {
+		driftRatio = float64(bytesPerSecond-actualBytesPerSecond) / float64(bytesPerSecond)
+	}
+
+	logger.Info(ctx, "per-agent send rate after tick quantization",
+		slog.F("requested_bytes_per_second", bytesPerSecond),
+		slog.F("actual_bytes_per_second", actualBytesPerSecond),
+		slog.F("ticks_per_second", ticksPerSecond),
+		slog.F("rate_drift_ratio", driftRatio),
+		slog.F("distribute_remainder", distributeRemainder),
+	)
+
+	if warnThreshold == 0 {
+		warnThreshold = DefaultRateDriftWarnThreshold
+	}
+	if driftRatio > warnThreshold {
+		logger.Warn(ctx, "bytes_per_second does not divide evenly by ticks_per_second, truncation will under-run the target rate",
+			slog.F("requested_bytes_per_second", bytesPerSecond),
+			slog.F("actual_bytes_per_second", actualBytesPerSecond),
+			slog.F("rate_drift_ratio", driftRatio),
+			slog.F("rate_drift_warn_threshold", warnThreshold),
+		)
+	}
+}
+
+// openConn tracks a single session's live connection so Cleanup can close it
+// out-of-band if Run was interrupted before its own defers ran. Keyed by
+// reconnect token rather than agent ID, since Config.SessionsPerAgent can
+// open more than one concurrent session against the same agent.
+type openConn struct {
+	agentID uuid.UUID
+	close   func() error
+}
+
+var _ harness.Runnable = &Runner{}
+var _ harness.Cleanable = &Runner{}
+
+// NewRunner returns a Runner that drives traffic against cfg.AgentIDs using
+// client. Every dial, including the reconnecting PTY and SSH paths, goes
+// through client.HTTPClient, so mTLS-only deployments can configure client
+// certificates by setting a custom tls.Config on client.HTTPClient.Transport
+// before calling NewRunner; there's no separate transport override here.
+func NewRunner(client *codersdk.Client, cfg Config) *Runner {
+	return &Runner{
+		client: client,
+		cfg:    cfg,
+		conns:  make(map[uuid.UUID]*openConn),
+		crws:   make(map[uuid.UUID]*countReadWriter),
+		rtts:   make(map[uuid.UUID][]time.Duration),
+	}
+}
+
+// RunStats is a point-in-time snapshot of a Runner's progress, returned by
+// Stats. Unlike Results, it only reflects sessions that are currently
+// connected: a session's contribution disappears from future snapshots once
+// it closes, rather than accumulating into a running total.
+type RunStats struct {
+	BytesSent uint64 `json:"bytes_sent"`
+	BytesRcvd uint64 `json:"bytes_rcvd"`
+
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP95 time.Duration `json:"latency_p95"`
+	LatencyP99 time.Duration `json:"latency_p99"`
+	LatencyMax time.Duration `json:"latency_max"`
+}
+
+// Stats returns a snapshot of bytes sent/received and round-trip latency
+// across every currently-connected session, safe to call concurrently with
+// Run. It returns a zero RunStats if Run hasn't started yet or every session
+// has already closed, so callers can poll it at their own cadence instead of
+// wiring up Config.ProgressFn.
+func (r *Runner) Stats() RunStats {
+	r.mu.Lock()
+	crws := make([]*countReadWriter, 0, len(r.crws))
+	for _, crw := range r.crws {
+		crws = append(crws, crw)
+	}
+	r.mu.Unlock()
+
+	var stats RunStats
+	var latencies []time.Duration
+	for _, crw := range crws {
+		stats.BytesSent += uint64(crw.writeBytes())
+		stats.BytesRcvd += uint64(crw.readBytes())
+		latencies = append(latencies, crw.latencySamples()...)
+	}
+	stats.LatencyP50, stats.LatencyP95, stats.LatencyP99, stats.LatencyMax = percentiles(latencies)
+	return stats
+}
+
+// connectionCount returns the number of sessions currently open, safe to
+// call concurrently with Run.
+func (r *Runner) connectionCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// monitorSteadyState waits for Config.Duration to elapse, then polls r.Stats
+// every Config.SteadyStateWindow, comparing the aggregate throughput of each
+// window against the one before it. Once two successive windows are within
+// Config.SteadyStateEpsilon of each other it cancels ctx, ending the run
+// early instead of waiting out the rest of Config.SteadyStateMaxDuration. It
+// returns false, 0 if ctx is done (Config.SteadyStateMaxDuration reached, or
+// the run ended some other way) before convergence is detected. Only called
+// from Run when Config.SteadyState is set.
+func (r *Runner) monitorSteadyState(ctx context.Context, cancel context.CancelFunc, start time.Time, logger slog.Logger) (reached bool, convergedAfter time.Duration) {
+	window := time.Duration(r.cfg.SteadyStateWindow)
+	if window <= 0 {
+		window = DefaultSteadyStateWindow
+	}
+
+	settleTimer := time.NewTimer(time.Duration(r.cfg.Duration))
+	defer settleTimer.Stop()
+	select {
+	case <-ctx.Done():
+		return false, 0
+	case <-settleTimer.C:
+	}
+
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+
+	prev := r.Stats()
+	var prevThroughput float64
+	havePrev := false
+	for {
+		select {
+		case <-ctx.Done():
+			return false, 0
+		case <-ticker.C:
+			cur := r.Stats()
+			throughput := float64(int64(cur.BytesSent+cur.BytesRcvd)-int64(prev.BytesSent+prev.BytesRcvd)) / window.Seconds()
+			prev = cur
+
+			if havePrev {
+				// prevThroughput <= 0 means the previous window saw no
+				// measurable traffic (most commonly still ramping up right
+				// after settleTimer fires), so there's nothing yet to call
+				// converged even if this window is also quiet.
+				converged := prevThroughput > 0 && math.Abs(throughput-prevThroughput)/prevThroughput <= r.cfg.SteadyStateEpsilon
+				logger.Debug(ctx, "steady state window",
+					slog.F("throughput", throughput),
+					slog.F("previous_throughput", prevThroughput),
+					slog.F("epsilon", r.cfg.SteadyStateEpsilon),
+				)
+				if converged {
+					cancel()
+					return true, time.Since(start)
+				}
+			}
+			prevThroughput = throughput
+			havePrev = true
+		}
+	}
+}
+
+// HealthStatus is served as JSON from Config.HealthAddr's /healthz.
+type HealthStatus struct {
+	// Status is "ok" if Active is true and Connections is greater than
+	// zero, and "degraded" otherwise - either Run hasn't started (or has
+	// already finished) or every session has dropped, which between
+	// reconnect attempts is expected to be transient but sustained is a
+	// sign something is wrong.
+	Status      string `json:"status"`
+	Active      bool   `json:"active"`
+	Connections int    `json:"connections"`
+}
+
+// startHealthServer starts an HTTP server on Config.HealthAddr serving
+// /healthz (HealthStatus as JSON, reflecting this Runner's live state) and
+// /metrics (Config.MetricsRegistry's counters in Prometheus exposition
+// format, or an empty registry's if MetricsRegistry is unset). It returns
+// once the server is listening, so a caller relying on the health check
+// being reachable immediately after Run starts isn't racing a background
+// goroutine; the returned stop func shuts the server down and should be
+// deferred by the caller.
+func (r *Runner) startHealthServer(logger slog.Logger) (stop func(), err error) {
+	listener, err := net.Listen("tcp", r.cfg.HealthAddr)
+	if err != nil {
+		return nil, xerrors.Errorf("listen on %q: %w", r.cfg.HealthAddr, err)
+	}
+
+	registry := r.cfg.MetricsRegistry
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		status := HealthStatus{
+			Active:      r.active.Load(),
+			Connections: r.connectionCount(),
+		}
+		status.Status = "degraded"
+		code := http.StatusServiceUnavailable
+		if status.Active && status.Connections > 0 {
+			status.Status = "ok"
+			code = http.StatusOK
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(status)
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !xerrors.Is(err, http.ErrServerClosed) {
+			logger.Warn(context.Background(), "health server exited unexpectedly", slog.Error(err))
+		}
+	}()
+
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), healthServerShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}, nil
+}
+
+// healthServerShutdownTimeout bounds how long startHealthServer's stop func
+// waits for in-flight /healthz or /metrics requests to finish before Run
+// returns.
+const healthServerShutdownTimeout = 5 * time.Second
+
+// ErrInterrupted is returned by Run when ctx is canceled before Config.Duration
+// elapses, for example because the process caught SIGINT. Results accumulated
+// up to that point are still logged and written to Config.MetricsWriter, the
+// same as a run that ends normally; only the returned error distinguishes an
+// interruption from a clean finish.
+var ErrInterrupted = xerrors.New("run interrupted")
+
+// ErrLatencySLOExceeded is returned by Run when Config.LatencyThreshold is
+// set and the fraction of round-trip samples exceeding it is greater than
+// Config.LatencyViolationBudget, so the generator can be used directly as a
+// pass/fail latency SLO gate in CI.
+var ErrLatencySLOExceeded = xerrors.New("latency SLO exceeded")
+
+// ErrZeroBytesTransferred is returned by Run when Config.FailOnZeroBytes is
+// set and the run completed without a single byte sent or received,
+// typically because the target command exited immediately instead of
+// echoing anything back.
+var ErrZeroBytesTransferred = xerrors.New("zero bytes transferred")
+
+// RunPhase categorizes which part of an agent's lifecycle a RunError
+// happened in, so a caller can bucket failures (e.g. "80% of failures were
+// during connect") instead of string-matching the error text.
+type RunPhase string
+
+const (
+	// RunPhaseConnect covers dialing the agent and the initial PTY/SSH
+	// handshake, before any load-generating traffic is sent.
+	RunPhaseConnect RunPhase = "connect"
+	// RunPhaseWrite covers writing generated traffic to the connection.
+	RunPhaseWrite RunPhase = "write"
+	// RunPhaseRead covers reading echoed data back from the connection.
+	RunPhaseRead RunPhase = "read"
+	// RunPhaseCleanup covers closing the connection once the run has
+	// otherwise finished.
+	RunPhaseCleanup RunPhase = "cleanup"
+)
+
+// RunError wraps a failure encountered while running an agent with the
+// RunPhase it happened in, so callers can type-assert via errors.As instead
+// of string-matching an opaque wrapped error like "connect to workspace:
+// ...".
+type RunError struct {
+	Phase   RunPhase
+	AgentID uuid.UUID
+	Err     error
+}
+
+func (e *RunError) Error() string {
+	return fmt.Sprintf("%s phase, agent %s: %s", e.Phase, e.AgentID, e.Err)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// Run implements Runnable.
+func (r *Runner) Run(ctx context.Context, _ string, logs io.Writer) error {
+	ctx, span := tracing.StartSpan(ctx)
+	defer span.End()
+	callerCtx := ctx
+
+	if err := r.cfg.Validate(); err != nil {
+		return xerrors.Errorf("validate config: %w", err)
+	}
+
+	if err := r.resolveAgentByName(ctx); err != nil {
+		return xerrors.Errorf("resolve agent name: %w", err)
+	}
+
+	agentIDs := make([]string, len(r.cfg.AgentIDs))
+	for i, agentID := range r.cfg.AgentIDs {
+		agentIDs[i] = agentID.String()
+	}
+	span.SetAttributes(
+		attribute.StringSlice("coder.trafficgen.agent_ids", agentIDs),
+		attribute.Int64("coder.trafficgen.bytes_per_second", r.cfg.BytesPerSecond),
+		attribute.Int64("coder.trafficgen.ticks_per_second", r.cfg.TicksPerSecond),
+		attribute.String("coder.trafficgen.duration", time.Duration(r.cfg.Duration).String()),
+	)
+	span.SetAttributes(labelAttributes(r.cfg.Labels)...)
+
+	logs = loadtestutil.NewSyncWriter(logs)
+	logger := slog.Make(sloghuman.Sink(logs)).Leveled(r.cfg.LogLevel).With(labelFields(r.cfg.Labels)...)
+	r.client.Logger = logger
+	r.client.LogBodies = r.cfg.LogLevel <= slog.LevelDebug
+	if r.cfg.Resolver != nil || r.cfg.NetworkFamily != NetworkFamilyAuto {
+		r.client.HTTPClient.Transport = withDialResolver(r.client.HTTPClient.Transport, r.cfg.Resolver, r.cfg.NetworkFamily, logger)
+	}
+
+	if r.cfg.DryRun {
+		return r.runDryRun(ctx, logger)
+	}
+	if r.cfg.HandshakeOnly {
+		return r.runHandshakeOnly(ctx, logger)
+	}
+
+	runCtx := ctx
+	if r.cfg.RunTimeout > 0 {
+		var runCancel context.CancelFunc
+		runCtx, runCancel = context.WithTimeout(ctx, time.Duration(r.cfg.RunTimeout))
+		defer runCancel()
+	}
+
+	runDeadline := time.Duration(r.cfg.Duration)
+	if r.cfg.SteadyState && time.Duration(r.cfg.SteadyStateMaxDuration) > runDeadline {
+		runDeadline = time.Duration(r.cfg.SteadyStateMaxDuration)
+	}
+	ctx, cancel := context.WithTimeout(runCtx, runDeadline)
+	defer cancel()
+
+	agentCtx := ctx
+	var failFastOnce sync.Once
+	failFast := func() {}
+	if r.cfg.FailFast {
+		var failCancel context.CancelFunc
+		agentCtx, failCancel = context.WithCancel(ctx)
+		defer failCancel()
+		failFast = func() { failFastOnce.Do(failCancel) }
+	}
+
+	r.active.Store(true)
+	defer r.active.Store(false)
+
+	if r.cfg.HealthAddr != "" {
+		stopHealthServer, err := r.startHealthServer(logger)
+		if err != nil {
+			return xerrors.Errorf("start health server: %w", err)
+		}
+		defer stopHealthServer()
+	}
+
+	sessions := sessionsPerAgent(r.cfg)
+	bytesPerSecond := r.cfg.BytesPerSecond / int64(len(r.cfg.AgentIDs)*sessions)
+
+	logRateDrift(ctx, logger, bytesPerSecond, r.cfg.TicksPerSecond, r.cfg.UseRateLimiter, r.cfg.RateDriftWarnThreshold, r.cfg.DistributeRemainder)
+
+	start := time.Now()
+
+	var startMemStats runtime.MemStats
+	if r.cfg.SelfProfile {
+		runtime.ReadMemStats(&startMemStats)
+	}
+
+	var csvWriter io.Writer
+	if r.cfg.CSVWriter != nil {
+		csvWriter = loadtestutil.NewSyncWriter(r.cfg.CSVWriter)
+		if r.cfg.CSVHeader {
+			if err := writeCSVHeader(csvWriter); err != nil {
+				return xerrors.Errorf("write csv header: %w", err)
+			}
+		}
+	}
+
+	agentResults := make([]agentResult, len(r.cfg.AgentIDs)*sessions)
+	var wg sync.WaitGroup
+	wg.Add(len(agentResults))
+
+	var steadyStateReached bool
+	var steadyStateConvergedAfter time.Duration
+	if r.cfg.SteadyState {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			steadyStateReached, steadyStateConvergedAfter = r.monitorSteadyState(ctx, cancel, start, logger)
+		}()
+	}
+
+	i := 0
+	for _, agentID := range r.cfg.AgentIDs {
+		agentID := agentID
+		for session := 0; session < sessions; session++ {
+			idx := i
+			i++
+			go func() {
+				defer wg.Done()
+
+				agentStart := time.Now()
+				res := r.runAgent(agentCtx, logger, agentID, bytesPerSecond, start)
+				if res.err != nil {
+					logger.Warn(ctx, "agent errored", slog.F("agent_id", agentID), slog.Error(res.err))
+					if r.cfg.FailFast {
+						failFast()
+					}
+				}
+				agentResults[idx] = res
+				agentDuration := time.Since(agentStart)
+
+				if csvWriter != nil {
+					errStr := ""
+					if res.err != nil {
+						errStr = res.err.Error()
+					}
+					throughputSent := float64(res.bytesSent) / agentDuration.Seconds()
+					if err := writeCSVRow(csvWriter, agentID, agentDuration, res.bytesSent, res.bytesRcvd, throughputSent, errStr, r.cfg.Labels); err != nil {
+						logger.Warn(ctx, "write csv row failed", slog.F("agent_id", agentID), slog.Error(err))
+					}
+				}
+
+				if r.cfg.ArtifactDir != "" {
+					if err := writeAgentArtifacts(r.cfg, agentID, agentStart, agentDuration, res, res.echoSample); err != nil {
+						logger.Warn(ctx, "write agent artifacts failed", slog.F("agent_id", agentID), slog.Error(err))
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	var selfProfile *SelfProfile
+	if r.cfg.SelfProfile {
+		var endMemStats runtime.MemStats
+		runtime.ReadMemStats(&endMemStats)
+		selfProfile = &SelfProfile{
+			BytesAllocated: endMemStats.TotalAlloc - startMemStats.TotalAlloc,
+			Mallocs:        endMemStats.Mallocs - startMemStats.Mallocs,
+			GCCycles:       endMemStats.NumGC - startMemStats.NumGC,
+			GCPauseTotal:   time.Duration(endMemStats.PauseTotalNs - startMemStats.PauseTotalNs),
+		}
+	}
+
+	elapsed := time.Since(start)
+	measuredElapsed := elapsed - time.Duration(r.cfg.Warmup)
+	if measuredElapsed <= 0 {
+		measuredElapsed = elapsed
+	}
+
+	var (
+		bytesSent, wireBytesSent, bytesRcvd uint64
+		writeMessages, readFrames           uint64
+		reconnects, forcedReconnects        int
+		latencies                           []time.Duration
+		throughputSamples                   []ThroughputSample
+		connectDurations                    []time.Duration
+		ttfbSamples                         []time.Duration
+		keepaliveSamples                    []time.Duration
+		scriptCommandLatencies              []time.Duration
+		transportRTTs                       []time.Duration
+		connectAttempts                     int
+		corruptedBytes                      int64
+		missingBytes                        int64
+		maxBytesElapsed                     time.Duration
+		writeBlocked                        time.Duration
+		chunkSizeCounts                     []int64
+		reason                              Reason
+		runErr                              error
+		adaptivePeakBytesPerTick            int64
+		echoCaptureTruncated                bool
+		overrunTicks, totalTicks            int64
+		throttledTicks                      int64
+		totalErrors                         int
+		errorBudgetExhausted                bool
+		gracefulExits, forcedExits          int
+	)
+	for _, ar := range agentResults {
+		bytesSent += ar.bytesSent
+		wireBytesSent += ar.wireBytesSent
+		bytesRcvd += ar.bytesRcvd
+		writeMessages += ar.writeMessages
+		readFrames += ar.readFrames
+		reconnects += ar.reconnects
+		forcedReconnects += ar.forcedReconnects
+		latencies = append(latencies, ar.latencies...)
+		throughputSamples = mergeThroughputSamples(throughputSamples, ar.throughputSamples)
+		connectDurations = append(connectDurations, ar.connectDurations...)
+		ttfbSamples = append(ttfbSamples, ar.ttfbSamples...)
+		keepaliveSamples = append(keepaliveSamples, ar.keepaliveSamples...)
+		scriptCommandLatencies = append(scriptCommandLatencies, ar.scriptCommandLatencies...)
+		transportRTTs = append(transportRTTs, ar.transportRTTs...)
+		connectAttempts += ar.connectAttempts
+		corruptedBytes += ar.corruptedBytes
+		missingBytes += ar.missingBytes
+		writeBlocked += ar.writeBlocked
+		chunkSizeCounts = mergeChunkSizeCounts(chunkSizeCounts, ar.chunkSizeCounts)
+		if ar.maxBytesElapsed > maxBytesElapsed {
+			maxBytesElapsed = ar.maxBytesElapsed
+		}
+		if ar.adaptivePeakBytesPerTick > adaptivePeakBytesPerTick {
+			adaptivePeakBytesPerTick = ar.adaptivePeakBytesPerTick
+		}
+		if ar.echoCaptureTruncated {
+			echoCaptureTruncated = true
+		}
+		if r.cfg.GracefulShutdown {
+			if ar.exitedCleanly {
+				gracefulExits++
+			} else {
+				forcedExits++
+			}
+		}
+		overrunTicks += ar.overrunTicks
+		totalTicks += ar.totalTicks
+		throttledTicks += ar.throttledTicks
+		totalErrors += ar.errors
+		if ar.budgetExhausted {
+			errorBudgetExhausted = true
+		}
+		if ar.err != nil && runErr == nil {
+			runErr = xerrors.Errorf("agent %s: %w", ar.agentID, ar.err)
+		}
+		if reasonPriority(ar.reason) > reasonPriority(reason) {
+			reason = ar.reason
+		}
+	}
+	if steadyStateReached && reason == ReasonCanceled {
+		reason = ReasonSteadyState
+	}
+	p50, p95, p99, max := percentiles(latencies)
+	connectP50, connectP95, connectP99, connectMax := percentiles(connectDurations)
+	ttfbP50, ttfbP95, ttfbP99, ttfbMax := percentiles(ttfbSamples)
+	keepaliveP50, keepaliveP95, keepaliveP99, keepaliveMax := percentiles(keepaliveSamples)
+	scriptCommandP50, scriptCommandP95, scriptCommandP99, scriptCommandMax := percentiles(scriptCommandLatencies)
+	transportRTTP50, transportRTTP95, transportRTTP99, transportRTTMax := percentiles(transportRTTs)
+
+	readRates := make([]float64, len(agentResults))
+	for i, ar := range agentResults {
+		readRates[i] = float64(ar.bytesRcvd)
+	}
+	readRateFairnessCoV := coefficientOfVariation(readRates)
+
+	var latencyViolations int
+	if latencyThreshold := time.Duration(r.cfg.LatencyThreshold); latencyThreshold > 0 {
+		for _, l := range latencies {
+			if l > latencyThreshold {
+				latencyViolations++
+			}
+		}
+	}
+
+	var writeBlockedRatio float64
+	if totalWriteTime := measuredElapsed.Seconds() * float64(len(r.cfg.AgentIDs)); totalWriteTime > 0 {
+		writeBlockedRatio = writeBlocked.Seconds() / totalWriteTime
+	}
+
+	var overrunTickRatio float64
+	if totalTicks > 0 {
+		overrunTickRatio = float64(overrunTicks) / float64(totalTicks)
+	}
+
+	var throttledTickRatio float64
+	if totalTicks > 0 {
+		throttledTickRatio = float64(throttledTicks) / float64(totalTicks)
+	}
+
+	chunkSizeBuckets := make([]ChunkSizeBucket, len(chunkSizeCounts))
+	for i, count := range chunkSizeCounts {
+		upperBound := int64(-1)
+		if i < len(chunkSizeBucketBounds) {
+			upperBound = chunkSizeBucketBounds[i]
+		}
+		chunkSizeBuckets[i] = ChunkSizeBucket{UpperBound: upperBound, Count: count}
+	}
+
+	var traceID string
+	if span.SpanContext().HasTraceID() {
+		traceID = span.SpanContext().TraceID().String()
+	}
+
+	res := Results{
+		SchemaVersion:      resultsSchemaVersion,
+		TraceID:            traceID,
+		Labels:             r.cfg.Labels,
+		Reason:             reason,
+		Duration:           measuredElapsed,
+		BytesSent:          bytesSent,
+		WireBytesSent:      wireBytesSent,
+		BytesRcvd:          bytesRcvd,
+		MessagesSent:       writeMessages,
+		FramesRcvd:         readFrames,
+		ThroughputSent:     float64(bytesSent) / measuredElapsed.Seconds(),
+		WireThroughputSent: float64(wireBytesSent) / measuredElapsed.Seconds(),
+		ThroughputRcvd:     float64(bytesRcvd) / measuredElapsed.Seconds(),
+		LatencySamples:     len(latencies),
+		LatencyP50:         p50,
+		LatencyP95:         p95,
+		LatencyP99:         p99,
+		LatencyMax:         max,
+
+		LatencyViolations: latencyViolations,
+
+		ConnectSamples:  len(connectDurations),
+		ConnectP50:      connectP50,
+		ConnectP95:      connectP95,
+		ConnectP99:      connectP99,
+		ConnectMax:      connectMax,
+		ConnectAttempts: connectAttempts,
+
+		TTFBSamples: len(ttfbSamples),
+		TTFBP50:     ttfbP50,
+		TTFBP95:     ttfbP95,
+		TTFBP99:     ttfbP99,
+		TTFBMax:     ttfbMax,
+
+		KeepaliveSamples: len(keepaliveSamples),
+		KeepaliveP50:     keepaliveP50,
+		KeepaliveP95:     keepaliveP95,
+		KeepaliveP99:     keepaliveP99,
+		KeepaliveMax:     keepaliveMax,
+
+		ScriptCommandSamples: len(scriptCommandLatencies),
+		ScriptCommandP50:     scriptCommandP50,
+		ScriptCommandP95:     scriptCommandP95,
+		ScriptCommandP99:     scriptCommandP99,
+		ScriptCommandMax:     scriptCommandMax,
+
+		CorruptedBytes: corruptedBytes,
+		MissingBytes:   missingBytes,
+
+		MaxBytesElapsed: maxBytesElapsed,
+
+		WriteBlockedDuration: writeBlocked,
+		WriteBlockedRatio:    writeBlockedRatio,
+
+		OverrunTicks:     overrunTicks,
+		OverrunTickRatio: overrunTickRatio,
+
+		ThrottledTicks:     throttledTicks,
+		ThrottledTickRatio: throttledTickRatio,
+
+		TotalErrors:          totalErrors,
+		ErrorBudgetExhausted: errorBudgetExhausted,
+
+		ChunkSizeBuckets: chunkSizeBuckets,
+
+		ThroughputSamples: throughputSamples,
+
+		ByCommand: aggregateByCommand(agentResults, measuredElapsed),
+
+		EchoCaptureTruncated: echoCaptureTruncated,
+
+		GracefulExits: gracefulExits,
+		ForcedExits:   forcedExits,
+
+		SelfProfile: selfProfile,
+
+		SteadyStateReached:        steadyStateReached,
+		SteadyStateConvergedAfter: steadyStateConvergedAfter,
+
+		TransportRTTSamples: len(transportRTTs),
+		TransportRTTP50:     transportRTTP50,
+		TransportRTTP95:     transportRTTP95,
+		TransportRTTP99:     transportRTTP99,
+		TransportRTTMax:     transportRTTMax,
+	}
+	if r.cfg.Pattern == TrafficPatternAdaptive {
+		res.AdaptivePeakBytesPerSecond = float64(adaptivePeakBytesPerTick) * float64(r.cfg.TicksPerSecond)
+	}
+	res.MessagesPerSecond = float64(res.MessagesSent) / measuredElapsed.Seconds()
+	res.FramesPerSecond = float64(res.FramesRcvd) / measuredElapsed.Seconds()
+	res.ThroughputAchievedRatio = res.ThroughputSent / float64(r.cfg.BytesPerSecond)
+	if bytesSent > 0 {
+		res.FramingOverheadRatio = float64(wireBytesSent-bytesSent) / float64(bytesSent)
+	}
+	if r.cfg.ReadBytesPerSecond > 0 {
+		res.ReadThroughputAchievedRatio = res.ThroughputRcvd / float64(r.cfg.ReadBytesPerSecond)
+	}
+	res.ReadRateFairnessCoV = readRateFairnessCoV
+
+	sampleInterval := time.Duration(r.cfg.ThroughputSampleInterval)
+	if sampleInterval <= 0 {
+		sampleInterval = DefaultThroughputSampleInterval
+	}
+	res.ThroughputSentP5, res.ThroughputSentP50, res.ThroughputSentP95 = throughputPercentiles(throughputSamples, sampleInterval, func(s ThroughputSample) int64 { return s.BytesWritten })
+	res.ThroughputRcvdP5, res.ThroughputRcvdP50, res.ThroughputRcvdP95 = throughputPercentiles(throughputSamples, sampleInterval, func(s ThroughputSample) int64 { return s.BytesRead })
+	if time.Duration(r.cfg.LatencyThreshold) > 0 && len(latencies) > 0 {
+		res.LatencyViolationRatio = float64(res.LatencyViolations) / float64(len(latencies))
+		if res.LatencyViolationRatio > r.cfg.LatencyViolationBudget && runErr == nil {
+			runErr = ErrLatencySLOExceeded
+		}
+	}
+	res.Reconnects = reconnects
+	res.ForcedReconnects = forcedReconnects
+	zeroBytes := res.BytesSent == 0 || res.BytesRcvd == 0
+	if zeroBytes && r.cfg.FailOnZeroBytes && runErr == nil {
+		runErr = ErrZeroBytesTransferred
+	}
+	if runErr != nil {
+		res.Error = runErr.Error()
+	}
+
+	span.SetAttributes(
+		attribute.Int64("coder.trafficgen.bytes_sent", int64(res.BytesSent)),
+		attribute.Int64("coder.trafficgen.bytes_rcvd", int64(res.BytesRcvd)),
+	)
+	if runErr != nil {
+		span.SetAttributes(attribute.String("coder.trafficgen.error", runErr.Error()))
+	}
+
+	logFields := []slog.Field{
+		slog.F("agents", len(r.cfg.AgentIDs)),
+		slog.F("reason", res.Reason),
+		slog.F("write_bytes", res.BytesSent),
+		slog.F("wire_write_bytes", res.WireBytesSent),
+		slog.F("framing_overhead_ratio", res.FramingOverheadRatio),
+		slog.F("messages_sent", res.MessagesSent),
+		slog.F("messages_per_second", res.MessagesPerSecond),
+		slog.F("read_bytes", res.BytesRcvd),
+		slog.F("frames_rcvd", res.FramesRcvd),
+		slog.F("frames_per_second", res.FramesPerSecond),
+		slog.F("latency_samples", res.LatencySamples),
+		slog.F("latency_p50", res.LatencyP50),
+		slog.F("latency_p95", res.LatencyP95),
+		slog.F("latency_p99", res.LatencyP99),
+		slog.F("latency_max", res.LatencyMax),
+		slog.F("latency_violations", res.LatencyViolations),
+		slog.F("latency_violation_ratio", res.LatencyViolationRatio),
+		slog.F("connect_samples", res.ConnectSamples),
+		slog.F("connect_p50", res.ConnectP50),
+		slog.F("connect_p95", res.ConnectP95),
+		slog.F("connect_p99", res.ConnectP99),
+		slog.F("connect_max", res.ConnectMax),
+		slog.F("connect_attempts", res.ConnectAttempts),
+		slog.F("ttfb_samples", res.TTFBSamples),
+		slog.F("ttfb_p50", res.TTFBP50),
+		slog.F("ttfb_p95", res.TTFBP95),
+		slog.F("ttfb_p99", res.TTFBP99),
+		slog.F("ttfb_max", res.TTFBMax),
+		slog.F("keepalive_samples", res.KeepaliveSamples),
+		slog.F("keepalive_p50", res.KeepaliveP50),
+		slog.F("keepalive_p95", res.KeepaliveP95),
+		slog.F("keepalive_p99", res.KeepaliveP99),
+		slog.F("keepalive_max", res.KeepaliveMax),
+		slog.F("script_command_samples", res.ScriptCommandSamples),
+		slog.F("script_command_p50", res.ScriptCommandP50),
+		slog.F("corrupted_bytes", res.CorruptedBytes),
+		slog.F("missing_bytes", res.MissingBytes),
+		slog.F("max_bytes_elapsed", res.MaxBytesElapsed),
+		slog.F("write_blocked_duration", res.WriteBlockedDuration),
+		slog.F("write_blocked_ratio", res.WriteBlockedRatio),
+		slog.F("overrun_ticks", res.OverrunTicks),
+		slog.F("overrun_tick_ratio", res.OverrunTickRatio),
+		slog.F("throttled_ticks", res.ThrottledTicks),
+		slog.F("throttled_tick_ratio", res.ThrottledTickRatio),
+		slog.F("chunk_size_buckets", res.ChunkSizeBuckets),
+		slog.F("by_command", res.ByCommand),
+		slog.F("throughput_achieved_ratio", res.ThroughputAchievedRatio),
+		slog.F("throughput_sent_p5", res.ThroughputSentP5),
+		slog.F("throughput_sent_p50", res.ThroughputSentP50),
+		slog.F("throughput_sent_p95", res.ThroughputSentP95),
+		slog.F("throughput_rcvd_p5", res.ThroughputRcvdP5),
+		slog.F("throughput_rcvd_p50", res.ThroughputRcvdP50),
+		slog.F("throughput_rcvd_p95", res.ThroughputRcvdP95),
+		slog.F("read_throughput_achieved_ratio", res.ReadThroughputAchievedRatio),
+		slog.F("read_rate_fairness_cov", res.ReadRateFairnessCoV),
+		slog.F("reconnects", res.Reconnects),
+		slog.F("forced_reconnects", res.ForcedReconnects),
+		slog.F("total_errors", res.TotalErrors),
+		slog.F("error_budget_exhausted", res.ErrorBudgetExhausted),
+		slog.F("throughput_samples", len(res.ThroughputSamples)),
+	}
+	if r.cfg.SteadyState {
+		logFields = append(logFields,
+			slog.F("steady_state_reached", res.SteadyStateReached),
+			slog.F("steady_state_converged_after", res.SteadyStateConvergedAfter),
+		)
+	}
+	if r.cfg.TransportRTT {
+		logFields = append(logFields,
+			slog.F("transport_rtt_samples", res.TransportRTTSamples),
+			slog.F("transport_rtt_p50", res.TransportRTTP50),
+			slog.F("transport_rtt_p95", res.TransportRTTP95),
+			slog.F("transport_rtt_p99", res.TransportRTTP99),
+			slog.F("transport_rtt_max", res.TransportRTTMax),
+		)
+	}
+	if r.cfg.Pattern == TrafficPatternAdaptive {
+		logFields = append(logFields, slog.F("adaptive_peak_bytes_per_second", res.AdaptivePeakBytesPerSecond))
+	}
+	if runErr != nil {
+		logFields = append(logFields, slog.Error(runErr))
+		logger.Warn(ctx, "results (partial, run ended with an error)", logFields...)
+	} else {
+		logger.Info(ctx, "results", logFields...)
+	}
+
+	if zeroBytes {
+		logger.Warn(ctx, "run transferred zero bytes, target command may have exited immediately or never echoed",
+			slog.F("bytes_sent", res.BytesSent),
+			slog.F("bytes_rcvd", res.BytesRcvd),
+			slog.F("fail_on_zero_bytes", r.cfg.FailOnZeroBytes),
+		)
+	}
+
+	throughputWarnThreshold := r.cfg.ThroughputWarnThreshold
+	if throughputWarnThreshold == 0 {
+		throughputWarnThreshold = DefaultThroughputWarnThreshold
+	}
+	if res.ThroughputAchievedRatio < throughputWarnThreshold {
+		logger.Warn(ctx, "throughput target not sustained, agents may be saturated",
+			slog.F("throughput_achieved_ratio", res.ThroughputAchievedRatio),
+			slog.F("throughput_warn_threshold", throughputWarnThreshold),
+		)
+	}
+	if r.cfg.ReadBytesPerSecond > 0 && res.ReadThroughputAchievedRatio < throughputWarnThreshold {
+		logger.Warn(ctx, "read throughput target not sustained, agents may be saturated",
+			slog.F("read_throughput_achieved_ratio", res.ReadThroughputAchievedRatio),
+			slog.F("throughput_warn_threshold", throughputWarnThreshold),
+		)
+	}
+	if time.Duration(r.cfg.LatencyThreshold) > 0 && res.LatencyViolationRatio > r.cfg.LatencyViolationBudget {
+		logger.Warn(ctx, "latency SLO budget exceeded",
+			slog.F("latency_violations", res.LatencyViolations),
+			slog.F("latency_violation_ratio", res.LatencyViolationRatio),
+			slog.F("latency_violation_budget", r.cfg.LatencyViolationBudget),
+		)
+	}
+
+	if r.cfg.MetricsWriter != nil {
+		if err := writeMetrics(r.cfg.MetricsWriter, res, r.cfg.MetricsCompression); err != nil {
+			if runErr == nil {
+				runErr = xerrors.Errorf("write metrics: %w", err)
+			}
+		}
+	}
+
+	if r.cfg.ResultsEndpoint != "" {
+		// callerCtx, not ctx: ctx's own deadline is Config.Duration, which has
+		// just elapsed by this point in Run, so posting against it would fail
+		// immediately instead of getting its own bounded timeout.
+		if err := postResults(callerCtx, r.cfg.ResultsEndpoint, res); err != nil {
+			logger.Warn(ctx, "post results to results_endpoint failed", slog.F("results_endpoint", r.cfg.ResultsEndpoint), slog.Error(err))
+		}
+	}
+
+	if runErr == nil && r.cfg.ResultValidator != nil {
+		if err := r.cfg.ResultValidator(res); err != nil {
+			runErr = xerrors.Errorf("result validator: %w", err)
+		}
+	}
+
+	if callerCtx.Err() != nil {
+		if runErr != nil {
+			return xerrors.Errorf("%w: %v", ErrInterrupted, runErr)
+		}
+		return ErrInterrupted
+	}
+
+	return runErr
+}
+
+// agentResult holds one agent's contribution to the run's aggregate
+// Results, gathered by runAgent and combined by Run once every agent has
+// finished.
+type agentResult struct {
+	agentID           uuid.UUID
+	command           string
+	bytesSent         uint64
+	wireBytesSent     uint64
+	bytesRcvd         uint64
+	writeMessages     uint64
+	readFrames        uint64
+	reconnects        int
+	forcedReconnects  int
+	latencies         []time.Duration
+	throughputSamples []ThroughputSample
+	connectDurations  []time.Duration
+	connectAttempts   int
+	transportRTTs     []time.Duration
+	corruptedBytes    int64
+	missingBytes      int64
+	maxBytesElapsed   time.Duration
+	writeBlocked      time.Duration
+	chunkSizeCounts   []int64
+	reason            Reason
+	err               error
+
+	// adaptivePeakBytesPerTick is the highest bytesPerTick reached by
+	// TrafficPatternAdaptive's feedback loop before any backoff. Zero unless
+	// Config.Pattern is TrafficPatternAdaptive.
+	adaptivePeakBytesPerTick int64
+
+	// echoCaptureTruncated is true if Config.EchoCaptureMaxBytes was reached
+	// while draining this agent's connection. Always false unless
+	// Config.EchoCapture is set.
+	echoCaptureTruncated bool
+
+	// echoSample holds up to Config.ArtifactEchoSampleMaxBytes of whatever
+	// this agent read back, for writeAgentArtifacts to save alongside the
+	// rest of its artifacts. Always nil unless Config.ArtifactDir is set.
+	echoSample []byte
+
+	// exitedCleanly is true if this agent's shell acknowledged
+	// Config.ExitSequence before Config.GracefulShutdownGrace elapsed.
+	// Always false unless Config.GracefulShutdown is set.
+	exitedCleanly bool
+
+	// overrunTicks and totalTicks count, respectively, the ticks whose Write
+	// took longer than the tick interval to complete and the ticks that
+	// fired in total, across this agent's tickedWriteLoop. Both are zero if
+	// Config.UseRateLimiter is set, since rateLimitedWriteLoop has no notion
+	// of discrete ticks.
+	overrunTicks int64
+	totalTicks   int64
+
+	// throttledTicks counts the ticks whose write was delayed because
+	// Config.MaxInFlight was already reached, across this agent's
+	// tickedWriteLoop. Zero unless MaxInFlight is set.
+	throttledTicks int64
+
+	// errors counts this agent's total read/write failures, whether or not
+	// each was recovered by a reconnect. budgetExhausted is true if errors
+	// exceeded Config.MaxErrors, meaning this agent's err, if non-nil, is the
+	// error budget being exhausted rather than an unrecoverable dial
+	// failure. errors is counted whether or not MaxErrors is set;
+	// budgetExhausted is always false unless MaxErrors is set.
+	errors          int
+	budgetExhausted bool
+
+	// ttfbSamples is the time-to-first-byte observed on this agent's initial
+	// connection and every reconnect after it, in the order they occurred.
+	ttfbSamples []time.Duration
+
+	// keepaliveSamples is the round-trip time of every keepalive ping this
+	// agent sent, matched against its echo. Always empty unless
+	// Config.KeepaliveInterval is set.
+	keepaliveSamples []time.Duration
+
+	// scriptCommandLatencies is the completion time of every Config.ScriptPath
+	// command this agent ran, from the command being sent to its marker
+	// being read back. Always empty unless Config.ScriptWaitForMarker is
+	// set.
+	scriptCommandLatencies []time.Duration
+}
+
+// runAgent drives the full traffic loop (dial, write, drain, progress)
+// against a single agent and returns its contribution to the aggregate
+// Results. bytesPerSecond is this agent's share of Config.BytesPerSecond,
+// already divided across Config.AgentIDs by the caller. err is nil if the
+// loop ran to completion or stopped because ctx was done; any other
+// failure is returned via err so Run can decide whether to trigger
+// FailFast.
+func (r *Runner) runAgent(ctx context.Context, logger slog.Logger, agentID uuid.UUID, bytesPerSecond int64, start time.Time) (res agentResult) {
+	command := r.resolveCommand()
+	res = agentResult{agentID: agentID, command: command}
+
+	reconnectToken := r.cfg.ReconnectToken
+	if reconnectToken == uuid.Nil {
+		reconnectToken = uuid.New()
+	}
+	dialStart := time.Now()
+	rw, closeConn, attempts, err := r.dialWithRetry(ctx, logger, agentID, reconnectToken, command)
+	res.connectAttempts = attempts
+	if err != nil {
+		res.err = &RunError{Phase: RunPhaseConnect, AgentID: agentID, Err: xerrors.Errorf("dial agent: %w", err)}
+		res.reason = ReasonError
+		return res
+	}
+	initialConnectDuration := time.Since(dialStart)
+
+	// idleCtx is derived from ctx so that an idle timeout, detected only by
+	// the read side, can cut this agent's write loop short too, rather than
+	// leaving it running until the full Duration elapses. Everything else
+	// (dialing, reconnects) keeps using ctx directly.
+	idleCtx, idleCancel := context.WithCancel(ctx)
+	defer idleCancel()
+
+	cm := newConnManager(ctx, rw, closeConn, r.cfg.Reconnect, r.cfg.MaxReconnects, r.cfg.MaxErrors, time.Now(), func(dialCtx context.Context) (io.ReadWriter, func() error, error) {
+		return r.dial(dialCtx, logger, agentID, reconnectToken, command)
+	})
+	r.trackConn(agentID, reconnectToken, cm.close)
+
+	var readExited chan struct{}
+	if r.cfg.LeakCheck {
+		readExited = make(chan struct{})
+	}
+
+	defer func() {
+		if r.cfg.GracefulShutdown {
+			res.exitedCleanly = gracefulShutdown(cm, r.cfg.ExitSequence, time.Duration(r.cfg.GracefulShutdownGrace))
+		}
+		closeErr := cm.close()
+		r.untrackConn(reconnectToken)
+		if r.cfg.LeakCheck {
+			r.checkLeak(ctx, logger, agentID, readExited, closeErr)
+		}
+	}()
+
+	var metrics *promMetrics
+	if r.cfg.MetricsRegistry != nil {
+		metrics = registerPromMetrics(r.cfg.MetricsRegistry, agentID.String(), r.cfg.Labels)
+	}
+	sampleInterval := time.Duration(r.cfg.ThroughputSampleInterval)
+	if sampleInterval <= 0 {
+		sampleInterval = DefaultThroughputSampleInterval
+	}
+	crw := newCountReadWriter(cm, metrics, time.Duration(r.cfg.Warmup), start, sampleInterval, r.cfg.VerifyEcho)
+	r.trackCRW(reconnectToken, crw)
+	defer r.untrackCRW(reconnectToken)
+
+	readBufferSize := r.cfg.ReadBufferSize
+	if readBufferSize == 0 {
+		readBufferSize = DefaultReadBufferSize
+	}
+
+	var echoCapture *cappedWriter
+	var echoCaptureW io.Writer
+	if r.cfg.EchoCapture != nil {
+		maxBytes := r.cfg.EchoCaptureMaxBytes
+		if maxBytes <= 0 {
+			maxBytes = DefaultEchoCaptureMaxBytes
+		}
+		echoCapture = newCappedWriter(r.cfg.EchoCapture, maxBytes)
+		echoCaptureW = echoCapture
+	}
+
+	// artifactEchoBuf backs res.echoSample, independent of echoCapture/
+	// Config.EchoCapture above - it's written to this agent's own artifact
+	// directory rather than a caller-supplied shared writer. See
+	// writeAgentArtifacts.
+	var artifactEchoBuf *bytes.Buffer
+	captureW := echoCaptureW
+	if r.cfg.ArtifactDir != "" {
+		maxBytes := r.cfg.ArtifactEchoSampleMaxBytes
+		if maxBytes <= 0 {
+			maxBytes = DefaultArtifactEchoSampleMaxBytes
+		}
+		artifactEchoBuf = &bytes.Buffer{}
+		artifactEchoW := newCappedWriter(artifactEchoBuf, maxBytes)
+		if captureW != nil {
+			captureW = io.MultiWriter(captureW, artifactEchoW)
+		} else {
+			captureW = artifactEchoW
+		}
+	}
+
+	// drainCtx, unlike idleCtx, isn't bound to ctx's Duration deadline: it's
+	// only canceled once the write loop has actually stopped (whether from
+	// the deadline, MaxBytes, or an error), plus Config.DrainTimeout more
+	// (see the drainCancel trigger below), so echoes still in flight when
+	// writing stops get a chance to arrive instead of being cut off by the
+	// same deadline that ended the write loop.
+	drainCtx, drainCancel := context.WithCancel(context.Background())
+	defer drainCancel()
+
+	// g joins the background read/progress/reconnect goroutines deterministically:
+	// g.Wait() below blocks until every one of them has returned, and
+	// g.Go's first non-nil error wins, rather than relying on a shared
+	// drainErr variable that a goroutine writes to before signaling a
+	// sync.WaitGroup.
+	var g errgroup.Group
+	g.Go(func() error {
+		err := drainContext(drainCtx, crw, readBufferSize, readExited, captureW, time.Duration(r.cfg.IdleTimeout))
+		if xerrors.Is(err, errReadIdleTimeout) {
+			idleCancel()
+		}
+		return err
+	})
+
+	if len(r.cfg.SetupCommands) > 0 {
+		crw.beginSetup()
+		err := runSetupCommands(idleCtx, crw, r.cfg.SetupCommands, time.Duration(r.cfg.SetupSettleDelay))
+		crw.endSetup()
+		if err != nil {
+			res.err = &RunError{Phase: RunPhaseWrite, AgentID: agentID, Err: xerrors.Errorf("run setup commands: %w", err)}
+			res.reason = ReasonError
+			return res
+		}
+	}
+
+	if r.cfg.ProgressFn != nil {
+		g.Go(func() error {
+			reportProgress(ctx, time.Duration(r.cfg.ProgressInterval), start, crw, r.cfg.ProgressFn)
+			return nil
+		})
+	}
+
+	if time.Duration(r.cfg.ReconnectInterval) > 0 {
+		g.Go(func() error {
+			forceReconnectLoop(idleCtx, logger, cm, time.Duration(r.cfg.ReconnectInterval))
+			return nil
+		})
+	}
+
+	payloadPrefix := r.cfg.PayloadPrefix
+	if payloadPrefix == "" {
+		payloadPrefix = DefaultPayloadPrefix
+	}
+	var scriptCommands []string
+	if r.cfg.ScriptPath != "" {
+		scriptCommands, err = loadScript(r.cfg.ScriptPath)
+		if err != nil {
+			res.err = &RunError{Phase: RunPhaseConnect, AgentID: agentID, Err: xerrors.Errorf("load script: %w", err)}
+			res.reason = ReasonError
+			return res
+		}
+	}
+	genPayload := newPayloadGenerator(r.cfg.Seed, payloadPrefix, r.cfg.PayloadEntropy, scriptCommands)
+
+	budget := newByteBudget(r.cfg.MaxBytes / int64(len(r.cfg.AgentIDs)*sessionsPerAgent(r.cfg)))
+
+	if r.cfg.ReplayFile != "" {
+		replayEvents, err := loadReplay(r.cfg.ReplayFile)
+		if err != nil {
+			res.err = &RunError{Phase: RunPhaseConnect, AgentID: agentID, Err: xerrors.Errorf("load replay: %w", err)}
+			res.reason = ReasonError
+			return res
+		}
+		if err := replayWriteLoop(idleCtx, crw, replayEvents, r.cfg.ReplayLoop, budget); err != nil {
+			res.err = &RunError{Phase: RunPhaseWrite, AgentID: agentID, Err: err}
+		}
+	} else if r.cfg.ScriptWaitForMarker {
+		var err error
+		res.scriptCommandLatencies, err = scriptedRequestResponseLoop(idleCtx, crw, scriptCommands, time.Duration(r.cfg.MarkerTimeout), budget)
+		if err != nil {
+			res.err = &RunError{Phase: RunPhaseWrite, AgentID: agentID, Err: err}
+		}
+	} else if r.cfg.UseRateLimiter {
+		if err := rateLimitedWriteLoop(idleCtx, crw, bytesPerSecond, genPayload, budget, time.Duration(r.cfg.KeystrokeDelay), r.cfg.GlobalLimiter, r.cfg.LineLength, time.Duration(r.cfg.KeepaliveInterval), payloadPrefix); err != nil {
+			res.err = &RunError{Phase: RunPhaseWrite, AgentID: agentID, Err: err}
+		}
+	} else {
+		tickCfg := r.cfg
+		tickCfg.BytesPerSecond = bytesPerSecond
+		var err error
+		res.adaptivePeakBytesPerTick, res.overrunTicks, res.totalTicks, res.throttledTicks, err = tickedWriteLoop(idleCtx, crw, tickCfg, start, genPayload, budget, payloadPrefix)
+		if err != nil {
+			res.err = &RunError{Phase: RunPhaseWrite, AgentID: agentID, Err: err}
+		}
+	}
+
+	if drainTimeout := time.Duration(r.cfg.DrainTimeout); drainTimeout > 0 {
+		time.AfterFunc(drainTimeout, drainCancel)
+	} else {
+		drainCancel()
+	}
+
+	drainErr := g.Wait()
+
+	res.bytesSent = uint64(crw.writeBytes())
+	res.wireBytesSent = uint64(crw.wireWriteBytes())
+	res.bytesRcvd = uint64(crw.readBytes())
+	res.writeMessages = uint64(crw.writeMessageCount())
+	res.readFrames = uint64(crw.readFrameCount())
+	res.reconnects = cm.reconnectCount()
+	res.forcedReconnects = cm.forcedReconnectCount()
+	res.errors = cm.errorCount()
+	res.budgetExhausted = cm.budgetExhausted()
+	res.ttfbSamples = cm.ttfbDurations()
+	res.transportRTTs = r.takeTransportRTTSamples(reconnectToken)
+	res.latencies = crw.latencySamples()
+	res.keepaliveSamples = crw.keepaliveSamples()
+	res.throughputSamples = crw.throughputSamples()
+	res.connectDurations = append([]time.Duration{initialConnectDuration}, cm.reconnectDurations()...)
+	res.corruptedBytes = crw.corruptedBytes()
+	res.missingBytes = crw.missingBytes()
+	res.writeBlocked = crw.writeBlockedDuration()
+	res.chunkSizeCounts = crw.chunkSizeCounts()
+	if echoCapture != nil {
+		res.echoCaptureTruncated = echoCapture.truncated
+	}
+	if artifactEchoBuf != nil {
+		res.echoSample = artifactEchoBuf.Bytes()
+	}
+	if budget != nil && !budget.doneAt.IsZero() {
+		res.maxBytesElapsed = budget.doneAt.Sub(start)
+	}
+
+	switch {
+	case res.err != nil:
+		res.reason = ReasonError
+	case xerrors.Is(drainErr, errReadIdleTimeout):
+		res.reason = ReasonStalled
+	case budget != nil && !budget.doneAt.IsZero():
+		res.reason = ReasonMaxBytes
+	case xerrors.Is(ctx.Err(), context.Canceled):
+		res.reason = ReasonCanceled
+	case drainErr != nil && !xerrors.Is(drainErr, context.Canceled):
+		res.reason = ReasonEOF
+	default:
+		res.reason = ReasonDeadline
+	}
+	return res
+}
+
+// trackConn records a session's live connection, keyed by its reconnect
+// token, so Cleanup can close it if Run is interrupted before runAgent's own
+// defer gets a chance to.
+func (r *Runner) trackConn(agentID uuid.UUID, reconnectToken uuid.UUID, close func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns[reconnectToken] = &openConn{agentID: agentID, close: close}
+}
+
+// untrackConn removes a session's connection once runAgent has closed it
+// normally, so Cleanup doesn't try to close it again.
+func (r *Runner) untrackConn(reconnectToken uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, reconnectToken)
+}
+
+// trackCRW records a session's countReadWriter, keyed by its reconnect
+// token, so Stats can read its live counters.
+func (r *Runner) trackCRW(reconnectToken uuid.UUID, crw *countReadWriter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.crws[reconnectToken] = crw
+}
+
+// untrackCRW removes a session's countReadWriter once runAgent has finished
+// with it, so Stats stops counting its (now final) contribution.
+func (r *Runner) untrackCRW(reconnectToken uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.crws, reconnectToken)
+}
+
+// recordTransportRTT appends a transport-level RTT sample for the session
+// keyed by reconnectToken, taken once per dial (the initial connection and
+// every reconnect). Only called from dial's ConnectionTypeSSH branch when
+// Config.TransportRTT is set.
+func (r *Runner) recordTransportRTT(reconnectToken uuid.UUID, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rtts[reconnectToken] = append(r.rtts[reconnectToken], d)
+}
+
+// takeTransportRTTSamples returns and clears the transport RTT samples
+// recorded for reconnectToken, called once by runAgent after a session ends.
+func (r *Runner) takeTransportRTTSamples(reconnectToken uuid.UUID) []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	samples := r.rtts[reconnectToken]
+	delete(r.rtts, reconnectToken)
+	return samples
+}
+
+// checkLeak is called after an agent's connection has been closed, when
+// Config.LeakCheck is set. It waits up to Config.LeakCheckGrace for
+// readExited to close, confirming the background goroutine driving
+// drainContext's blocking Read didn't outlive the run, and logs a warning if
+// either that goroutine is still running once the grace period elapses or
+// closeErr (the error, if any, returned by closing the connection) is
+// non-nil.
+func (r *Runner) checkLeak(ctx context.Context, logger slog.Logger, agentID uuid.UUID, readExited <-chan struct{}, closeErr error) {
+	grace := time.Duration(r.cfg.LeakCheckGrace)
+	if grace <= 0 {
+		grace = DefaultLeakCheckGrace
+	}
+
+	select {
+	case <-readExited:
+	case <-time.After(grace):
+		logger.Warn(ctx, "leak check: read goroutine still running past grace period",
+			slog.F("agent_id", agentID),
+			slog.F("leak_check_grace", grace),
+		)
+	}
+
+	if closeErr != nil {
+		logger.Warn(ctx, "leak check: connection did not close cleanly",
+			slog.F("agent_id", agentID),
+			slog.Error(closeErr),
+		)
+	}
+}
+
+// Cleanup implements harness.Cleanable. It closes any agent connection still
+// open from an interrupted Run, so the corresponding reconnecting PTY
+// session on the agent doesn't linger until it times out server-side.
+func (r *Runner) Cleanup(_ context.Context, _ string) error {
+	r.mu.Lock()
+	conns := r.conns
+	r.conns = make(map[uuid.UUID]*openConn)
+	r.mu.Unlock()
+
+	var err error
+	for _, conn := range conns {
+		if cerr := conn.close(); cerr != nil && err == nil {
+			err = xerrors.Errorf("close lingering connection for agent %s: %w", conn.agentID, cerr)
+		}
+	}
+	return err
+}
+
+// RunBatch runs every Runner in runners concurrently against a shared
+// derivative of ctx, each using its own id and logs writer, and returns
+// their errors (nil for a clean run) in the same order as runners. Each
+// Runner's Run already responds to ctx cancellation on its own (returning
+// ErrInterrupted), so canceling the shared context here is enough to abort
+// every other runner in the batch early.
+//
+// If failFast is set, the first fatal error - currently, a *RunError with
+// Phase RunPhaseConnect, i.e. an agent that couldn't be reached at all
+// rather than one that merely misbehaved after connecting - cancels the
+// shared context, so the rest of the batch aborts instead of each runner
+// running out its own full Duration. A non-fatal error (a single agent's
+// write or read failing, a latency SLO violation, etc.) doesn't trigger the
+// abort, since it isn't evidence the whole batch is doomed.
+func RunBatch(ctx context.Context, runners []*Runner, ids []string, logs []io.Writer, failFast bool) []error {
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var abortOnce sync.Once
+	abort := func() { abortOnce.Do(cancel) }
+
+	errs := make([]error, len(runners))
+	var wg sync.WaitGroup
+	wg.Add(len(runners))
+	for i, runner := range runners {
+		i, runner := i, runner
+		go func() {
+			defer wg.Done()
+			err := runner.Run(batchCtx, ids[i], logs[i])
+			errs[i] = err
+			if failFast && isFatalBatchError(err) {
+				abort()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// isFatalBatchError reports whether err is the kind of failure RunBatch's
+// failFast aborts the rest of the batch for.
+func isFatalBatchError(err error) bool {
+	var runErr *RunError
+	if xerrors.As(err, &runErr) {
+		return runErr.Phase == RunPhaseConnect
+	}
+	return false
+}
+
+// dryRunTimeout bounds how long a single agent's connectivity check may
+// take in DryRun mode. Config.Duration isn't meaningful there, since no
+// load is generated.
+const dryRunTimeout = 30 * time.Second
+
+// runDryRun confirms every agent in Config.AgentIDs is reachable and its
+// PTY/SSH handshake succeeds, without generating any load, and reports
+// success or failure per agent. It returns the first agent's error, if
+// any, wrapped with that agent's ID.
+func (r *Runner) runDryRun(ctx context.Context, logger slog.Logger) error {
+	errs := make([]error, len(r.cfg.AgentIDs))
+
+	var wg sync.WaitGroup
+	wg.Add(len(r.cfg.AgentIDs))
+	for i, agentID := range r.cfg.AgentIDs {
+		i, agentID := i, agentID
+		go func() {
+			defer wg.Done()
+			errs[i] = r.dryRunAgent(ctx, logger, agentID)
+		}()
+	}
+	wg.Wait()
+
+	var failed int
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		if firstErr == nil {
+			firstErr = xerrors.Errorf("agent %s: %w", r.cfg.AgentIDs[i], err)
+		}
+	}
+
+	logger.Info(ctx, "dry run complete",
+		slog.F("agents", len(r.cfg.AgentIDs)),
+		slog.F("failed", failed),
+	)
+
+	return firstErr
+}
+
+// dryRunAgent dials agentID, writes a single newline to confirm the
+// PTY/SSH handshake completes and the shell echoes a prompt back, then
+// closes the connection.
+func (r *Runner) dryRunAgent(ctx context.Context, logger slog.Logger, agentID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, dryRunTimeout)
+	defer cancel()
+
+	rw, closeConn, err := r.dial(ctx, logger, agentID, uuid.New(), r.resolveCommand())
+	if err != nil {
+		logger.Warn(ctx, "dry run: agent unreachable", slog.F("agent_id", agentID), slog.Error(err))
+		return &RunError{Phase: RunPhaseConnect, AgentID: agentID, Err: xerrors.Errorf("dial agent: %w", err)}
+	}
+	defer func() { _ = closeConn() }()
+
+	if _, err := rw.Write([]byte("\n")); err != nil {
+		logger.Warn(ctx, "dry run: handshake write failed", slog.F("agent_id", agentID), slog.Error(err))
+		return &RunError{Phase: RunPhaseWrite, AgentID: agentID, Err: xerrors.Errorf("write handshake newline: %w", err)}
+	}
+
+	buf := make([]byte, DefaultReadBufferSize)
+	if _, err := rw.Read(buf); err != nil {
+		logger.Warn(ctx, "dry run: handshake read failed", slog.F("agent_id", agentID), slog.Error(err))
+		return &RunError{Phase: RunPhaseRead, AgentID: agentID, Err: xerrors.Errorf("read prompt: %w", err)}
+	}
+
+	logger.Info(ctx, "dry run: agent reachable", slog.F("agent_id", agentID))
+	return nil
+}
+
+const handshakeTimeout = 30 * time.Second
+
+// runHandshakeOnly repeatedly dials, confirms the handshake, and closes the
+// connection against every agent in Config.AgentIDs, Config.Connections
+// times each, measuring connection setup/teardown rate and handshake
+// latency independently of data throughput. Unlike runDryRun, which only
+// confirms reachability once, this stresses the server's session-creation
+// path the way a steady-state traffic run barely touches it. It returns the
+// first agent's error, if any, wrapped with that agent's ID; durations
+// gathered before the failure are still reported.
+func (r *Runner) runHandshakeOnly(ctx context.Context, logger slog.Logger) error {
+	var (
+		mu        sync.Mutex
+		durations []time.Duration
+		attempts  int
+		failed    int
+		firstErr  error
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(len(r.cfg.AgentIDs))
+	for _, agentID := range r.cfg.AgentIDs {
+		agentID := agentID
+		go func() {
+			defer wg.Done()
+			for i := 0; i < r.cfg.Connections && ctx.Err() == nil; i++ {
+				d, err := r.handshakeOnce(ctx, logger, agentID)
+
+				mu.Lock()
+				attempts++
+				if err != nil {
+					failed++
+					if firstErr == nil {
+						firstErr = xerrors.Errorf("agent %s: %w", agentID, err)
+					}
+				} else {
+					durations = append(durations, d)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	start := time.Now()
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	p50, p95, p99, max := percentiles(durations)
+	var connectionsPerSecond float64
+	if elapsed > 0 {
+		connectionsPerSecond = float64(len(durations)) / elapsed.Seconds()
+	}
+
+	reason := ReasonDeadline
+	if firstErr != nil {
+		reason = ReasonError
+	}
+
+	res := Results{
+		SchemaVersion:                 resultsSchemaVersion,
+		Labels:                        r.cfg.Labels,
+		Reason:                        reason,
+		Duration:                      elapsed,
+		ConnectSamples:                len(durations),
+		ConnectP50:                    p50,
+		ConnectP95:                    p95,
+		ConnectP99:                    p99,
+		ConnectMax:                    max,
+		ConnectAttempts:               attempts,
+		HandshakeConnections:          len(durations),
+		HandshakeConnectionsPerSecond: connectionsPerSecond,
+	}
+	if firstErr != nil {
+		res.Error = firstErr.Error()
+	}
+
+	logger.Info(ctx, "handshake benchmark complete",
+		slog.F("agents", len(r.cfg.AgentIDs)),
+		slog.F("connections", res.HandshakeConnections),
+		slog.F("failed", failed),
+		slog.F("connections_per_second", res.HandshakeConnectionsPerSecond),
+		slog.F("connect_p50", res.ConnectP50),
+		slog.F("connect_p95", res.ConnectP95),
+		slog.F("connect_p99", res.ConnectP99),
+		slog.F("connect_max", res.ConnectMax),
+	)
+
+	if r.cfg.MetricsWriter != nil {
+		if err := writeMetrics(r.cfg.MetricsWriter, res, r.cfg.MetricsCompression); err != nil && firstErr == nil {
+			firstErr = xerrors.Errorf("write metrics: %w", err)
+		}
+	}
+
+	if firstErr == nil && r.cfg.ResultValidator != nil {
+		if err := r.cfg.ResultValidator(res); err != nil {
+			firstErr = xerrors.Errorf("result validator: %w", err)
+		}
+	}
+
+	return firstErr
+}
+
+// handshakeOnce dials agentID, confirms the handshake the same way
+// dryRunAgent does, and closes the connection, returning how long the dial
+// itself took to establish.
+func (r *Runner) handshakeOnce(ctx context.Context, logger slog.Logger, agentID uuid.UUID) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	dialStart := time.Now()
+	rw, closeConn, err := r.dial(ctx, logger, agentID, uuid.New(), r.resolveCommand())
+	dialDuration := time.Since(dialStart)
+	if err != nil {
+		logger.Warn(ctx, "handshake benchmark: agent unreachable", slog.F("agent_id", agentID), slog.Error(err))
+		return 0, &RunError{Phase: RunPhaseConnect, AgentID: agentID, Err: xerrors.Errorf("dial agent: %w", err)}
+	}
+	defer func() { _ = closeConn() }()
+
+	if _, err := rw.Write([]byte("\n")); err != nil {
+		logger.Warn(ctx, "handshake benchmark: handshake write failed", slog.F("agent_id", agentID), slog.Error(err))
+		return 0, &RunError{Phase: RunPhaseWrite, AgentID: agentID, Err: xerrors.Errorf("write handshake newline: %w", err)}
+	}
+
+	buf := make([]byte, DefaultReadBufferSize)
+	if _, err := rw.Read(buf); err != nil {
+		logger.Warn(ctx, "handshake benchmark: handshake read failed", slog.F("agent_id", agentID), slog.Error(err))
+		return 0, &RunError{Phase: RunPhaseRead, AgentID: agentID, Err: xerrors.Errorf("read prompt: %w", err)}
+	}
+
+	return dialDuration, nil
+}
+
+// connectRetryJitter adds up to this fraction of random jitter on top of
+// dialWithRetry's exponential backoff, using the same jitteredInterval
+// helper as Config.TickJitter, so agents starting at once and all failing
+// their first dial don't retry in lockstep and cause a second thundering
+// herd.
+const connectRetryJitter = 0.2
+
+// dialWithRetry calls dial, retrying up to Config.ConnectRetries additional
+// times with an exponential backoff between Config.ConnectRetryBackoffFloor
+// and Config.ConnectRetryBackoffCeil if it fails. It returns the same
+// values as dial, plus the number of attempts made (always at least 1, and
+// greater than 1 only if at least one attempt failed).
+func (r *Runner) dialWithRetry(ctx context.Context, logger slog.Logger, agentID uuid.UUID, reconnectToken uuid.UUID, command string) (io.ReadWriter, func() error, int, error) {
+	backoffFloor := time.Duration(r.cfg.ConnectRetryBackoffFloor)
+	if backoffFloor <= 0 {
+		backoffFloor = DefaultConnectRetryBackoffFloor
+	}
+	backoffCeil := time.Duration(r.cfg.ConnectRetryBackoffCeil)
+	if backoffCeil <= 0 {
+		backoffCeil = DefaultConnectRetryBackoffCeil
+	}
+
+	return connectWithRetry(ctx, backoffFloor, backoffCeil, r.cfg.ConnectRetries,
+		func() (io.ReadWriter, func() error, error) {
+			return r.dial(ctx, logger, agentID, reconnectToken, command)
+		},
+		func(attempt int, err error) {
+			logger.Warn(ctx, "initial connection failed, retrying",
+				slog.F("agent_id", agentID),
+				slog.F("attempt", attempt),
+				slog.Error(err),
+			)
+		},
+	)
+}
+
+// connectWithRetry calls dial, retrying up to retries additional times with
+// an exponential backoff between floor and ceil if it fails, doubling after
+// every attempt and capping at ceil. onRetry, if non-nil, is called before
+// each retry's backoff wait. It returns the same values as dial, plus the
+// number of attempts made (always at least 1, and greater than 1 only if at
+// least one attempt failed).
+func connectWithRetry(ctx context.Context, floor, ceil time.Duration, retries int, dial func() (io.ReadWriter, func() error, error), onRetry func(attempt int, err error)) (io.ReadWriter, func() error, int, error) {
+	delay := floor
+	for attempts := 1; ; attempts++ {
+		rw, closeConn, err := dial()
+		if err == nil {
+			return rw, closeConn, attempts, nil
+		}
+		if attempts > retries {
+			return nil, nil, attempts, err
+		}
+
+		if onRetry != nil {
+			onRetry(attempts, err)
+		}
+
+		select {
+		case <-time.After(jitteredInterval(delay, connectRetryJitter)):
+		case <-ctx.Done():
+			return nil, nil, attempts, err
+		}
+
+		delay *= 2
+		if delay > ceil {
+			delay = ceil
+		}
+	}
+}
+
+// resultsEndpointTimeout bounds a single POST attempt to Config.ResultsEndpoint.
+const resultsEndpointTimeout = 5 * time.Second
+
+// resultsEndpointRetries is the number of additional POST attempts made to
+// Config.ResultsEndpoint after the first one fails.
+const resultsEndpointRetries = 2
+
+// postResults marshals res as JSON and POSTs it to endpoint, retrying
+// resultsEndpointRetries additional times with a short backoff if the
+// request fails or the response isn't a 2xx, each attempt bounded by
+// resultsEndpointTimeout. The caller is expected to only log the returned
+// error, not fail the run over it: a collector being unreachable shouldn't
+// take down the load test itself.
+func postResults(ctx context.Context, endpoint string, res Results) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return xerrors.Errorf("marshal results: %w", err)
+	}
+
+	delay := 100 * time.Millisecond
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		lastErr = postResultsOnce(ctx, endpoint, body)
+		if lastErr == nil {
+			return nil
+		}
+		if attempt > resultsEndpointRetries {
+			return xerrors.Errorf("post results after %d attempts: %w", attempt, lastErr)
+		}
+
+		select {
+		case <-time.After(jitteredInterval(delay, connectRetryJitter)):
+		case <-ctx.Done():
+			return xerrors.Errorf("post results: %w", ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+func postResultsOnce(ctx context.Context, endpoint string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, resultsEndpointTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return xerrors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Dialer abstracts opening a reconnecting PTY session against an agent, so
+// tests can inject an in-memory implementation instead of requiring a live
+// coderd server to exercise byte accounting, deadline handling, and error
+// propagation deterministically. See Config.Dialer.
+type Dialer interface {
+	Dial(ctx context.Context, opts codersdk.WorkspaceAgentReconnectingPTYOpts) (io.ReadWriteCloser, error)
+}
+
+// withDialResolver returns a copy of base (or a fresh transport cloned from
+// http.DefaultTransport if base is nil or not an *http.Transport) whose
+// DialContext uses resolver and family to resolve and connect, logging the
+// address it ends up using. Only DialContext is overridden, so this
+// composes with a transport the caller already configured for mTLS rather
+// than replacing it. See Config.Resolver and Config.NetworkFamily.
+func withDialResolver(base http.RoundTripper, resolver *net.Resolver, family NetworkFamily, logger slog.Logger) http.RoundTripper {
+	transport, ok := base.(*http.Transport)
+	if !ok || transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	} else {
+		transport = transport.Clone()
+	}
+
+	network := "tcp"
+	switch family {
+	case NetworkFamilyIPv4:
+		network = "tcp4"
+	case NetworkFamilyIPv6:
+		network = "tcp6"
+	}
+
+	dialer := &net.Dialer{Resolver: resolver}
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, xerrors.Errorf("dial %s over %s: %w", addr, network, err)
+		}
+		logger.Debug(ctx, "resolved agent connection address",
+			slog.F("addr", addr),
+			slog.F("network", network),
+			slog.F("resolved_addr", conn.RemoteAddr().String()),
+		)
+		return conn, nil
+	}
+	return transport
+}
+
+// clientDialer is the default Dialer, used whenever Config.Dialer is unset.
+type clientDialer struct {
+	client *codersdk.Client
+}
+
+func (d clientDialer) Dial(ctx context.Context, opts codersdk.WorkspaceAgentReconnectingPTYOpts) (io.ReadWriteCloser, error) {
+	return d.client.WorkspaceAgentReconnectingPTY(ctx, opts)
+}
+
+// resolveCommand returns the command a single session should launch: a
+// generated throttled-output command sized to Config.ReadBytesPerSecond if
+// Config.GenerateReadCommand is set (see buildReadRateCommand), otherwise one
+// entry from Config.CommandMix chosen at random and weighted by Weight if
+// CommandMix is non-empty, otherwise Config.Command unchanged. Called once
+// per session so the same command is reused across that session's
+// reconnects rather than re-rolled on every dial.
+func (r *Runner) resolveCommand() string {
+	if r.cfg.GenerateReadCommand {
+		return buildReadRateCommand(r.cfg.ReadBytesPerSecond)
+	}
+	if len(r.cfg.CommandMix) == 0 {
+		return r.cfg.Command
+	}
+	return pickWeightedCommand(r.cfg.CommandMix)
+}
+
+// runSetupCommands writes each of commands to rw, one per line, waiting
+// settleDelay after each before sending the next, so an earlier command's
+// echo and any side effects (a cd, an exported variable, a started
+// program) have time to land before the next one runs. rw's usual read
+// loop is left to drain and discard the echoes; the caller is expected to
+// exclude SetupCommands' bytes from Results (see
+// countReadWriter.beginSetup/endSetup) since runSetupCommands itself only
+// writes. Returns once the last command's settle delay has elapsed, or ctx
+// is canceled.
+func runSetupCommands(ctx context.Context, rw io.Writer, commands []string, settleDelay time.Duration) error {
+	for _, cmd := range commands {
+		if _, err := rw.Write([]byte(cmd + "\n")); err != nil {
+			return xerrors.Errorf("write setup command %q: %w", cmd, err)
+		}
+		if settleDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(settleDelay):
+			}
+		}
+	}
+	return nil
+}
+
+// labelFields converts labels into slog.Fields, one per entry, sorted by key
+// so a run's log output is deterministic across repeated invocations with
+// the same Config.Labels. Used to attach Config.Labels to every log entry
+// Run produces.
+func labelFields(labels map[string]string) []slog.Field {
+	if len(labels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]slog.Field, len(keys))
+	for i, k := range keys {
+		fields[i] = slog.F(k, labels[k])
+	}
+	return fields
+}
+
+// labelAttributes converts labels into span attributes, one per entry under
+// the "coder.trafficgen.label." prefix, sorted by key for the same
+// determinism reason as labelFields. Used to attach Config.Labels to Run's
+// span.
+func labelAttributes(labels map[string]string) []attribute.KeyValue {
+	if len(labels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]attribute.KeyValue, len(keys))
+	for i, k := range keys {
+		attrs[i] = attribute.String("coder.trafficgen.label."+k, labels[k])
+	}
+	return attrs
+}
+
+// ptyEnv returns the environment to apply to a newly spawned reconnecting
+// PTY command, copying Config.Env and filling in DefaultTERM if it didn't
+// set "TERM", so existing callers see no change in behavior.
+func (r *Runner) ptyEnv() map[string]string {
+	env := make(map[string]string, len(r.cfg.Env)+1)
+	for k, v := range r.cfg.Env {
+		env[k] = v
+	}
+	if _, ok := env["TERM"]; !ok {
+		env["TERM"] = DefaultTERM
+	}
+	return env
+}
+
+// buildReadRateCommand returns a shell loop that writes bytesPerSecond zero
+// bytes once a second, forever, regardless of what's written to it. It's
+// sent once at session start so the reader can measure pure download
+// throughput without depending on the target command echoing input back.
+func buildReadRateCommand(bytesPerSecond int64) string {
+	return fmt.Sprintf("while :; do dd if=/dev/zero bs=%d count=1 2>/dev/null; sleep 1; done", bytesPerSecond)
+}
+
+// pickWeightedCommand returns one entry from mix chosen at random with
+// probability proportional to its Weight. mix must be non-empty and every
+// Weight must be greater than 0, both enforced by Config.Validate.
+func pickWeightedCommand(mix []CommandWeight) string {
+	var total float64
+	for _, cw := range mix {
+		total += cw.Weight
+	}
+
+	roll := rand.Float64() * total
+	for _, cw := range mix {
+		roll -= cw.Weight
+		if roll < 0 {
+			return cw.Command
+		}
+	}
+	// Only reached if floating-point rounding left roll >= 0 all the way
+	// through, which can happen when roll lands extremely close to total.
+	return mix[len(mix)-1].Command
+}
+
+// dial opens the configured connection type against the agent and returns
+// an io.ReadWriter to drive the random-data loop against, along with a
+// cleanup func that closes the underlying connection. reconnectToken is
+// reused across reconnects of the same run so that the reconnecting PTY
+// resumes the same session rather than starting a fresh one; for
+// ConnectionTypeSSH, which has no equivalent concept, it's only used as the
+// key under which Config.TransportRTT samples are recorded. command is the
+// resolved value of Config.Command or Config.CommandMix (see
+// Runner.resolveCommand); it's only used for ConnectionTypePTY, the same as
+// Config.Command. The dial itself is bounded by Config.ConnectTimeout,
+// independently of ctx's own deadline.
+func (r *Runner) dial(ctx context.Context, logger slog.Logger, agentID uuid.UUID, reconnectToken uuid.UUID, command string) (io.ReadWriter, func() error, error) {
+	connectTimeout := time.Duration(r.cfg.ConnectTimeout)
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	width := r.cfg.Width
+	if width == 0 {
+		width = DefaultWidth
+	}
+	height := r.cfg.Height
+	if height == 0 {
+		height = DefaultHeight
+	}
+
+	switch r.cfg.ConnectionType {
+	case ConnectionTypeSSH:
+		conn, err := r.client.DialWorkspaceAgent(ctx, agentID, &codersdk.DialWorkspaceAgentOptions{
+			Logger: logger.Named("trafficgen"),
+		})
+		if err != nil {
+			return nil, nil, xerrors.Errorf("dial workspace agent: %w", err)
+		}
+
+		sshClient, err := conn.SSHClient(ctx)
+		if err != nil {
+			_ = conn.Close()
+			return nil, nil, xerrors.Errorf("create ssh client: %w", err)
+		}
+
+		session, err := sshClient.NewSession()
+		if err != nil {
+			_ = sshClient.Close()
+			_ = conn.Close()
+			return nil, nil, xerrors.Errorf("create ssh session: %w", err)
+		}
+
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			_ = session.Close()
+			_ = sshClient.Close()
+			_ = conn.Close()
+			return nil, nil, xerrors.Errorf("get ssh session stdin: %w", err)
+		}
+		stdout, err := session.StdoutPipe()
+		if err != nil {
+			_ = session.Close()
+			_ = sshClient.Close()
+			_ = conn.Close()
+			return nil, nil, xerrors.Errorf("get ssh session stdout: %w", err)
+		}
+
+		err = session.RequestPty("xterm", int(height), int(width), ssh.TerminalModes{})
+		if err != nil {
+			_ = session.Close()
+			_ = sshClient.Close()
+			_ = conn.Close()
+			return nil, nil, xerrors.Errorf("request ssh pty: %w", err)
+		}
+		err = session.Shell()
+		if err != nil {
+			_ = session.Close()
+			_ = sshClient.Close()
+			_ = conn.Close()
+			return nil, nil, xerrors.Errorf("start ssh shell: %w", err)
+		}
+
+		if r.cfg.TransportRTT {
+			if rtt, _, _, err := conn.Ping(ctx); err != nil {
+				logger.Warn(ctx, "transport rtt ping failed", slog.Error(err))
+			} else {
+				r.recordTransportRTT(reconnectToken, rtt)
+			}
+		}
+
+		var rw io.ReadWriter = &readWriter{Reader: stdout, Writer: stdin}
+		if r.cfg.FaultInjector != nil {
+			rw = r.cfg.FaultInjector(rw)
+		}
+
+		return rw, func() error {
+			_ = session.Close()
+			_ = sshClient.Close()
+			return conn.Close()
+		}, nil
+	case ConnectionTypePTY, "":
+		if command == "" {
+			command = "/bin/sh"
+		}
+
+		dialer := r.cfg.Dialer
+		if dialer == nil {
+			dialer = clientDialer{client: r.client}
+		}
+
+		conn, err := dialer.Dial(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+			AgentID:   agentID,
+			Reconnect: reconnectToken,
+			Width:     width,
+			Height:    height,
+			Command:   command,
+			Env:       r.ptyEnv(),
+		})
+		if err != nil {
+			return nil, nil, xerrors.Errorf("open reconnecting PTY: %w", err)
+		}
+
+		var wireConn io.ReadWriter = conn
+		if r.cfg.FaultInjector != nil {
+			wireConn = r.cfg.FaultInjector(wireConn)
+		}
+
+		return newReconnectingPTYWriter(wireConn), conn.Close, nil
+	default:
+		return nil, nil, xerrors.Errorf("unknown connection_type %q", r.cfg.ConnectionType)
+	}
+}
+
+// readWriter combines a separate io.Reader and io.Writer (e.g. the stdout
+// and stdin of an SSH session) into a single io.ReadWriter.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+// reconnectingPTYWriter wraps a reconnecting PTY net.Conn. Writes are framed
+// as a codersdk.ReconnectingPTYRequest, since that's what the reconnecting
+// PTY protocol expects on the wire, while reads are passed through
+// unmodified as raw terminal output.
+type reconnectingPTYWriter struct {
+	conn io.ReadWriter
+
+	// buf and enc are reused across every Write call instead of
+	// json.Marshal-ing a fresh []byte per tick, which otherwise dominates
+	// allocations at high tick rates. Safe without locking since a single
+	// reconnectingPTYWriter is only ever written from the one goroutine
+	// driving its agent's write loop.
+	buf bytes.Buffer
+	enc *json.Encoder
+
+	// wireBytes is the size of the JSON frame sent by the most recent Write
+	// call, which is always somewhat larger than the payload length Write
+	// was given. See wireByteWriter.
+	wireBytes int
+}
+
+func newReconnectingPTYWriter(conn io.ReadWriter) *reconnectingPTYWriter {
+	w := &reconnectingPTYWriter{conn: conn}
+	w.enc = json.NewEncoder(&w.buf)
+	return w
+}
+
+func (w *reconnectingPTYWriter) Read(p []byte) (int, error) {
+	return w.conn.Read(p)
+}
+
+func (w *reconnectingPTYWriter) Write(p []byte) (int, error) {
+	w.buf.Reset()
+	if err := w.enc.Encode(codersdk.ReconnectingPTYRequest{Data: string(p)}); err != nil {
+		return 0, xerrors.Errorf("marshal reconnecting pty request: %w", err)
+	}
+
+	_, err := w.conn.Write(w.buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	w.wireBytes = w.buf.Len()
+	return len(p), nil
+}
+
+// wireBytesWritten returns the size of the JSON frame sent by the most
+// recent successful Write call, for countReadWriter to report alongside the
+// payload length. See wireByteWriter.
+func (w *reconnectingPTYWriter) wireBytesWritten() int {
+	return w.wireBytes
+}
+
+// wireByteWriter is implemented by writers that wrap the payload passed to
+// Write in additional protocol framing before it reaches the wire, letting
+// countReadWriter learn the framed size of the most recent Write call
+// separately from the payload length. Only reconnectingPTYWriter implements
+// it: JSON-encoding every write as a codersdk.ReconnectingPTYRequest
+// inflates its size on the wire. SSH's raw stdin pipe writes payload bytes
+// unmodified, so writers that don't implement this interface are treated as
+// having no overhead: wire bytes equal payload bytes.
+type wireByteWriter interface {
+	wireBytesWritten() int
+}
+
+// keepaliveWriter is implemented by writers that can tag a write as an
+// application-level keepalive ping, timed separately from ordinary data
+// writes. Only countReadWriter implements it; the write loops use it to
+// send Config.KeepaliveInterval's pings without introducing a second
+// goroutine writing to the same connection. See countReadWriter.WriteKeepalive.
+type keepaliveWriter interface {
+	WriteKeepalive(p []byte) (int, error)
+}
+
+// keepalivePayload returns the payload written on Config.KeepaliveInterval's
+// cadence: a single comment line prefixed the same way as genPayload's
+// output, so it's indistinguishable from ordinary traffic to anything
+// downstream, and terminated with '\n' so the PTY's line discipline echoes
+// it back just like any other line.
+func keepalivePayload(payloadPrefix string) []byte {
+	return []byte(payloadPrefix + "keepalive\n")
+}
+
+// connManager wraps the live agent connection, transparently redialing it
+// on transport errors when reconnects are enabled. Reads and writes that
+// fail are retried exactly once against the freshly redialed connection;
+// if reconnecting isn't possible, the original error is returned so the
+// caller can treat the run as failed. If maxErrors is set, every failure is
+// counted regardless of whether reconnecting recovers it, and once the
+// count exceeds maxErrors the error budget is considered exhausted: the
+// failure is returned immediately without even attempting to reconnect, on
+// the theory that a connection failing this often is no longer worth the
+// cost of chasing.
+type connManager struct {
+	ctx  context.Context
+	dial func(ctx context.Context) (io.ReadWriter, func() error, error)
+
+	mu                   sync.Mutex
+	rw                   io.ReadWriter
+	closeConn            func() error
+	enabled              bool
+	max                  int
+	reconnects           int
+	forcedReconnects     int
+	connectDurations     []time.Duration
+	maxErrors            int
+	errors               int
+	errorBudgetExhausted bool
+
+	// connEstablishedAt is when the current rw became usable, and
+	// firstByteSeen tracks whether a read has already been matched against
+	// it. Together they let Read record one time-to-first-byte sample per
+	// connection (the initial one, and then one per reconnect), reset
+	// whenever rw is swapped. See ttfbSamples.
+	connEstablishedAt time.Time
+	firstByteSeen     bool
+	ttfbSamples       []time.Duration
+
+	// wireBytes is the wire size of the most recent successful Write call,
+	// as reported by wireByteWriter if the current rw implements it, or the
+	// payload length otherwise. See lastWireBytes.
+	wireBytes int
+}
+
+func newConnManager(ctx context.Context, rw io.ReadWriter, closeConn func() error, enabled bool, max int, maxErrors int, connEstablishedAt time.Time, dial func(ctx context.Context) (io.ReadWriter, func() error, error)) *connManager {
+	return &connManager{ctx: ctx, rw: rw, closeConn: closeConn, enabled: enabled, max: max, maxErrors: maxErrors, connEstablishedAt: connEstablishedAt, dial: dial}
+}
+
+func (m *connManager) Write(p []byte) (int, error) {
+	n, err := m.do(func(rw io.ReadWriter) (int, error) { return rw.Write(p) })
+	if err == nil {
+		wire := n
+		m.mu.Lock()
+		if wbw, ok := m.rw.(wireByteWriter); ok {
+			wire = wbw.wireBytesWritten()
+		}
+		m.wireBytes = wire
+		m.mu.Unlock()
+	}
+	return n, err
+}
+
+// wireBytesWritten returns the wire size of the most recent successful
+// Write call, satisfying wireByteWriter so countReadWriter can treat a
+// connManager the same as the writer it wraps. It's meaningless to call
+// before the first successful Write.
+func (m *connManager) wireBytesWritten() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.wireBytes
+}
+
+func (m *connManager) Read(p []byte) (int, error) {
+	n, err := m.do(func(rw io.ReadWriter) (int, error) { return rw.Read(p) })
+	if n > 0 {
+		m.mu.Lock()
+		if !m.firstByteSeen {
+			m.firstByteSeen = true
+			m.ttfbSamples = append(m.ttfbSamples, time.Since(m.connEstablishedAt))
+		}
+		m.mu.Unlock()
+	}
+	return n, err
+}
+
+func (m *connManager) do(fn func(io.ReadWriter) (int, error)) (int, error) {
+	m.mu.Lock()
+	rw := m.rw
+	m.mu.Unlock()
+
+	n, err := fn(rw)
+	if err == nil || m.ctx.Err() != nil {
+		return n, err
+	}
+
+	m.mu.Lock()
+	m.errors++
+	exhausted := m.maxErrors > 0 && m.errors > m.maxErrors
+	if exhausted {
+		m.errorBudgetExhausted = true
+	}
+	m.mu.Unlock()
+	if exhausted {
+		return n, xerrors.Errorf("exceeded max_errors (%d): %w", m.maxErrors, err)
+	}
+
+	if rerr := m.reconnect(rw); rerr != nil {
+		return n, rerr
+	}
+
+	m.mu.Lock()
+	rw = m.rw
+	m.mu.Unlock()
+	return fn(rw)
+}
+
+// reconnect redials the connection if it's still the one the caller
+// observed failing (stale). If another goroutine already reconnected past
+// it, reconnect is a no-op success so the caller's retry picks up the new
+// connection.
+func (m *connManager) reconnect(stale io.ReadWriter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rw != stale {
+		return nil
+	}
+	if !m.enabled {
+		return xerrors.New("connection lost and reconnect is disabled")
+	}
+	if m.max > 0 && m.reconnects >= m.max {
+		return xerrors.Errorf("exceeded max_reconnects (%d)", m.max)
+	}
+
+	_ = m.closeConn()
+	dialStart := time.Now()
+	rw, closeConn, err := m.dial(m.ctx)
+	if err != nil {
+		return xerrors.Errorf("reconnect: %w", err)
+	}
+	m.rw = rw
+	m.closeConn = closeConn
+	m.reconnects++
+	m.connectDurations = append(m.connectDurations, time.Since(dialStart))
+	m.connEstablishedAt = time.Now()
+	m.firstByteSeen = false
+	return nil
+}
+
+// forceReconnect closes and redials the connection unconditionally, even
+// though it may still be healthy, to soak-test the reconnect path itself
+// under Config.ReconnectInterval. Unlike reconnect, it ignores m.enabled and
+// m.max, since it isn't responding to an error, and counts separately in
+// m.forcedReconnects.
+func (m *connManager) forceReconnect(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_ = m.closeConn()
+	dialStart := time.Now()
+	rw, closeConn, err := m.dial(ctx)
+	if err != nil {
+		return xerrors.Errorf("forced reconnect: %w", err)
+	}
+	m.rw = rw
+	m.closeConn = closeConn
+	m.forcedReconnects++
+	m.connectDurations = append(m.connectDurations, time.Since(dialStart))
+	m.connEstablishedAt = time.Now()
+	m.firstByteSeen = false
+	return nil
+}
+
+func (m *connManager) forcedReconnectCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.forcedReconnects
+}
+
+func (m *connManager) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closeConn()
+}
+
+func (m *connManager) reconnectCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reconnects
+}
+
+// errorCount returns the total number of read/write failures observed so
+// far, whether or not each was successfully recovered by a reconnect.
+func (m *connManager) errorCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errors
+}
+
+// budgetExhausted reports whether errorCount ever exceeded Config.MaxErrors,
+// i.e. whether the most recent do() failure (if any) was because the error
+// budget ran out rather than an unrecoverable dial failure.
+func (m *connManager) budgetExhausted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errorBudgetExhausted
+}
+
+// reconnectDurations returns the dial duration of every successful
+// reconnect so far, in the order they occurred.
+func (m *connManager) reconnectDurations() []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]time.Duration, len(m.connectDurations))
+	copy(out, m.connectDurations)
+	return out
+}
+
+// ttfbDurations returns the time-to-first-byte observed on every
+// connection so far - the initial one, plus one more per successful
+// reconnect - in the order they occurred. A connection that never reads a
+// byte before being replaced contributes no sample.
+func (m *connManager) ttfbDurations() []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]time.Duration, len(m.ttfbSamples))
+	copy(out, m.ttfbSamples)
+	return out
+}
+
+// tickedWriteLoop writes bytesPerTick bytes to rw once per tick, where
+// bytesPerTick is derived from cfg.BytesPerSecond and cfg.TicksPerSecond and
+// shaped by cfg.Pattern (constant with optional RampUp, Burst, Sine,
+// Adaptive, or ThinkTime). Each write is bounded by a deadline of one tick
+// interval (see
+// writeWithDeadline), so a write that stalls - e.g. against an agent that's
+// stopped reading - is abandoned rather than blocking the loop forever. It
+// returns the peak bytesPerTick reached by the Adaptive pattern's feedback
+// loop (zero for every other pattern), overrunTicks (the number of ticks
+// whose write took longer than tickInterval to complete or return, meaning
+// the agent fell behind the target cadence rather than merely being
+// slow-but-keeping-up), totalTicks (the number of ticks actually fired, for
+// computing the overrun fraction), and throttledTicks (the number of ticks
+// whose write was delayed because cfg.MaxInFlight was already reached; zero
+// unless MaxInFlight is set), and nil once ctx is done, a write fails with a
+// context error, or budget (if non-nil) is exhausted, and a wrapped error for
+// any other write failure. If cfg.GlobalLimiter is set, each tick reserves
+// bytesPerTick from it before writing, so this function's cadence is
+// additionally capped by whatever other Runners are sharing that limiter. If
+// cfg.MaxInFlight is set, each tick also waits for rw's in-flight (written
+// but not yet echoed) bytes to drop below it before writing, modeling a
+// client with bounded flow control; see waitForInFlightCapacity. If
+// cfg.SizeDistribution is set, the size Pattern computed for that tick is
+// then resampled around itself per cfg.SizeSpread, so the long-run average
+// still matches what Pattern would have written unchanged. If
+// cfg.KeepaliveInterval is positive and rw implements keepaliveWriter, a
+// keepalive ping is written on that cadence (using payloadPrefix the same
+// way genPayload does), independently of Pattern's regular data writes.
+func tickedWriteLoop(ctx context.Context, rw io.Writer, cfg Config, start time.Time, genPayload func(dst []byte, n int64) ([]byte, error), budget *byteBudget, payloadPrefix string) (adaptivePeakBytesPerTick, overrunTicks, totalTicks, throttledTicks int64, err error) {
+	targetBytesPerTick := cfg.BytesPerSecond / cfg.TicksPerSecond
+	remainderBytes := cfg.BytesPerSecond % cfg.TicksPerSecond
+	tickInterval := time.Second / time.Duration(cfg.TicksPerSecond)
+
+	burstSize := cfg.Burst.Size
+	if burstSize == 0 {
+		burstSize = targetBytesPerTick * (cfg.Burst.IdleTicks + 1)
+	}
+
+	var adaptive *adaptiveController
+	var latencySource adaptiveLatencyObserver
+	if cfg.Pattern == TrafficPatternAdaptive {
+		adaptive = newAdaptiveController(targetBytesPerTick, cfg.AdaptiveStepBytes, cfg.AdaptiveBackoffFactor, time.Duration(cfg.AdaptiveLatencyThreshold))
+		latencySource, _ = rw.(adaptiveLatencyObserver)
+	}
+
+	var thinkTime *thinkTimeController
+	if cfg.Pattern == TrafficPatternThinkTime {
+		thinkTime = newThinkTimeController(cfg.ThinkTime)
+	}
+
+	timer := time.NewTimer(jitteredInterval(tickInterval, cfg.TickJitter))
+	defer timer.Stop()
+
+	kw, _ := rw.(keepaliveWriter)
+	keepaliveInterval := time.Duration(cfg.KeepaliveInterval)
+	lastKeepalive := time.Now()
+
+	var tickIndex int64
+	for {
+		select {
+		case <-ctx.Done():
+			return adaptivePeak(adaptive), overrunTicks, totalTicks, throttledTicks, nil
+		case <-timer.C:
+			if keepaliveInterval > 0 && kw != nil && time.Since(lastKeepalive) >= keepaliveInterval {
+				lastKeepalive = time.Now()
+				if _, err := kw.WriteKeepalive(keepalivePayload(payloadPrefix)); err != nil {
+					return adaptivePeak(adaptive), overrunTicks, totalTicks, throttledTicks, xerrors.Errorf("write keepalive: %w", err)
+				}
+			}
+			var bytesPerTick int64
+			switch cfg.Pattern {
+			case TrafficPatternBurst:
+				bytesPerTick = burstBytesPerTick(burstSize, cfg.Burst.IdleTicks, tickIndex)
+			case TrafficPatternSine:
+				bytesPerTick = sineBytesPerTick(targetBytesPerTick, cfg.Sine.Amplitude, time.Duration(cfg.Sine.Period), time.Since(start))
+			case TrafficPatternAdaptive:
+				var latency time.Duration
+				var ok bool
+				if latencySource != nil {
+					latency, ok = latencySource.latestLatency()
+				}
+				bytesPerTick = adaptive.next(latency, ok)
+			case TrafficPatternThinkTime:
+				bytesPerTick = thinkTime.next(tickInterval, targetBytesPerTick)
+			default:
+				bytesPerTick = rampedBytesPerTick(targetBytesPerTick, time.Duration(cfg.RampUp), time.Duration(cfg.Duration), time.Since(start))
+				if cfg.DistributeRemainder && cfg.RampUp == 0 && remainderBytes > 0 {
+					bytesPerTick += remainderBytesPerTick(remainderBytes, cfg.TicksPerSecond, tickIndex)
+				}
+			}
+			bytesPerTick = sampleTickSize(bytesPerTick, cfg.SizeDistribution, cfg.SizeSpread)
+			tickIndex++
+			timer.Reset(jitteredInterval(tickInterval, cfg.TickJitter))
+			if err := waitForGlobalLimiter(ctx, cfg.GlobalLimiter, bytesPerTick); err != nil {
+				return adaptivePeak(adaptive), overrunTicks, totalTicks, throttledTicks, nil
+			}
+			throttled, err := waitForInFlightCapacity(ctx, rw, int64(cfg.MaxInFlight))
+			if throttled {
+				throttledTicks++
+			}
+			if err != nil {
+				return adaptivePeak(adaptive), overrunTicks, totalTicks, throttledTicks, nil
+			}
+			writeStart := time.Now()
+			writeErr := writeRandomData(ctx, rw, bytesPerTick, genPayload, budget, time.Duration(cfg.KeystrokeDelay), tickInterval, cfg.LineLength)
+			totalTicks++
+			if time.Since(writeStart) > tickInterval {
+				overrunTicks++
+			}
+			switch {
+			case writeErr == nil:
+			case xerrors.Is(writeErr, errWriteDeadlineExceeded):
+				// Already counted above: the write took the whole tick
+				// interval to even be given up on. Unlike the sentinels
+				// below, this isn't a reason to stop the run - the agent
+				// may still be making progress on later ticks - so fall
+				// through and let the loop move on to the next one.
+			case xerrors.Is(writeErr, context.Canceled) || xerrors.Is(writeErr, context.DeadlineExceeded) || xerrors.Is(writeErr, errMaxBytesReached):
+				return adaptivePeak(adaptive), overrunTicks, totalTicks, throttledTicks, nil
+			default:
+				return adaptivePeak(adaptive), overrunTicks, totalTicks, throttledTicks, xerrors.Errorf("write random data: %w", writeErr)
+			}
+			if cfg.TotalTicks > 0 && totalTicks >= int64(cfg.TotalTicks) {
+				return adaptivePeak(adaptive), overrunTicks, totalTicks, throttledTicks, nil
+			}
+		}
+	}
+}
+
+// inFlightObserver is implemented by countReadWriter, letting
+// waitForInFlightCapacity observe how many written bytes are still waiting
+// to be echoed back without depending on the concrete type.
+type inFlightObserver interface {
+	pendingBytes() int64
+}
+
+// inFlightPollInterval is how often waitForInFlightCapacity re-checks rw's
+// in-flight byte count while blocked on Config.MaxInFlight. There's no
+// signal to wake on the moment a read lands, so this is a plain poll; a
+// millisecond is short enough not to meaningfully distort the write cadence
+// it's throttling.
+const inFlightPollInterval = time.Millisecond
+
+// waitForInFlightCapacity blocks until rw has fewer than maxInFlight bytes
+// outstanding (written but not yet matched by an echoed read), or ctx is
+// done. throttled is true if it had to block at all, letting the caller
+// count how often the cap actually limited the write rate. maxInFlight <= 0
+// disables the cap, returning immediately; so does rw not implementing
+// inFlightObserver, since there's nothing to observe.
+func waitForInFlightCapacity(ctx context.Context, rw io.Writer, maxInFlight int64) (throttled bool, err error) {
+	if maxInFlight <= 0 {
+		return false, nil
+	}
+	observer, ok := rw.(inFlightObserver)
+	if !ok || observer.pendingBytes() < maxInFlight {
+		return false, nil
+	}
+	ticker := time.NewTicker(inFlightPollInterval)
+	defer ticker.Stop()
+	for observer.pendingBytes() >= maxInFlight {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return true, nil
+}
+
+// adaptivePeak returns adaptive's discovered peak bytesPerTick, or zero if
+// adaptive is nil (i.e. cfg.Pattern isn't TrafficPatternAdaptive).
+func adaptivePeak(adaptive *adaptiveController) int64 {
+	if adaptive == nil {
+		return 0
+	}
+	return adaptive.peak
+}
+
+// adaptiveLatencyObserver is implemented by countReadWriter, and lets
+// tickedWriteLoop read the most recent round-trip latency sample without
+// depending on the concrete type.
+type adaptiveLatencyObserver interface {
+	latestLatency() (time.Duration, bool)
+}
+
+// adaptiveController implements the AIMD (additive-increase,
+// multiplicative-decrease) feedback loop backing TrafficPatternAdaptive: it
+// grows bytesPerTick by stepBytes every tick the latest latency sample is at
+// or below threshold, and multiplies it by backoffFactor as soon as it
+// isn't, converging on (and then holding just below) the highest rate the
+// agent can sustain without its latency degrading. peak tracks the largest
+// bytesPerTick reached before any backoff.
+type adaptiveController struct {
+	bytesPerTick  int64
+	peak          int64
+	stepBytes     int64
+	backoffFactor float64
+	threshold     time.Duration
+}
+
+func newAdaptiveController(startBytesPerTick, stepBytes int64, backoffFactor float64, threshold time.Duration) *adaptiveController {
+	if stepBytes == 0 {
+		stepBytes = DefaultAdaptiveStepBytes
+	}
+	if backoffFactor == 0 {
+		backoffFactor = DefaultAdaptiveBackoffFactor
+	}
+	return &adaptiveController{
+		bytesPerTick:  startBytesPerTick,
+		peak:          startBytesPerTick,
+		stepBytes:     stepBytes,
+		backoffFactor: backoffFactor,
+		threshold:     threshold,
+	}
+}
+
+// next returns the bytesPerTick to write this tick, given the latest
+// latency sample (and whether one was available yet; with none, the
+// controller holds steady rather than guessing). It updates the
+// controller's internal state and peak for the following tick.
+func (a *adaptiveController) next(latency time.Duration, ok bool) int64 {
+	if !ok {
+		return a.bytesPerTick
+	}
+	if latency > a.threshold {
+		a.bytesPerTick = int64(float64(a.bytesPerTick) * a.backoffFactor)
+	} else {
+		a.bytesPerTick += a.stepBytes
+		if a.bytesPerTick > a.peak {
+			a.peak = a.bytesPerTick
+		}
+	}
+	return a.bytesPerTick
+}
+
+// jitteredInterval returns interval shifted by a random offset drawn
+// uniformly from [-jitter*interval, +jitter*interval]. The offset is
+// centered on zero, so ticks drift in phase without changing the long-run
+// average interval, and therefore without changing the average achieved
+// rate. jitter <= 0 returns interval unchanged.
+func jitteredInterval(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	offset := (rand.Float64()*2 - 1) * jitter * float64(interval)
+	return interval + time.Duration(offset)
+}
+
+// sampleTickSize varies mean by distribution and spread, the same way
+// jitteredInterval varies tickInterval: the result is centered on mean, so
+// the long-run average still converges to it regardless of how any
+// individual tick's size came out. Negative samples are clamped to zero
+// rather than allowed through, since a spread wide enough to swing that far
+// below the mean is the caller's mistake, not a reason to write a negative
+// size. distribution == SizeDistributionNone, or mean <= 0, returns mean
+// unchanged.
+func sampleTickSize(mean int64, distribution SizeDistribution, spread float64) int64 {
+	if distribution == SizeDistributionNone || mean <= 0 {
+		return mean
+	}
+	var sampled float64
+	switch distribution {
+	case SizeDistributionUniform:
+		sampled = float64(mean) + (rand.Float64()*2-1)*spread*float64(mean)
+	case SizeDistributionNormal:
+		sampled = float64(mean) + rand.NormFloat64()*spread*float64(mean)
+	default:
+		return mean
+	}
+	if sampled < 0 {
+		return 0
+	}
+	return int64(sampled)
+}
+
+// thinkTimeController backs TrafficPatternThinkTime: instead of sending on
+// every tick, it stays idle for a randomly sampled gap (see sampleGap), then
+// emits a single burst sized to cover every tick that elapsed during that
+// gap at targetBytesPerTick, so the long-run average rate still approximates
+// BytesPerSecond regardless of how long any individual gap turned out to be.
+type thinkTimeController struct {
+	cfg     ThinkTimeConfig
+	elapsed time.Duration
+	gap     time.Duration
+}
+
+func newThinkTimeController(cfg ThinkTimeConfig) *thinkTimeController {
+	c := &thinkTimeController{cfg: cfg}
+	c.gap = c.sampleGap()
+	return c
+}
+
+// sampleGap draws the next idle gap according to cfg.Distribution.
+func (c *thinkTimeController) sampleGap() time.Duration {
+	switch c.cfg.Distribution {
+	case ThinkTimeUniform:
+		min := time.Duration(c.cfg.Min)
+		max := time.Duration(c.cfg.Max)
+		return min + time.Duration(rand.Float64()*float64(max-min))
+	case ThinkTimeExponential:
+		return time.Duration(rand.ExpFloat64() * float64(time.Duration(c.cfg.Mean)))
+	default: // ThinkTimeConstant, ""
+		return time.Duration(c.cfg.Mean)
+	}
+}
+
+// next returns 0 while the current gap hasn't elapsed yet, or once it has, a
+// burst covering every tick spent waiting, and then samples the next gap.
+func (c *thinkTimeController) next(tickInterval time.Duration, targetBytesPerTick int64) int64 {
+	c.elapsed += tickInterval
+	if c.elapsed < c.gap {
+		return 0
+	}
+	burst := int64(c.elapsed/tickInterval) * targetBytesPerTick
+	c.elapsed = 0
+	c.gap = c.sampleGap()
+	return burst
+}
+
+// rateLimitedChunkSize is the number of bytes written per rate.Limiter
+// reservation when Config.UseRateLimiter is set. Smaller than a typical
+// per-tick payload, so writes are paced continuously instead of landing in
+// one burst per tick.
+const rateLimitedChunkSize = 512
+
+// rateLimitedWriteLoop writes to rw continuously, pacing writes with a
+// token-bucket rate.Limiter fed at bytesPerSecond so the long-run rate
+// matches the target without ever bursting more than rateLimitedChunkSize
+// bytes into the socket at once. It returns nil once ctx is done or budget
+// (if non-nil) is exhausted, and a wrapped error for any other failure. If
+// globalLimiter is set, each chunk additionally reserves rateLimitedChunkSize
+// from it before writing, capping this loop's rate alongside whatever other
+// Runners are sharing that limiter. If keepaliveInterval is positive and rw
+// implements keepaliveWriter, a keepalive ping is written on that cadence,
+// using payloadPrefix the same way genPayload does.
+func rateLimitedWriteLoop(ctx context.Context, rw io.Writer, bytesPerSecond int64, genPayload func(dst []byte, n int64) ([]byte, error), budget *byteBudget, keystrokeDelay time.Duration, globalLimiter *rate.Limiter, lineLength int64, keepaliveInterval time.Duration, payloadPrefix string) error {
+	limiter := rate.NewLimiter(rate.Limit(bytesPerSecond), rateLimitedChunkSize)
+
+	kw, _ := rw.(keepaliveWriter)
+	lastKeepalive := time.Now()
+
+	for {
+		if keepaliveInterval > 0 && kw != nil && time.Since(lastKeepalive) >= keepaliveInterval {
+			lastKeepalive = time.Now()
+			if _, err := kw.WriteKeepalive(keepalivePayload(payloadPrefix)); err != nil {
+				return xerrors.Errorf("write keepalive: %w", err)
+			}
+		}
+
+		// limiter.WaitN refuses to wait at all if the reservation wouldn't
+		// clear before ctx's deadline, even when that deadline is still
+		// comfortably in the future, so reserve the tokens ourselves and
+		// race the resulting delay against ctx instead.
+		reservation := limiter.ReserveN(time.Now(), rateLimitedChunkSize)
+		select {
+		case <-ctx.Done():
+			reservation.Cancel()
+			return nil
+		case <-time.After(reservation.Delay()):
+		}
+
+		if err := waitForGlobalLimiter(ctx, globalLimiter, rateLimitedChunkSize); err != nil {
+			return nil
+		}
+
+		if err := writeRandomData(ctx, rw, rateLimitedChunkSize, genPayload, budget, keystrokeDelay, 0, lineLength); err != nil {
+			if xerrors.Is(err, context.Canceled) || xerrors.Is(err, context.DeadlineExceeded) || xerrors.Is(err, errMaxBytesReached) {
+				return nil
+			}
+			return xerrors.Errorf("write random data: %w", err)
+		}
+	}
+}
+
+// waitForGlobalLimiter reserves n bytes from limiter and blocks until
+// they're available or ctx is done, in which case the reservation is
+// canceled so it doesn't linger and starve other writers sharing limiter.
+// limiter may be nil, in which case it returns immediately; this lets
+// callers pass Config.GlobalLimiter through unconditionally. Mirrors the
+// reserve-and-race-ctx workaround in rateLimitedWriteLoop, since WaitN
+// refuses to wait when ctx has a deadline at all, even one comfortably in
+// the future.
+//
+// n is reserved in chunks no larger than limiter.Burst(), since ReserveN
+// rejects (and Delay reports as never satisfiable) any single reservation
+// bigger than the bucket's burst capacity - a single Runner's bytesPerTick
+// can easily be larger than whatever burst a shared GlobalLimiter was
+// configured with. Chunking preserves the limiter's configured rate exactly
+// instead of under-reserving (and so under-counting) whatever doesn't fit
+// in one burst.
+func waitForGlobalLimiter(ctx context.Context, limiter *rate.Limiter, n int64) error {
+	if limiter == nil {
+		return nil
+	}
+	burst := int64(limiter.Burst())
+	if burst <= 0 {
+		burst = 1
+	}
+	for n > 0 {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+		reservation := limiter.ReserveN(time.Now(), int(chunk))
+		select {
+		case <-ctx.Done():
+			reservation.Cancel()
+			return ctx.Err()
+		case <-time.After(reservation.Delay()):
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// rampedBytesPerTick linearly scales target from zero up to its full value
+// over rampUp, returning target unchanged once rampUp has elapsed. If
+// rampUp is greater than or equal to duration, the ramp is stretched across
+// the entire run instead, since it would otherwise never complete.
+func rampedBytesPerTick(target int64, rampUp, duration, elapsed time.Duration) int64 {
+	if rampUp <= 0 {
+		return target
+	}
+	if rampUp >= duration {
+		rampUp = duration
+	}
+	if elapsed >= rampUp {
+		return target
+	}
+
+	return int64(float64(target) * float64(elapsed) / float64(rampUp))
+}
+
+// remainderBytesPerTick returns 1 on remainder out of every ticksPerSecond
+// ticks (round-robin by tickIndex) and 0 otherwise, so that calling it once
+// per tick across a full second of ticks adds up to exactly remainder extra
+// bytes instead of truncating them away.
+func remainderBytesPerTick(remainder, ticksPerSecond, tickIndex int64) int64 {
+	if tickIndex%ticksPerSecond < remainder {
+		return 1
+	}
+	return 0
+}
+
+// burstBytesPerTick returns size on every (idleTicks+1)th tick, starting at
+// tickIndex 0, and zero otherwise, producing a burst-then-idle pattern.
+func burstBytesPerTick(size, idleTicks, tickIndex int64) int64 {
+	if idleTicks < 0 {
+		idleTicks = 0
+	}
+	period := idleTicks + 1
+	if tickIndex%period == 0 {
+		return size
+	}
+	return 0
+}
+
+// sineBytesPerTick returns target modulated by a sine wave of the given
+// period and amplitude, swinging between (1-amplitude)*target and
+// (1+amplitude)*target. elapsed 0 starts at the mean and rises first,
+// simulating diurnal usage that peaks partway through each period.
+func sineBytesPerTick(target int64, amplitude float64, period, elapsed time.Duration) int64 {
+	phase := 2 * math.Pi * float64(elapsed) / float64(period)
+	return int64(float64(target) * (1 + amplitude*math.Sin(phase)))
+}
+
+// writeRandomData writes n bytes of random data, prefixed with
+// payloadPrefix, to rw. The trailing newline is included in n. genPayload
+// supplies the random bytes and is swapped out when a deterministic seed is
+// configured. If budget is non-nil, n is clamped to whatever remains of it,
+// and errMaxBytesReached is returned once the budget has nothing left. If
+// lineLength is positive and less than n, n is split into multiple
+// newline-terminated lines of at most lineLength bytes each - genPayload is
+// called once per line rather than once for the whole of n - so the agent
+// sees several short lines instead of one giant blob, the way a real
+// terminal session would. If keystrokeDelay is positive, each line is
+// written one byte at a time with a sleep between each byte instead of in a
+// single Write call, simulating the gaps between a human typist's
+// keystrokes; ctx.Err() is returned if ctx is done before every byte has
+// been written. Otherwise, if writeDeadline is positive, each line's single
+// Write call is aborted with errWriteDeadlineExceeded if it hasn't
+// completed within that long; see writeWithDeadline.
+func writeRandomData(ctx context.Context, rw io.Writer, n int64, genPayload func(dst []byte, n int64) ([]byte, error), budget *byteBudget, keystrokeDelay, writeDeadline time.Duration, lineLength int64) error {
+	if budget != nil {
+		n = budget.take(n)
+		if n <= 0 {
+			return errMaxBytesReached
+		}
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	// buf is drawn from payloadBufPool instead of letting genPayload
+	// allocate a fresh slice every call, so tick- and reconnect-heavy runs
+	// don't thrash the allocator. It's grown in place (and the grown
+	// version returned to the pool) if a payload doesn't fit.
+	buf := payloadBufPool.get(0)
+	defer func() { payloadBufPool.put(buf) }()
+
+	if lineLength <= 0 || lineLength >= n {
+		payload, err := genPayload(buf, n)
+		if err != nil {
+			return err
+		}
+		buf = payload
+		return writePayload(ctx, rw, payload, keystrokeDelay, writeDeadline)
+	}
+
+	for n > 0 {
+		lineN := lineLength
+		if lineN > n {
+			lineN = n
+		}
+		payload, err := genPayload(buf, lineN)
+		if err != nil {
+			return err
+		}
+		buf = payload
+		if err := writePayload(ctx, rw, payload, keystrokeDelay, writeDeadline); err != nil {
+			return err
+		}
+		n -= lineN
+	}
+	return nil
+}
+
+// writePayload writes payload to rw, either in a single Write call bounded
+// by writeDeadline, or - if keystrokeDelay is positive - one byte at a time
+// with a sleep between each byte. See writeRandomData.
+func writePayload(ctx context.Context, rw io.Writer, payload []byte, keystrokeDelay, writeDeadline time.Duration) error {
+	if keystrokeDelay <= 0 {
+		_, err := writeWithDeadline(rw, payload, writeDeadline)
+		return err
+	}
+
+	timer := time.NewTimer(keystrokeDelay)
+	defer timer.Stop()
+	for i := range payload {
+		if _, err := rw.Write(payload[i : i+1]); err != nil {
+			return err
+		}
+		if i == len(payload)-1 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			timer.Reset(keystrokeDelay)
+		}
+	}
+	return nil
+}
+
+// errMaxBytesReached is returned by writeRandomData once a non-nil budget
+// has been fully spent, so the write loops can treat it as a clean stop
+// instead of a failure.
+var errMaxBytesReached = xerrors.New("max_bytes reached")
+
+// errWriteDeadlineExceeded is returned by writeWithDeadline when rw.Write
+// hasn't completed within deadline, letting tickedWriteLoop tell an actually
+// stuck write apart from one that's merely slow but still moving.
+var errWriteDeadlineExceeded = xerrors.New("write exceeded deadline")
+
+// writeWithDeadline writes p to rw, returning errWriteDeadlineExceeded if
+// the call hasn't returned within deadline rather than waiting on it
+// indefinitely. A deadline <= 0 disables this and simply calls rw.Write
+// directly. io.Writer has no way to cancel an in-progress call, so a write
+// that times out keeps running on its own goroutine in the background;
+// its result, once it arrives, is discarded. This trades a (bounded, single
+// extra in-flight write per stall) resource leak for the loop that owns rw
+// never blocking longer than deadline on a connection that's stopped
+// making progress.
+func writeWithDeadline(rw io.Writer, p []byte, deadline time.Duration) (int, error) {
+	if deadline <= 0 {
+		return rw.Write(p)
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := rw.Write(p)
+		done <- result{n, err}
+	}()
+
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-timer.C:
+		return 0, errWriteDeadlineExceeded
+	}
+}
+
+// errReadDeadlineExceeded is returned by readWithDeadline when rw.Read
+// hasn't completed within deadline.
+var errReadDeadlineExceeded = xerrors.New("read exceeded deadline")
+
+// readWithDeadline reads into p from rw, returning errReadDeadlineExceeded
+// if the call hasn't returned within deadline rather than waiting on it
+// indefinitely. A deadline <= 0 disables this and simply calls rw.Read
+// directly. Like writeWithDeadline, a read that times out keeps running on
+// its own goroutine in the background; its result, once it arrives, is
+// discarded.
+func readWithDeadline(rw io.Reader, p []byte, deadline time.Duration) (int, error) {
+	if deadline <= 0 {
+		return rw.Read(p)
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := rw.Read(p)
+		done <- result{n, err}
+	}()
+
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-timer.C:
+		return 0, errReadDeadlineExceeded
+	}
+}
+
+// gracefulShutdown writes sequence (or DefaultExitSequence if empty) to rw,
+// then waits up to grace (or DefaultGracefulShutdownGrace if grace <= 0) for
+// rw to acknowledge termination, giving a shell on the other end a chance to
+// terminate on its own before the caller closes the connection out from
+// under it. It reports whether that acknowledgment - a Read returning
+// io.EOF - arrived within grace, so the caller can tell a clean exit from
+// one it had to force by closing the connection anyway. A write that
+// doesn't complete within grace is abandoned and reported as forced
+// immediately, since there's nothing left to wait on; errors other than
+// io.EOF from the read are likewise treated as forced, since the
+// connection is about to be closed either way.
+func gracefulShutdown(rw io.ReadWriter, sequence string, grace time.Duration) (exitedCleanly bool) {
+	if grace <= 0 {
+		grace = DefaultGracefulShutdownGrace
+	}
+	if sequence == "" {
+		sequence = DefaultExitSequence
+	}
+	if _, err := writeWithDeadline(rw, []byte(sequence), grace); err != nil {
+		return false
+	}
+	buf := make([]byte, DefaultReadBufferSize)
+	_, err := readWithDeadline(rw, buf, grace)
+	return xerrors.Is(err, io.EOF)
+}
+
+// byteBudget caps the total bytes writeRandomData will hand out across
+// repeated calls, so a run can stop once Config.MaxBytes is written instead
+// of only when Duration elapses. A byteBudget is only ever touched by the
+// single write-loop goroutine that owns it, so it needs no locking.
+type byteBudget struct {
+	remaining int64
+	doneAt    time.Time
+}
+
+// newByteBudget returns a byteBudget capping writes at max bytes, or nil if
+// max is zero or negative, meaning unlimited.
+func newByteBudget(max int64) *byteBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &byteBudget{remaining: max}
+}
+
+// take reserves up to n bytes from the budget, returning how many are
+// actually available, which may be less than n or zero once the budget is
+// exhausted. The call that exhausts the budget records doneAt, so the
+// caller can report how long it took to reach the cap.
+func (b *byteBudget) take(n int64) int64 {
+	if b == nil || n <= 0 {
+		return n
+	}
+	if b.remaining <= 0 {
+		return 0
+	}
+	if n > b.remaining {
+		n = b.remaining
+	}
+	b.remaining -= n
+	if b.remaining <= 0 && b.doneAt.IsZero() {
+		b.doneAt = time.Now()
+	}
+	return n
+}
+
+// defaultPayloadBufCap is payloadBufPool's starting scratch buffer capacity,
+// sized generously for a typical tick's worth of payload so most runs never
+// need to grow it.
+const defaultPayloadBufCap = 512
+
+// payloadBufPool pools the scratch buffers genPayload's implementations
+// build each payload into, so tick- and reconnect-heavy runs don't allocate
+// a fresh buffer for every single payload. See bytesPool.
+var payloadBufPool = newBytesPool(defaultPayloadBufCap)
+
+// newPayloadGenerator returns a func that generates payloads of exactly n
+// bytes, prefixed with payloadPrefix and terminated with a newline so the
+// target command treats it as a no-op comment instead of executing it. dst
+// is reused as scratch space rather than being allocated fresh each call -
+// callers typically get it from payloadBufPool - and the returned slice may
+// alias it. entropy selects the payload's compressibility; for
+// PayloadEntropyRandom (the default), if seed is non-zero, the generated
+// bytes are deterministic across runs sharing the same seed, otherwise
+// they're drawn from cryptorand. Seed is ignored for the other entropy
+// kinds, since their output is already deterministic.
+//
+// If scriptCommands is non-empty, it takes priority over everything else:
+// the generated payloads cycle through scriptCommands instead, unprefixed,
+// so they execute for real. See Config.ScriptPath.
+func newPayloadGenerator(seed int64, payloadPrefix string, entropy PayloadEntropy, scriptCommands []string) func(dst []byte, n int64) ([]byte, error) {
+	if len(scriptCommands) > 0 {
+		idx := 0
+		return func(dst []byte, n int64) ([]byte, error) {
+			return scriptPayload(dst, scriptCommands, &idx, n), nil
+		}
+	}
+
+	switch entropy {
+	case PayloadEntropyRepeated:
+		return func(dst []byte, n int64) ([]byte, error) {
+			return repeatedPayload(dst, payloadPrefix, n), nil
+		}
+	case PayloadEntropyText:
+		return func(dst []byte, n int64) ([]byte, error) {
+			return textPayload(dst, payloadPrefix, n), nil
+		}
+	default:
+		if seed == 0 {
+			return func(dst []byte, n int64) ([]byte, error) {
+				return randPayload(dst, payloadPrefix, n)
+			}
+		}
+
+		rnd := rand.New(rand.NewSource(seed))
+		return func(dst []byte, n int64) ([]byte, error) {
+			return seededRandPayload(dst, payloadPrefix, rnd, n)
+		}
+	}
+}
+
+// randPayload generates a cryptographically random payload of exactly n
+// bytes, reusing dst's backing array via buildPayload.
+func randPayload(dst []byte, payloadPrefix string, n int64) ([]byte, error) {
+	fixed := int64(len(payloadPrefix) + 1)
+	randLen := n - fixed
+	if randLen < 0 {
+		randLen = 0
+	}
+
+	s, err := cryptorand.String(int(randLen))
+	if err != nil {
+		return nil, xerrors.Errorf("generate random string: %w", err)
+	}
+
+	return buildPayload(dst, payloadPrefix, []byte(s)), nil
+}
+
+// seededRandPayload generates a payload of exactly n bytes using rnd, so that
+// the same seed produces byte-for-byte identical traffic across runs. dst is
+// reused as scratch space rather than being allocated fresh each call.
+func seededRandPayload(dst []byte, payloadPrefix string, rnd *rand.Rand, n int64) ([]byte, error) {
+	fixed := int64(len(payloadPrefix) + 1)
+	randLen := n - fixed
+	if randLen < 0 {
+		randLen = 0
+	}
+
+	dst = dst[:0]
+	dst = append(dst, payloadPrefix...)
+	for i := int64(0); i < randLen; i++ {
+		dst = append(dst, cryptorand.Default[rnd.Intn(len(cryptorand.Default))])
+	}
+	dst = append(dst, '\n')
+	return dst, nil
+}
+
+// repeatedPatternByte is the byte repeated to fill PayloadEntropyRepeated
+// payloads.
+const repeatedPatternByte = 'A'
+
+// repeatedPayload generates a payload of exactly n bytes, prefixed with
+// payloadPrefix, whose body is a single byte repeated over and over: highly
+// compressible, simulating output like a progress bar or a repeated log
+// line. dst is reused as scratch space rather than being allocated fresh
+// each call.
+func repeatedPayload(dst []byte, payloadPrefix string, n int64) []byte {
+	fixed := int64(len(payloadPrefix) + 1)
+	bodyLen := n - fixed
+	if bodyLen < 0 {
+		bodyLen = 0
+	}
+
+	dst = dst[:0]
+	dst = append(dst, payloadPrefix...)
+	for i := int64(0); i < bodyLen; i++ {
+		dst = append(dst, repeatedPatternByte)
+	}
+	dst = append(dst, '\n')
+	return dst
+}
+
+// loremWords is cycled to build PayloadEntropyText payloads: natural-language
+// prose compresses moderately well, unlike PayloadEntropyRandom's
+// incompressible bytes or PayloadEntropyRepeated's highly compressible ones.
+var loremWords = strings.Fields(
+	"lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod " +
+		"tempor incididunt ut labore et dolore magna aliqua",
+)
+
+// textPayload generates a payload of exactly n bytes, prefixed with
+// payloadPrefix, whose body cycles through loremWords. dst is reused as
+// scratch space rather than being allocated fresh each call.
+func textPayload(dst []byte, payloadPrefix string, n int64) []byte {
+	fixed := int64(len(payloadPrefix) + 1)
+	bodyLen := n - fixed
+	if bodyLen < 0 {
+		bodyLen = 0
+	}
+
+	dst = dst[:0]
+	dst = append(dst, payloadPrefix...)
+	bodyStart := len(dst)
+	for i := 0; int64(len(dst)-bodyStart) < bodyLen; i++ {
+		if i > 0 {
+			dst = append(dst, ' ')
+		}
+		dst = append(dst, loremWords[i%len(loremWords)]...)
+	}
+	if over := int64(len(dst)-bodyStart) - bodyLen; over > 0 {
+		dst = dst[:int64(len(dst))-over]
+	}
+	dst = append(dst, '\n')
+	return dst
+}
+
+// buildPayload appends payloadPrefix, body, and a trailing newline onto
+// dst, reusing dst's backing array when it has enough capacity. dst is
+// truncated to zero length first; the result, which may alias dst, is
+// returned.
+func buildPayload(dst []byte, payloadPrefix string, body []byte) []byte {
+	dst = dst[:0]
+	dst = append(dst, payloadPrefix...)
+	dst = append(dst, body...)
+	dst = append(dst, '\n')
+	return dst
+}
+
+// loadScript reads path and returns its non-empty, trimmed lines in order,
+// for newPayloadGenerator to cycle through when Config.ScriptPath is set. It
+// returns an error if path can't be read or contains no commands.
+func loadScript(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("open script: %w", err)
+	}
+	defer f.Close()
+
+	var commands []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("read script: %w", err)
+	}
+	if len(commands) == 0 {
+		return nil, xerrors.New("script contains no commands")
+	}
+	return commands, nil
+}
+
+// scriptPayload cycles through commands, each terminated with a newline so
+// the PTY executes it as a real command line, fitting as many whole
+// commands as possible into n bytes and padding any leftover room with
+// spaces. idx is the index of the next command to emit and is only advanced
+// past commands that were written in full, so a command that doesn't fit in
+// this call's budget is never split across a line boundary: splitting it
+// would leave its trailing newline behind, and the next call would glue the
+// following command onto its unterminated remainder, corrupting both into
+// one garbage line. That command is instead retried whole once a later
+// call's budget is large enough to hold it. dst is reused as scratch space
+// rather than being allocated fresh each call.
+func scriptPayload(dst []byte, commands []string, idx *int, n int64) []byte {
+	dst = dst[:0]
+	for {
+		cmd := commands[*idx%len(commands)]
+		if int64(len(dst)+len(cmd)+1) > n { // +1 for the trailing newline
+			break
+		}
+		dst = append(dst, cmd...)
+		dst = append(dst, '\n')
+		*idx++
+		if int64(len(dst)) >= n {
+			break
+		}
+	}
+
+	for int64(len(dst)) < n {
+		dst = append(dst, ' ')
+	}
+	return dst
+}
+
+// replayEvent is one captured write replayWriteLoop replays: Data, sent
+// Delay after the previous event (or after the replay starts, for the first
+// event). See loadReplay.
+type replayEvent struct {
+	Delay time.Duration
+	Data  []byte
+}
+
+// replayCaptureLine is the on-disk JSON Lines format for Config.ReplayFile:
+// one line per captured write, DelayMS the time in milliseconds since the
+// previous event (or since replay start, for the first line), and Data the
+// raw bytes written.
+type replayCaptureLine struct {
+	DelayMS int64  `json:"delay_ms"`
+	Data    string `json:"data"`
+}
+
+// loadReplay reads path as JSON Lines of replayCaptureLine, one per line,
+// for replayWriteLoop to step through in order when Config.ReplayFile is
+// set. It returns an error if path can't be read, a line can't be decoded,
+// or the file contains no events.
+func loadReplay(path string) ([]replayEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("open replay file: %w", err)
+	}
+	defer f.Close()
+
+	var events []replayEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var l replayCaptureLine
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			return nil, xerrors.Errorf("decode replay line: %w", err)
+		}
+		events = append(events, replayEvent{Delay: time.Duration(l.DelayMS) * time.Millisecond, Data: []byte(l.Data)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("read replay file: %w", err)
+	}
+	if len(events) == 0 {
+		return nil, xerrors.New("replay file contains no events")
+	}
+	return events, nil
+}
+
+// replayWriteLoop writes each of events' Data to rw, waiting Delay between
+// consecutive writes to preserve the capture's original timing. If loop is
+// true, it restarts from the first event once the last one has been
+// written, repeating the capture for the remainder of the run instead of
+// going idle. Byte counting and latency measurement happen exactly as with
+// any other write loop, since rw is the same countReadWriter every other
+// write loop writes through. Returns nil once ctx is done, a write fails
+// with a context error, or budget (if non-nil) is exhausted, and a wrapped
+// error for any other write failure.
+func replayWriteLoop(ctx context.Context, rw io.Writer, events []replayEvent, loop bool, budget *byteBudget) error {
+	for i := 0; ; i++ {
+		if i >= len(events) {
+			if !loop {
+				return nil
+			}
+			i = 0
+		}
+		ev := events[i]
+		if ev.Delay > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(ev.Delay):
+			}
+		}
+
+		data := ev.Data
+		if budget != nil {
+			n := budget.take(int64(len(data)))
+			if n <= 0 {
+				return nil
+			}
+			data = data[:n]
+		}
+
+		if _, err := rw.Write(data); err != nil {
+			if xerrors.Is(err, context.Canceled) || xerrors.Is(err, context.DeadlineExceeded) {
+				return nil
+			}
+			return xerrors.Errorf("write replay event: %w", err)
+		}
+	}
+}
+
+// markerPrefix and markerSuffix bracket the index scriptedRequestResponseLoop
+// embeds in each command's completion marker (e.g. "__DONE_3__"), making it
+// distinctive enough that it's vanishingly unlikely to appear in a command's
+// own legitimate output.
+const (
+	markerPrefix = "__DONE_"
+	markerSuffix = "__"
+)
+
+// scriptedRequestResponseLoop drives commands as a request/response
+// workload instead of writing them at a fixed rate: each command is
+// followed by "echo" of a unique marker, and the loop blocks until that
+// marker is read back (via crw.awaitMarker) before sending the next
+// command, timing out after markerTimeout if it never arrives. Used instead
+// of tickedWriteLoop/rateLimitedWriteLoop when Config.ScriptWaitForMarker is
+// set. Returns the per-command completion latencies it measured, in the
+// order the commands were sent, and the first error encountered - a write
+// failure, or a marker that never arrived. Returns cleanly, with no error,
+// once ctx is done or budget (if non-nil) is exhausted.
+func scriptedRequestResponseLoop(ctx context.Context, crw *countReadWriter, commands []string, markerTimeout time.Duration, budget *byteBudget) ([]time.Duration, error) {
+	if markerTimeout <= 0 {
+		markerTimeout = DefaultMarkerTimeout
+	}
+
+	var latencies []time.Duration
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return latencies, nil
+		default:
+		}
+
+		cmd := commands[i%len(commands)]
+		marker := markerPrefix + strconv.Itoa(i) + markerSuffix
+		payload := []byte(cmd + "\necho " + marker + "\n")
+
+		if budget != nil && budget.take(int64(len(payload))) <= 0 {
+			return latencies, nil
+		}
+
+		done := crw.awaitMarker(marker)
+		start := time.Now()
+		if _, err := crw.Write(payload); err != nil {
+			return latencies, xerrors.Errorf("write command %d: %w", i, err)
+		}
+
+		timer := time.NewTimer(markerTimeout)
+		select {
+		case <-done:
+			timer.Stop()
+			latencies = append(latencies, time.Since(start))
+		case <-ctx.Done():
+			timer.Stop()
+			return latencies, nil
+		case <-timer.C:
+			return latencies, xerrors.Errorf("command %d: marker %q not observed within %s", i, marker, markerTimeout)
+		}
+	}
+}
+
+// errReadIdleTimeout is returned by drainContext when idleTimeout is set and
+// elapses with no bytes read, indicating the connection stayed open but
+// stopped echoing data.
+var errReadIdleTimeout = xerrors.New("read idle timeout exceeded")
+
+// bytesPool is a sync.Pool of reusable byte slices, keyed by nothing but a
+// default starting capacity: get returns a slice of length n, growing a
+// pooled slice's backing array if it's too small, and put returns a slice
+// for later reuse. Used to keep high-session-count and reconnect-heavy runs
+// from thrashing the allocator with per-call buffers that would otherwise
+// be thrown away moments after being filled. Safe for concurrent use.
+type bytesPool struct {
+	pool sync.Pool
+}
+
+func newBytesPool(defaultCap int) *bytesPool {
+	return &bytesPool{
+		pool: sync.Pool{
+			New: func() any {
+				buf := make([]byte, 0, defaultCap)
+				return &buf
+			},
+		},
+	}
+}
+
+func (p *bytesPool) get(n int) []byte {
+	buf := *p.pool.Get().(*[]byte)
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
+
+func (p *bytesPool) put(buf []byte) {
+	p.pool.Put(&buf)
+}
+
+// readBufPool pools drainContext's bulk-read buffers. See bytesPool.
+var readBufPool = newBytesPool(DefaultReadBufferSize)
+
+// drainContext reads from r until ctx is canceled or a read fails, discarding
+// all data into a single fixed-size buffer that's reused for every read
+// rather than accumulated, so memory stays flat no matter how long the run
+// lasts; the buffer itself comes from readBufPool rather than being
+// allocated fresh, so repeated calls across many sessions or reconnects
+// don't each pay for their own allocation. It is responsible for feeding
+// read bytes into crw's latency
+// sampler; r is expected to be a *countReadWriter (or similar), which
+// captures whatever bytes it needs for VerifyEcho checking out of each
+// individual read before this loop reuses the buffer. bufSize controls the
+// size of the bulk-read buffer and is unrelated to bytesPerTick. If capture
+// is non-nil, every chunk read is also written to it, for Config.EchoCapture;
+// capture errors are ignored since echo capture is best-effort. If
+// idleTimeout is greater than zero and that much time passes without a
+// single byte being read, drainContext returns errReadIdleTimeout instead of
+// waiting for ctx to end. If ctx ends on its own, drainContext distinguishes
+// why: a deadline being reached is a clean stop and returns nil, while an
+// explicit cancellation (e.g. another agent failing with Config.FailFast
+// set) is an interruption and returns context.Canceled, so the caller can
+// tell "this agent finished" apart from "this agent was cut short". It
+// returns the read error otherwise (often io.EOF, if the remote end closed
+// the connection).
+//
+// The background goroutine actually blocked in r.Read may still be running
+// when drainContext returns due to ctx being canceled or idleTimeout
+// elapsing, since Read has no way to be interrupted directly and closing r
+// is the caller's responsibility. If readExited is non-nil, it's closed once
+// that goroutine exits, letting a caller that closes r afterward confirm the
+// goroutine didn't outlive it. See Config.LeakCheck.
+func drainContext(ctx context.Context, r io.Reader, bufSize int, readExited chan<- struct{}, capture io.Writer, idleTimeout time.Duration) error {
+	buf := readBufPool.get(bufSize)
+	readErr := make(chan error, 1)
+	read := make(chan struct{}, 1)
+	go func() {
+		// buf is only returned to the pool once this goroutine is done
+		// reading into it, since it may still be blocked in r.Read(buf)
+		// after drainContext itself has already returned - see the doc
+		// comment above.
+		defer readBufPool.put(buf)
+		if readExited != nil {
+			defer close(readExited)
+		}
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if capture != nil {
+					_, _ = capture.Write(buf[:n])
+				}
+				select {
+				case read <- struct{}{}:
+				default:
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	var idleC <-chan time.Time
+	if idleTimeout > 0 {
+		idleTimer := time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idleC = idleTimer.C
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctxEndErr(ctx)
+			case err := <-readErr:
+				return err
+			case <-read:
+				if !idleTimer.Stop() {
+					<-idleTimer.C
+				}
+				idleTimer.Reset(idleTimeout)
+			case <-idleC:
+				return errReadIdleTimeout
+			}
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctxEndErr(ctx)
+	case err := <-readErr:
+		return err
+	}
+}
+
+// ctxEndErr normalizes why ctx ended: a deadline being reached is a clean
+// stop (nil), while an explicit cancellation is an interruption signal
+// (context.Canceled) that the caller should be able to distinguish from a
+// clean stop.
+func ctxEndErr(ctx context.Context) error {
+	if xerrors.Is(ctx.Err(), context.Canceled) {
+		return context.Canceled
+	}
+	return nil
+}
+
+// cappedWriter wraps an io.Writer, dropping bytes once max have been written
+// so Config.EchoCapture can't fill the disk during a long run. Writes past
+// the cap are silent no-ops; truncated reports whether the cap was ever
+// reached. Errors from the underlying writer are swallowed, since echo
+// capture is a debugging aid and shouldn't affect the outcome of a run.
+type cappedWriter struct {
+	w         io.Writer
+	max       int64
+	written   int64
+	truncated bool
+}
+
+func newCappedWriter(w io.Writer, max int64) *cappedWriter {
+	return &cappedWriter{w: w, max: max}
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if c.written >= c.max {
+		c.truncated = true
+		return len(p), nil
+	}
+	if remaining := c.max - c.written; int64(len(p)) > remaining {
+		p = p[:remaining]
+		c.truncated = true
+	}
+	n, _ := c.w.Write(p)
+	c.written += int64(n)
+	return len(p), nil
+}
+
+// reportProgress invokes progressFn every interval with crw's cumulative
+// counters and the time elapsed since start, until ctx is canceled.
+func reportProgress(ctx context.Context, interval time.Duration, start time.Time, crw *countReadWriter, progressFn func(sent, rcvd int64, elapsed time.Duration)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			progressFn(crw.writeBytes(), crw.readBytes(), time.Since(start))
+		}
+	}
+}
+
+// forceReconnectLoop calls cm.forceReconnect every interval until ctx is
+// done, soak-testing the reconnect path under Config.ReconnectInterval
+// independently of any error-driven reconnects. A forced reconnect that
+// fails is logged and retried on the next tick rather than ending the run,
+// since the write/read loops will themselves fail (and trigger their own
+// error-driven reconnect, if enabled) against a connection that's actually
+// broken.
+func forceReconnectLoop(ctx context.Context, logger slog.Logger, cm *connManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cm.forceReconnect(ctx); err != nil {
+				logger.Warn(ctx, "forced reconnect failed", slog.Error(err))
+			}
+		}
+	}
+}
+
+// percentiles returns the p50, p95, p99 and max of the given latency
+// samples. If samples is empty, all zero values are returned.
+func percentiles(samples []time.Duration) (p50, p95, p99, max time.Duration) {
+	if len(samples) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	percentile := func(p float64) time.Duration {
+		idx := int(p * float64(len(sorted)-1))
+		return sorted[idx]
+	}
+
+	return percentile(0.5), percentile(0.95), percentile(0.99), sorted[len(sorted)-1]
+}
+
+// throughputPercentiles computes the p5, p50, and p95 bytes/second rates
+// across samples, dividing each window's byte count (selected by bytesOf)
+// by windowWidth. p5 surfaces the slowest windows rather than averaging them
+// away, a more meaningful sustained-capacity figure when throughput has
+// periodic stalls. Returns zeros if samples is empty or windowWidth isn't
+// positive.
+func throughputPercentiles(samples []ThroughputSample, windowWidth time.Duration, bytesOf func(ThroughputSample) int64) (p5, p50, p95 float64) {
+	if len(samples) == 0 || windowWidth <= 0 {
+		return 0, 0, 0
+	}
+
+	rates := make([]float64, len(samples))
+	for i, s := range samples {
+		rates[i] = float64(bytesOf(s)) / windowWidth.Seconds()
+	}
+	sort.Float64s(rates)
+
+	percentile := func(p float64) float64 {
+		idx := int(p * float64(len(rates)-1))
+		return rates[idx]
+	}
+
+	return percentile(0.05), percentile(0.5), percentile(0.95)
+}
+
+// coefficientOfVariation returns the population standard deviation of values
+// divided by their mean, a scale-independent measure of how unevenly values
+// are spread (0 means every value is identical). Returns 0 if there are
+// fewer than two values or the mean is zero, rather than dividing by zero.
+func coefficientOfVariation(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+
+	var variance float64
+	for _, v := range values {
+		d := v - mean
+		variance += d * d
+	}
+	variance /= float64(len(values))
+
+	return math.Sqrt(variance) / mean
+}
+
+// mergeThroughputSamples combines into's running totals with next, a single
+// agent's samples, summing BytesRead and BytesWritten at matching indices.
+// This relies on every agent sharing the same countReadWriter start and
+// sampleInterval, so index i always refers to the same time window across
+// agents. The returned slice is grown to cover whichever of into or next
+// has more samples.
+func mergeThroughputSamples(into, next []ThroughputSample) []ThroughputSample {
+	for len(into) < len(next) {
+		into = append(into, ThroughputSample{Time: next[len(into)].Time})
+	}
+	for i, s := range next {
+		into[i].BytesRead += s.BytesRead
+		into[i].BytesWritten += s.BytesWritten
+	}
+	return into
+}
+
+// reasonPriority orders Reason values so the most specific/serious one wins
+// when aggregating across agents: an error outranks stalled, which outranks
+// canceled, which outranks max_bytes, which outranks eof, which outranks the
+// default deadline.
+func reasonPriority(r Reason) int {
+	switch r {
+	case ReasonError:
+		return 5
+	case ReasonStalled:
+		return 4
+	case ReasonCanceled:
+		return 3
+	case ReasonMaxBytes:
+		return 2
+	case ReasonEOF:
+		return 1
+	case ReasonDeadline:
+		return 0
+	default:
+		return -1
+	}
+}
+
+// mergeChunkSizeCounts combines into's running totals with next, a single
+// agent's chunkSizeCounts, summing matching buckets. Every agent shares the
+// same chunkSizeBucketBounds, so index i always refers to the same bucket
+// across agents.
+func mergeChunkSizeCounts(into, next []int64) []int64 {
+	for len(into) < len(next) {
+		into = append(into, 0)
+	}
+	for i, n := range next {
+		into[i] += n
+	}
+	return into
+}
+
+// aggregateByCommand groups results by the command each session ran and
+// summarizes each group's bytes, throughput, and latency percentiles, for
+// Results.ByCommand. duration is the same measured duration used to compute
+// Results.ThroughputSent. Returns nil unless results span more than one
+// distinct command, since a single-command run has nothing to compare the
+// breakdown against.
+func aggregateByCommand(results []agentResult, duration time.Duration) []CommandResult {
+	type agg struct {
+		sessions  int
+		bytesSent uint64
+		bytesRcvd uint64
+		latencies []time.Duration
+	}
+
+	byCommand := make(map[string]*agg)
+	for _, ar := range results {
+		a, ok := byCommand[ar.command]
+		if !ok {
+			a = &agg{}
+			byCommand[ar.command] = a
+		}
+		a.sessions++
+		a.bytesSent += ar.bytesSent
+		a.bytesRcvd += ar.bytesRcvd
+		a.latencies = append(a.latencies, ar.latencies...)
+	}
+	if len(byCommand) <= 1 {
+		return nil
+	}
+
+	commands := make([]string, 0, len(byCommand))
+	for command := range byCommand {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	out := make([]CommandResult, len(commands))
+	for i, command := range commands {
+		a := byCommand[command]
+		p50, p95, p99, max := percentiles(a.latencies)
+		out[i] = CommandResult{
+			Command:        command,
+			Sessions:       a.sessions,
+			BytesSent:      a.bytesSent,
+			BytesRcvd:      a.bytesRcvd,
+			ThroughputSent: float64(a.bytesSent) / duration.Seconds(),
+			ThroughputRcvd: float64(a.bytesRcvd) / duration.Seconds(),
+			LatencySamples: len(a.latencies),
+			LatencyP50:     p50,
+			LatencyP95:     p95,
+			LatencyP99:     p99,
+			LatencyMax:     max,
+		}
+	}
+	return out
+}
+
+// countReadWriter wraps an io.ReadWriter, counting bytes read and written
+// and sampling the round-trip latency between each write and the echoed
+// read that follows it. Writes that are never echoed back before the
+// connection is closed are simply left pending and excluded from the
+// samples.
+type countReadWriter struct {
+	rw      io.ReadWriter
+	metrics *promMetrics
+
+	// warmupUntil is the time (UnixNano, via atomic.Int64 since
+	// extendWarmupUntil can push it forward concurrently with Read/Write
+	// checking it) after which bytes and latency are counted. Traffic
+	// flows normally before this point; it's simply excluded from the
+	// accumulated results.
+	warmupUntil atomic.Int64
+
+	// inSetup is set for the duration of runSetupCommands, excluding every
+	// byte written and read in that window regardless of warmupUntil -
+	// unlike Warmup, a fixed duration computed up front, SetupCommands'
+	// length isn't known ahead of time, so there's no warmupUntil value
+	// that could be computed before it runs. See beginSetup/endSetup.
+	inSetup atomic.Bool
+
+	// start and sampleInterval locate a given timestamp within buckets, the
+	// per-window byte counters backing throughputSamples.
+	start          time.Time
+	sampleInterval time.Duration
+
+	writes     int64
+	wireWrites int64
+	reads      int64
+
+	// writeMessages and readFrames count discrete Write/Read calls rather
+	// than bytes, so the caller can see how much of the wire rate is
+	// message-framing overhead rather than payload at high tick rates. For
+	// ConnectionTypePTY every write is one codersdk.ReconnectingPTYRequest
+	// JSON message; for ConnectionTypeSSH it's one raw stdin write.
+	writeMessages int64
+	readFrames    int64
+
+	// writeDuration is the cumulative time spent inside Write calls since
+	// warmup ended, including time blocked by backpressure from a full
+	// receive buffer. See writeBlockedDuration.
+	writeDuration time.Duration
+
+	// verifyEcho enables byte-for-byte corruption checking of echoed data.
+	// See expected and corrupted below.
+	verifyEcho bool
+
+	mu      sync.Mutex
+	pending []pendingWrite
+	samples []time.Duration
+	// keepaliveRTTs holds the round-trip time of every WriteKeepalive
+	// write matched against its echo, kept separate from samples so a
+	// keepalive ping's RTT never factors into Results.LatencyP50. See
+	// pendingWrite.isKeepalive.
+	keepaliveRTTs []time.Duration
+	lastSample    time.Duration
+	hasSample     bool
+	// sampleConsumed is set once latestLatency has reported lastSample, so
+	// a caller that polls every tick (tickedWriteLoop's adaptive pattern)
+	// sees a fresh sample exactly once instead of repeatedly reacting to
+	// the same echo. See latestLatency.
+	sampleConsumed bool
+	buckets        []throughputBucket
+
+	// expected holds the post-warmup bytes that have been written but not
+	// yet matched against an echoed read, in the order they were sent. It's
+	// only populated when verifyEcho is set.
+	expected  []byte
+	corrupted int64
+
+	// chunkSizeHist is the number of Read calls whose returned byte count
+	// fell into each bucket of chunkSizeBucketBounds, plus a final overflow
+	// bucket for anything larger than the last bound. See
+	// recordChunkSizeLocked.
+	chunkSizeHist []int64
+
+	// markerMu guards awaitedMarker, markerBuf, and markerFound, which are
+	// set by awaitMarker and read by every Read call regardless of warmup or
+	// setup state - a separate lock from mu so marker scanning never
+	// contends with the byte/latency accounting Read and write already do
+	// under mu. See scriptedRequestResponseLoop.
+	markerMu      sync.Mutex
+	awaitedMarker string
+	markerBuf     []byte
+	markerFound   chan struct{}
+}
+
+// chunkSizeBucketBounds are the upper bounds, in bytes and inclusive, of
+// each bucket in Results.ChunkSizeBuckets, doubling from 64 bytes up to
+// 64KiB. A final, implicit bucket catches anything larger than the last
+// bound.
+var chunkSizeBucketBounds = []int64{64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+
+type pendingWrite struct {
+	remaining int
+	sentAt    time.Time
+	// isKeepalive marks a write made by WriteKeepalive rather than Write, so
+	// recordLatencyLocked can route its matched round-trip into
+	// keepaliveSamples instead of samples, keeping keepalive RTT out of
+	// Results.LatencyP50. See keepaliveSamples.
+	isKeepalive bool
+}
+
+type throughputBucket struct {
+	bytesRead    int64
+	bytesWritten int64
+}
+
+// newCountReadWriter wraps rw, optionally pushing live updates to metrics as
+// data is written and read. metrics may be nil. Bytes and latency recorded
+// during the warmup window are excluded from the accumulated counters.
+// start and sampleInterval locate each byte counted against a
+// throughputSamples window; start should be the same instant across every
+// agent in a run so their samples line up when aggregated.
+func newCountReadWriter(rw io.ReadWriter, metrics *promMetrics, warmup time.Duration, start time.Time, sampleInterval time.Duration, verifyEcho bool) *countReadWriter {
+	crw := &countReadWriter{
+		rw:             rw,
+		metrics:        metrics,
+		start:          start,
+		sampleInterval: sampleInterval,
+		verifyEcho:     verifyEcho,
+		chunkSizeHist:  make([]int64, len(chunkSizeBucketBounds)+1),
+	}
+	crw.warmupUntil.Store(time.Now().Add(warmup).UnixNano())
+	return crw
+}
+
+// beginSetup marks c as being in its SetupCommands phase: every byte
+// written and read while this holds is excluded from Results, regardless
+// of warmupUntil. See endSetup.
+func (c *countReadWriter) beginSetup() {
+	c.inSetup.Store(true)
+}
+
+// endSetup ends the SetupCommands phase started by beginSetup, and pushes
+// c's warmup window forward to at least now, so that if Config.Warmup's own
+// window had already elapsed before setup finished, the measured phase
+// still starts counting from here rather than retroactively.
+func (c *countReadWriter) endSetup() {
+	now := time.Now().UnixNano()
+	for {
+		cur := c.warmupUntil.Load()
+		if now <= cur || c.warmupUntil.CompareAndSwap(cur, now) {
+			break
+		}
+	}
+	c.inSetup.Store(false)
+}
+
+// awaitMarker arms c to watch every subsequent Read for marker, returning a
+// channel that's closed once marker has been observed, possibly split
+// across more than one Read call. Only one marker can be awaited at a time;
+// arming a new one abandons whatever partial match was in progress for the
+// last. See scriptedRequestResponseLoop, the only caller.
+func (c *countReadWriter) awaitMarker(marker string) <-chan struct{} {
+	ch := make(chan struct{})
+	c.markerMu.Lock()
+	c.awaitedMarker = marker
+	c.markerBuf = c.markerBuf[:0]
+	c.markerFound = ch
+	c.markerMu.Unlock()
+	return ch
+}
+
+// scanMarker appends p to the in-progress marker match and closes
+// markerFound once the full marker text has been observed. It's called from
+// every Read regardless of warmup/setup state, since matching a marker is a
+// synchronization signal, not measured traffic in its own right.
+func (c *countReadWriter) scanMarker(p []byte) {
+	c.markerMu.Lock()
+	defer c.markerMu.Unlock()
+	if c.awaitedMarker == "" {
+		return
+	}
+	c.markerBuf = append(c.markerBuf, p...)
+	// Only the tail long enough to still contain a full match matters, so a
+	// long-running command that never emits its marker doesn't grow
+	// markerBuf without bound.
+	if max := len(c.awaitedMarker) * 2; len(c.markerBuf) > max {
+		c.markerBuf = c.markerBuf[len(c.markerBuf)-max:]
+	}
+	if bytes.Contains(c.markerBuf, []byte(c.awaitedMarker)) {
+		close(c.markerFound)
+		c.awaitedMarker = ""
+		c.markerBuf = c.markerBuf[:0]
+	}
+}
+
+func (c *countReadWriter) Write(p []byte) (int, error) {
+	return c.write(p, false)
+}
+
+// WriteKeepalive writes p exactly like Write, except the round-trip it
+// completes is timed separately: recordLatencyLocked routes the matching
+// echo into keepaliveSamples instead of samples, so an application-level
+// keepalive ping's RTT is reported via Results.KeepaliveP50 and doesn't
+// skew Results.LatencyP50. p is still counted against expected when
+// verifyEcho is set, so the ping's echo is checked for corruption like any
+// other write.
+func (c *countReadWriter) WriteKeepalive(p []byte) (int, error) {
+	return c.write(p, true)
+}
+
+func (c *countReadWriter) write(p []byte, isKeepalive bool) (int, error) {
+	writeStart := time.Now()
+	n, err := c.rw.Write(p)
+	writeDuration := time.Since(writeStart)
+	if n > 0 {
+		now := time.Now()
+		if !c.inSetup.Load() && now.UnixNano() >= c.warmupUntil.Load() {
+			wire := n
+			if wbw, ok := c.rw.(wireByteWriter); ok {
+				wire = wbw.wireBytesWritten()
+			}
+
+			c.mu.Lock()
+			c.writes += int64(n)
+			c.wireWrites += int64(wire)
+			c.writeMessages++
+			c.writeDuration += writeDuration
+			c.pending = append(c.pending, pendingWrite{remaining: n, sentAt: now, isKeepalive: isKeepalive})
+			c.bucketLocked(now).bytesWritten += int64(n)
+			if c.verifyEcho {
+				c.expected = append(c.expected, p[:n]...)
+			}
+			c.mu.Unlock()
+
+			if c.metrics != nil {
+				c.metrics.bytesWritten.Add(float64(n))
+			}
+		}
+	}
+	return n, err
+}
+
+func (c *countReadWriter) Read(p []byte) (int, error) {
+	n, err := c.rw.Read(p)
+	if n > 0 {
+		c.scanMarker(p[:n])
+	}
+	if n > 0 && !c.inSetup.Load() && time.Now().UnixNano() >= c.warmupUntil.Load() {
+		now := time.Now()
+		c.mu.Lock()
+		c.reads += int64(n)
+		c.readFrames++
+		c.recordLatencyLocked(n)
+		c.recordChunkSizeLocked(n)
+		c.bucketLocked(now).bytesRead += int64(n)
+		if c.verifyEcho {
+			c.verifyEchoLocked(p[:n])
+		}
+		c.mu.Unlock()
+
+		if c.metrics != nil {
+			c.metrics.bytesRead.Add(float64(n))
+		}
+	}
+	return n, err
+}
+
+// bucketLocked returns the throughputBucket that t falls into, growing
+// c.buckets as needed. c.mu must be held.
+func (c *countReadWriter) bucketLocked(t time.Time) *throughputBucket {
+	idx := int(t.Sub(c.start) / c.sampleInterval)
+	if idx < 0 {
+		idx = 0
+	}
+	for len(c.buckets) <= idx {
+		c.buckets = append(c.buckets, throughputBucket{})
+	}
+	return &c.buckets[idx]
+}
+
+// recordLatencyLocked matches n bytes of echoed data against the oldest
+// pending writes, recording a latency sample for each write (or partial
+// write) that is fully or partially matched. c.mu must be held.
+func (c *countReadWriter) recordLatencyLocked(n int) {
+	now := time.Now()
+	for n > 0 && len(c.pending) > 0 {
+		w := &c.pending[0]
+		sample := now.Sub(w.sentAt)
+		if w.isKeepalive {
+			c.keepaliveRTTs = append(c.keepaliveRTTs, sample)
+		} else {
+			c.samples = append(c.samples, sample)
+			c.lastSample = sample
+			c.hasSample = true
+			c.sampleConsumed = false
+			if c.metrics != nil {
+				c.metrics.latency.Observe(sample.Seconds())
+			}
+		}
+		if w.remaining <= n {
+			n -= w.remaining
+			c.pending = c.pending[1:]
+			continue
+		}
+		w.remaining -= n
+		n = 0
+	}
+}
+
+// recordChunkSizeLocked increments whichever bucket of c.chunkSizeHist n
+// falls into, using chunkSizeBucketBounds as each bucket's inclusive upper
+// bound and a final overflow bucket for anything larger than the last
+// bound. c.mu must be held.
+func (c *countReadWriter) recordChunkSizeLocked(n int) {
+	for i, bound := range chunkSizeBucketBounds {
+		if int64(n) <= bound {
+			c.chunkSizeHist[i]++
+			return
+		}
+	}
+	c.chunkSizeHist[len(c.chunkSizeHist)-1]++
+}
+
+// verifyEchoLocked matches data against the oldest bytes in c.expected,
+// counting a corrupted byte for every mismatch, and drops whatever it
+// matches (or skips, if mismatched) from the front of c.expected. Bytes
+// read beyond what's currently expected — for example the shell
+// redisplaying its prompt after a comment line — are left unverified.
+// c.mu must be held.
+func (c *countReadWriter) verifyEchoLocked(data []byte) {
+	data = stripEchoedCRLF(data)
+	for len(data) > 0 && len(c.expected) > 0 {
+		n := len(data)
+		if len(c.expected) < n {
+			n = len(c.expected)
+		}
+		for i := 0; i < n; i++ {
+			if data[i] != c.expected[i] {
+				c.corrupted++
+			}
+		}
+		c.expected = c.expected[n:]
+		data = data[n:]
+	}
+}
+
+// stripEchoedCRLF drops the '\r' from every "\r\n" in data, returning a new
+// slice that leaves data untouched. A PTY connection's line discipline
+// echoes every outbound '\n' as "\r\n"; since the payload alphabet never
+// contains '\r' or '\n' itself (see cryptorand.Default), any "\r\n" seen
+// here can only be that translation, not a mismatch worth reporting.
+func stripEchoedCRLF(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
+
+func (c *countReadWriter) writeBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes
+}
+
+// wireWriteBytes returns the cumulative bytes actually put on the wire by
+// every Write call, which may exceed writeBytes if the underlying writer
+// adds protocol framing on top of the payload. See wireByteWriter.
+func (c *countReadWriter) wireWriteBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wireWrites
+}
+
+// writeMessageCount returns the number of successful Write calls, each of
+// which is one discrete message on the wire (one codersdk.ReconnectingPTYRequest
+// for ConnectionTypePTY, one raw write for ConnectionTypeSSH).
+func (c *countReadWriter) writeMessageCount() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeMessages
+}
+
+// readFrameCount returns the number of successful Read calls that returned
+// data, each counted as one discrete frame received off the wire.
+func (c *countReadWriter) readFrameCount() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readFrames
+}
+
+func (c *countReadWriter) readBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reads
+}
+
+// writeBlockedDuration returns the cumulative time spent inside Write calls
+// since warmup ended, including time blocked by backpressure from a full
+// receive buffer.
+func (c *countReadWriter) writeBlockedDuration() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeDuration
+}
+
+func (c *countReadWriter) latencySamples() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]time.Duration, len(c.samples))
+	copy(out, c.samples)
+	return out
+}
+
+// keepaliveSamples returns the round-trip time of every keepalive ping
+// matched against its echo, kept separate from latencySamples. Always
+// empty unless Config.KeepaliveInterval is set.
+func (c *countReadWriter) keepaliveSamples() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]time.Duration, len(c.keepaliveRTTs))
+	copy(out, c.keepaliveRTTs)
+	return out
+}
+
+// latestLatency returns the most recently recorded latency sample, and
+// whether it's new since the last call. Once a sample has been returned
+// with ok true, subsequent calls report ok false until a new echo arrives
+// and recordLatencyLocked records another one: without this, a caller
+// polling once per tick (tickedWriteLoop's adaptive pattern) would see the
+// same stale sample on every tick between echoes and react to it over and
+// over, e.g. applying a multiplicative backoff dozens of times for a single
+// latency spike. Unlike latencySamples, this doesn't copy the full,
+// ever-growing sample slice, so it's cheap enough to call once per tick.
+func (c *countReadWriter) latestLatency() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.hasSample || c.sampleConsumed {
+		return 0, false
+	}
+	c.sampleConsumed = true
+	return c.lastSample, true
+}
+
+// corruptedBytes returns the number of echoed bytes that didn't match what
+// was written, counted so far. Always 0 unless verifyEcho is set.
+func (c *countReadWriter) corruptedBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.corrupted
+}
+
+// missingBytes returns the number of written bytes still awaiting an
+// echoed read. A non-zero value once the run has ended means that much
+// data was sent but never came back. Always 0 unless verifyEcho is set.
+func (c *countReadWriter) missingBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int64(len(c.expected))
+}
+
+// pendingBytes returns the total size of writes recorded in c.pending that
+// haven't yet been matched against an echoed read, i.e. how many bytes are
+// currently in flight. Unlike missingBytes, this is tracked regardless of
+// verifyEcho, so it's safe to poll from waitForInFlightCapacity on every
+// run.
+func (c *countReadWriter) pendingBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total int64
+	for _, w := range c.pending {
+		total += int64(w.remaining)
+	}
+	return total
+}
+
+// chunkSizeCounts returns the number of Read calls whose returned byte count
+// fell into each bucket of chunkSizeBucketBounds, plus a final overflow
+// bucket for anything larger than the last bound, in order.
+func (c *countReadWriter) chunkSizeCounts() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]int64, len(c.chunkSizeHist))
+	copy(out, c.chunkSizeHist)
+	return out
+}
+
+// throughputSamples returns one ThroughputSample per sampleInterval-wide
+// window elapsed so far, in order starting from start.
+func (c *countReadWriter) throughputSamples() []ThroughputSample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]ThroughputSample, len(c.buckets))
+	for i, b := range c.buckets {
+		out[i] = ThroughputSample{
+			Time:         time.Duration(i) * c.sampleInterval,
+			BytesRead:    b.bytesRead,
+			BytesWritten: b.bytesWritten,
+		}
+	}
+	return out
+}
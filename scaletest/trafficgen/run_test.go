@@ -0,0 +1,2425 @@
+package trafficgen_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
+
+	"cdr.dev/slog"
+	"cdr.dev/slog/sloggers/slogtest"
+	"github.com/coder/coder/agent"
+	"github.com/coder/coder/coderd/coderdtest"
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/codersdk"
+	"github.com/coder/coder/codersdk/agentsdk"
+	"github.com/coder/coder/provisioner/echo"
+	"github.com/coder/coder/provisionersdk/proto"
+	"github.com/coder/coder/scaletest/trafficgen"
+	"github.com/coder/coder/testutil"
+)
+
+func Test_Runner(t *testing.T) {
+	t.Parallel()
+
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.NoError(t, err)
+
+		require.Contains(t, logStr, "results")
+		require.Contains(t, logStr, "latency_p50")
+		require.Contains(t, logStr, "throughput_achieved_ratio")
+	})
+
+	t.Run("CustomCommand", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			Command:        "cat",
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+	})
+
+	t.Run("GenerateReadCommand", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:            []uuid.UUID{agentID},
+			GenerateReadCommand: true,
+			ReadBytesPerSecond:  1024,
+			BytesPerSecond:      1024,
+			TicksPerSecond:      10,
+			Duration:            httpapi.Duration(2 * time.Second),
+			MetricsWriter:       metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.BytesRcvd, uint64(0))
+	})
+
+	t.Run("ReadRateFairness", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:            []uuid.UUID{agentID},
+			SessionsPerAgent:    4,
+			GenerateReadCommand: true,
+			ReadBytesPerSecond:  4096,
+			BytesPerSecond:      1024,
+			TicksPerSecond:      10,
+			Duration:            httpapi.Duration(2 * time.Second),
+			MetricsWriter:       metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.BytesRcvd, uint64(0))
+		require.GreaterOrEqual(t, res.ReadRateFairnessCoV, 0.0)
+	})
+
+	t.Run("TotalTicks", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1000,
+			TicksPerSecond: 10,
+			TotalTicks:     5,
+			Duration:       httpapi.Duration(time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.EqualValues(t, 5*100, res.BytesSent)
+	})
+
+	t.Run("ResultsEndpoint", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		var mu sync.Mutex
+		var received trafficgen.Results
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			err := json.NewDecoder(r.Body).Decode(&received)
+			require.NoError(t, err)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer collector.Close()
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:        []uuid.UUID{agentID},
+			BytesPerSecond:  1024,
+			TicksPerSecond:  10,
+			Duration:        httpapi.Duration(time.Second),
+			ResultsEndpoint: collector.URL,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Greater(t, received.BytesSent, uint64(0))
+	})
+
+	t.Run("ReconnectToken", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+		token := uuid.New()
+
+		cfg := trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ReconnectToken: token,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		// The first run establishes the reconnecting PTY session under
+		// token. The second reattaches to that same session rather than
+		// starting a fresh shell, modeling a second Runner invocation
+		// resuming a prior one.
+		firstMetrics := bytes.NewBuffer(nil)
+		cfg.MetricsWriter = firstMetrics
+		runner := trafficgen.NewRunner(client, cfg)
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var firstRes trafficgen.Results
+		err = json.Unmarshal(firstMetrics.Bytes(), &firstRes)
+		require.NoError(t, err)
+		require.Greater(t, firstRes.BytesRcvd, uint64(0))
+
+		secondMetrics := bytes.NewBuffer(nil)
+		cfg.MetricsWriter = secondMetrics
+		runner2 := trafficgen.NewRunner(client, cfg)
+
+		err = runner2.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var secondRes trafficgen.Results
+		err = json.Unmarshal(secondMetrics.Bytes(), &secondRes)
+		require.NoError(t, err)
+		require.Greater(t, secondRes.BytesRcvd, uint64(0))
+	})
+
+	t.Run("ThroughputPercentiles", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(3 * time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+
+		// p5 <= p50 <= p95 should hold for a steady-rate connection, and all
+		// should be above zero since bytes flowed in every one-second window.
+		require.Greater(t, res.ThroughputSentP5, 0.0)
+		require.LessOrEqual(t, res.ThroughputSentP5, res.ThroughputSentP50)
+		require.LessOrEqual(t, res.ThroughputSentP50, res.ThroughputSentP95)
+
+		require.Greater(t, res.ThroughputRcvdP5, 0.0)
+		require.LessOrEqual(t, res.ThroughputRcvdP5, res.ThroughputRcvdP50)
+		require.LessOrEqual(t, res.ThroughputRcvdP50, res.ThroughputRcvdP95)
+	})
+
+	t.Run("MaxInFlight", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 4096,
+			TicksPerSecond: 100,
+			Duration:       httpapi.Duration(2 * time.Second),
+			MaxInFlight:    1,
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.BytesSent, uint64(0))
+		// A cap of 1 in-flight byte against a shell with real round-trip
+		// latency can't keep up with 100 ticks/sec, so most ticks should
+		// have been held back waiting for the previous byte to echo.
+		require.Greater(t, res.ThrottledTicks, int64(0))
+		require.Greater(t, res.ThrottledTickRatio, 0.0)
+	})
+
+	//nolint:paralleltest // This test reserves a port.
+	t.Run("HealthAddr", func(t *testing.T) {
+		client, agentID := setupRunnerTest(t)
+
+		random, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		healthAddr := random.Addr().String()
+		require.NoError(t, random.Close())
+
+		registry := prometheus.NewRegistry()
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:        []uuid.UUID{agentID},
+			BytesPerSecond:  1024,
+			TicksPerSecond:  10,
+			Duration:        httpapi.Duration(2 * time.Second),
+			HealthAddr:      healthAddr,
+			MetricsRegistry: registry,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		runDone := make(chan error, 1)
+		go func() {
+			runDone <- runner.Run(ctx, "1", io.Discard)
+		}()
+
+		// The server only starts listening once Run reaches it, and there's
+		// no signal back to the test for exactly when that happens, so poll
+		// /healthz until it responds instead of racing a fixed sleep against
+		// however long dialing and handshaking the agent takes.
+		var healthResp *http.Response
+		require.Eventually(t, func() bool {
+			resp, err := http.Get(fmt.Sprintf("http://%s/healthz", healthAddr))
+			if err != nil {
+				return false
+			}
+			healthResp = resp
+			return true
+		}, testutil.WaitLong, testutil.IntervalFast)
+
+		var status trafficgen.HealthStatus
+		err = json.NewDecoder(healthResp.Body).Decode(&status)
+		require.NoError(t, healthResp.Body.Close())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, healthResp.StatusCode)
+		require.Equal(t, "ok", status.Status)
+		require.True(t, status.Active)
+		require.Greater(t, status.Connections, 0)
+
+		metricsResp, err := http.Get(fmt.Sprintf("http://%s/metrics", healthAddr))
+		require.NoError(t, err)
+		metricsBody, err := io.ReadAll(metricsResp.Body)
+		require.NoError(t, metricsResp.Body.Close())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, metricsResp.StatusCode)
+		require.Contains(t, string(metricsBody), "coder_trafficgen_bytes_written_total")
+
+		require.NoError(t, <-runDone)
+
+		// The server is shut down before Run returns, so /healthz should no
+		// longer be reachable at all.
+		_, err = http.Get(fmt.Sprintf("http://%s/healthz", healthAddr))
+		require.Error(t, err)
+	})
+
+	t.Run("CommandMix", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:         []uuid.UUID{agentID},
+			SessionsPerAgent: 12,
+			CommandMix: []trafficgen.CommandWeight{
+				{Command: "/bin/sh", Weight: 1},
+				{Command: "cat", Weight: 1},
+			},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+
+		var total int
+		for _, cr := range res.ByCommand {
+			require.Contains(t, []string{"/bin/sh", "cat"}, cr.Command)
+			require.Greater(t, cr.Sessions, 0)
+			total += cr.Sessions
+		}
+		require.Equal(t, 12, total)
+	})
+
+	t.Run("CustomDimensions", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			Width:          200,
+			Height:         50,
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+	})
+
+	t.Run("Warmup", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			Warmup:         httpapi.Duration(200 * time.Millisecond),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+	})
+
+	t.Run("ProgressFn", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		var mu sync.Mutex
+		var calls int
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:         []uuid.UUID{agentID},
+			BytesPerSecond:   1024,
+			TicksPerSecond:   10,
+			Duration:         httpapi.Duration(time.Second),
+			ProgressInterval: httpapi.Duration(100 * time.Millisecond),
+			ProgressFn: func(sent, rcvd int64, elapsed time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Greater(t, calls, 0)
+	})
+
+	t.Run("ReconnectEnabled", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			Reconnect:      true,
+			MaxReconnects:  3,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		logStr := logs.String()
+		require.NoError(t, err)
+		require.Contains(t, logStr, "reconnects")
+	})
+
+	t.Run("TimeToFirstByte", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.TTFBSamples, 0)
+		require.Greater(t, res.TTFBP50, time.Duration(0))
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs: []uuid.UUID{agentID},
+			DryRun:   true,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.NoError(t, err)
+
+		require.Contains(t, logStr, "dry run: agent reachable")
+		require.Contains(t, logStr, "dry run complete")
+	})
+
+	t.Run("DryRunUnreachableAgent", func(t *testing.T) {
+		t.Parallel()
+
+		client, _ := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs: []uuid.UUID{uuid.New()},
+			DryRun:   true,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.Error(t, err)
+	})
+
+	t.Run("HandshakeOnly", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:      []uuid.UUID{agentID},
+			HandshakeOnly: true,
+			Connections:   3,
+			MetricsWriter: metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.NoError(t, err)
+
+		require.Contains(t, logStr, "handshake benchmark complete")
+		require.Contains(t, logStr, "connections_per_second")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Equal(t, 3, res.HandshakeConnections)
+		require.Greater(t, res.HandshakeConnectionsPerSecond, float64(0))
+		require.Equal(t, 3, res.ConnectSamples)
+	})
+
+	t.Run("HandshakeOnlyUnreachableAgent", func(t *testing.T) {
+		t.Parallel()
+
+		client, _ := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:      []uuid.UUID{uuid.New()},
+			HandshakeOnly: true,
+			Connections:   3,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.Error(t, err)
+	})
+
+	t.Run("MultipleAgents", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentIDs := setupMultiAgentRunnerTest(t, 3)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       agentIDs,
+			BytesPerSecond: 3072,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.NoError(t, err)
+
+		require.Contains(t, logStr, `"agents": 3`)
+	})
+
+	t.Run("FailFastCancelsOtherAgents", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentIDs := setupMultiAgentRunnerTest(t, 2)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       append(agentIDs, uuid.New()),
+			BytesPerSecond: 3072,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(testutil.WaitSuperLong),
+			FailFast:       true,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		start := time.Now()
+		err := runner.Run(ctx, "1", logs)
+		require.Error(t, err)
+		require.Less(t, time.Since(start), testutil.WaitSuperLong)
+	})
+
+	t.Run("RunTimeoutBoundsHungAgent", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New(), agentID},
+			BytesPerSecond: 2048,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(testutil.WaitSuperLong),
+			ConnectTimeout: httpapi.Duration(time.Second),
+			RunTimeout:     httpapi.Duration(2 * time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		start := time.Now()
+		err := runner.Run(ctx, "1", logs)
+		require.Error(t, err)
+		require.Less(t, time.Since(start), testutil.WaitSuperLong)
+	})
+
+	t.Run("BurstPattern", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			Pattern:        trafficgen.TrafficPatternBurst,
+			Burst: trafficgen.BurstConfig{
+				Size:      512,
+				IdleTicks: 4,
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+	})
+
+	t.Run("TickJitter", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			TickJitter:     0.5,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+	})
+
+	t.Run("RateDriftWarning", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 10,
+			TicksPerSecond: 3,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		logStr := logs.String()
+		require.Contains(t, logStr, "per-agent send rate after tick quantization")
+		require.Contains(t, logStr, "truncation will under-run the target rate")
+	})
+
+	t.Run("DistributeRemainder", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:            []uuid.UUID{agentID},
+			BytesPerSecond:      10,
+			TicksPerSecond:      3,
+			Duration:            httpapi.Duration(time.Second),
+			DistributeRemainder: true,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		logStr := logs.String()
+		require.Contains(t, logStr, "per-agent send rate after tick quantization")
+		require.NotContains(t, logStr, "truncation will under-run the target rate")
+	})
+
+	t.Run("WorkspaceNameResolution", func(t *testing.T) {
+		t.Parallel()
+
+		client, workspace, agentName := setupRunnerTestWithWorkspace(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			WorkspaceName:  workspace.Name,
+			AgentName:      agentName,
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+	})
+
+	t.Run("WorkspaceNameResolutionNoSuchAgent", func(t *testing.T) {
+		t.Parallel()
+
+		client, workspace, _ := setupRunnerTestWithWorkspace(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			WorkspaceName:  workspace.Name,
+			AgentName:      "does-not-exist",
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.ErrorContains(t, err, "has no agent named")
+	})
+
+	t.Run("ForcedReconnects", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		var gotResult trafficgen.Results
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:          []uuid.UUID{agentID},
+			BytesPerSecond:    1024,
+			TicksPerSecond:    10,
+			Duration:          httpapi.Duration(2 * time.Second),
+			ReconnectInterval: httpapi.Duration(300 * time.Millisecond),
+			ResultValidator: func(res trafficgen.Results) error {
+				gotResult = res
+				return nil
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+		require.NotZero(t, gotResult.ForcedReconnects)
+		require.Zero(t, gotResult.Reconnects)
+		require.NotZero(t, gotResult.BytesSent)
+		// One TTFB sample for the initial connection, plus one more for
+		// every forced reconnect that got far enough to read a byte back.
+		require.Greater(t, gotResult.TTFBSamples, 1)
+	})
+
+	t.Run("MessageFramingStats", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		var gotResult trafficgen.Results
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ResultValidator: func(res trafficgen.Results) error {
+				gotResult = res
+				return nil
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		require.NotZero(t, gotResult.MessagesSent)
+		require.NotZero(t, gotResult.FramesRcvd)
+		require.NotZero(t, gotResult.MessagesPerSecond)
+		require.NotZero(t, gotResult.FramesPerSecond)
+	})
+
+	t.Run("ResultValidator", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		wantErr := xerrors.New("too slow")
+		var gotResult trafficgen.Results
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ResultValidator: func(res trafficgen.Results) error {
+				gotResult = res
+				return wantErr
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.ErrorIs(t, err, wantErr)
+		require.NotZero(t, gotResult.BytesSent)
+	})
+
+	t.Run("RateLimiter", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			UseRateLimiter: true,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.NoError(t, err)
+
+		require.Contains(t, logStr, "results")
+	})
+
+	t.Run("CustomReadBufferSize", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ReadBufferSize: 64,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+	})
+
+	t.Run("PartialResultsOnError", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			MetricsWriter:  erroringWriter{},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.Error(t, err)
+
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.Contains(t, logStr, "results")
+		require.Contains(t, logStr, "write_bytes")
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		t.Parallel()
+
+		client, _ := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.Error(t, err)
+		require.ErrorContains(t, err, "agent_ids must contain at least one agent")
+	})
+
+	t.Run("MetricsWriter", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Equal(t, 1, res.SchemaVersion)
+	})
+
+	t.Run("ThroughputSamples", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:                 []uuid.UUID{agentID},
+			BytesPerSecond:           1024,
+			TicksPerSecond:           10,
+			Duration:                 httpapi.Duration(time.Second),
+			ThroughputSampleInterval: httpapi.Duration(250 * time.Millisecond),
+			MetricsWriter:            metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.NotEmpty(t, res.ThroughputSamples)
+
+		var sampledSent, sampledRcvd uint64
+		for _, s := range res.ThroughputSamples {
+			sampledSent += uint64(s.BytesWritten)
+			sampledRcvd += uint64(s.BytesRead)
+		}
+		require.Equal(t, res.BytesSent, sampledSent)
+		require.Equal(t, res.BytesRcvd, sampledRcvd)
+	})
+
+	t.Run("ConnectMetrics", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			Reconnect:      true,
+			MaxReconnects:  3,
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), "connect_p50")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, res.ConnectSamples, 1)
+		require.GreaterOrEqual(t, res.ConnectMax, res.ConnectP50)
+	})
+
+	t.Run("VerifyEcho", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			VerifyEcho:     true,
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), "corrupted_bytes")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Zero(t, res.CorruptedBytes)
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(10 * time.Second),
+			MaxBytes:       1024,
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), "max_bytes_elapsed")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, res.BytesSent, uint64(1024))
+		require.NotZero(t, res.MaxBytesElapsed)
+		require.Less(t, res.MaxBytesElapsed, 10*time.Second)
+		require.Equal(t, trafficgen.ReasonMaxBytes, res.Reason)
+	})
+
+	t.Run("Reason", func(t *testing.T) {
+		t.Parallel()
+
+		t.Run("DefaultsToDeadline", func(t *testing.T) {
+			t.Parallel()
+
+			client, agentID := setupRunnerTest(t)
+
+			metrics := bytes.NewBuffer(nil)
+			runner := trafficgen.NewRunner(client, trafficgen.Config{
+				AgentIDs:       []uuid.UUID{agentID},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Second),
+				MetricsWriter:  metrics,
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+			defer cancel()
+
+			logs := bytes.NewBuffer(nil)
+			err := runner.Run(ctx, "1", logs)
+			require.NoError(t, err)
+			require.Contains(t, logs.String(), `"reason": "deadline"`)
+
+			var res trafficgen.Results
+			err = json.Unmarshal(metrics.Bytes(), &res)
+			require.NoError(t, err)
+			require.Equal(t, trafficgen.ReasonDeadline, res.Reason)
+		})
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(10 * time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		runCtx, runCancel := context.WithCancel(ctx)
+		time.AfterFunc(200*time.Millisecond, runCancel)
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(runCtx, "1", logs)
+		require.ErrorIs(t, err, trafficgen.ErrInterrupted)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.NotZero(t, res.BytesSent)
+	})
+
+	t.Run("SessionsPerAgent", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		csvBuf := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:         []uuid.UUID{agentID},
+			SessionsPerAgent: 3,
+			BytesPerSecond:   1024,
+			TicksPerSecond:   10,
+			Duration:         httpapi.Duration(time.Second),
+			MetricsWriter:    metrics,
+			CSVWriter:        csvBuf,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.NotZero(t, res.BytesSent)
+
+		r := csv.NewReader(csvBuf)
+		records, err := r.ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		for _, record := range records {
+			require.Equal(t, agentID.String(), record[0])
+		}
+	})
+
+	t.Run("CSVWriter", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		csvBuf := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			CSVWriter:      csvBuf,
+			CSVHeader:      true,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		r := csv.NewReader(csvBuf)
+		records, err := r.ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		require.Equal(t, []string{"agent_id", "duration", "bytes_sent", "bytes_rcvd", "throughput", "error", "labels"}, records[0])
+		require.Equal(t, agentID.String(), records[1][0])
+	})
+
+	t.Run("ReadThroughputAchievedRatio", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:           []uuid.UUID{agentID},
+			BytesPerSecond:     1024,
+			TicksPerSecond:     10,
+			Duration:           httpapi.Duration(time.Second),
+			ReadBytesPerSecond: 1024,
+			MetricsWriter:      metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), "read_throughput_achieved_ratio")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.NotZero(t, res.ReadThroughputAchievedRatio)
+	})
+
+	t.Run("LatencySLO", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:         []uuid.UUID{agentID},
+			BytesPerSecond:   1024,
+			TicksPerSecond:   10,
+			Duration:         httpapi.Duration(time.Second),
+			LatencyThreshold: httpapi.Duration(time.Nanosecond),
+			MetricsWriter:    metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.ErrorIs(t, err, trafficgen.ErrLatencySLOExceeded)
+		require.Contains(t, logs.String(), "latency SLO budget exceeded")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Equal(t, res.LatencySamples, res.LatencyViolations)
+		require.Equal(t, float64(1), res.LatencyViolationRatio)
+	})
+
+	t.Run("FailOnZeroBytes", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs: []uuid.UUID{agentID},
+			// Rounds down to 0 bytes per tick, so nothing is ever written
+			// (and so nothing is ever echoed back), simulating a silently
+			// broken run.
+			BytesPerSecond:  1,
+			TicksPerSecond:  100,
+			Duration:        httpapi.Duration(200 * time.Millisecond),
+			FailOnZeroBytes: true,
+			MetricsWriter:   metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.ErrorIs(t, err, trafficgen.ErrZeroBytesTransferred)
+		require.Contains(t, logs.String(), "run transferred zero bytes")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Zero(t, res.BytesSent)
+	})
+
+	t.Run("EchoCapture", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		echoCapture := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:            []uuid.UUID{agentID},
+			BytesPerSecond:      1024,
+			TicksPerSecond:      10,
+			Duration:            httpapi.Duration(time.Second),
+			EchoCapture:         echoCapture,
+			EchoCaptureMaxBytes: 16,
+			MetricsWriter:       metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		require.LessOrEqual(t, echoCapture.Len(), 16)
+		require.NotZero(t, echoCapture.Len())
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.True(t, res.EchoCaptureTruncated)
+	})
+
+	t.Run("GracefulShutdown", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		echoCapture := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:              []uuid.UUID{agentID},
+			BytesPerSecond:        8,
+			TicksPerSecond:        1,
+			Duration:              httpapi.Duration(300 * time.Millisecond),
+			GracefulShutdown:      true,
+			GracefulShutdownGrace: httpapi.Duration(500 * time.Millisecond),
+			EchoCapture:           echoCapture,
+			EchoCaptureMaxBytes:   4096,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		require.Contains(t, echoCapture.String(), "exit")
+	})
+
+	t.Run("ThinkTime", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			Pattern:        trafficgen.TrafficPatternThinkTime,
+			ThinkTime: trafficgen.ThinkTimeConfig{
+				Distribution: trafficgen.ThinkTimeConstant,
+				Mean:         httpapi.Duration(200 * time.Millisecond),
+			},
+			MetricsWriter: metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.BytesSent, uint64(0))
+	})
+
+	t.Run("SizeDistribution", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:         []uuid.UUID{agentID},
+			BytesPerSecond:   1024,
+			TicksPerSecond:   10,
+			Duration:         httpapi.Duration(time.Second),
+			SizeDistribution: trafficgen.SizeDistributionNormal,
+			SizeSpread:       0.3,
+			MetricsWriter:    metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.BytesSent, uint64(0))
+	})
+
+	t.Run("ScriptPath", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		scriptPath := filepath.Join(t.TempDir(), "script.txt")
+		require.NoError(t, os.WriteFile(scriptPath, []byte("echo one\necho two\n"), 0o600))
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ScriptPath:     scriptPath,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+	})
+
+	t.Run("ScriptPathMissingFile", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ScriptPath:     filepath.Join(t.TempDir(), "does-not-exist.txt"),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.ErrorContains(t, err, "load script")
+	})
+
+	t.Run("ReplayFile", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		replayPath := filepath.Join(t.TempDir(), "replay.jsonl")
+		replay := `{"delay_ms": 0, "data": "echo one\n"}
+{"delay_ms": 50, "data": "echo two\n"}
+`
+		require.NoError(t, os.WriteFile(replayPath, []byte(replay), 0o600))
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ReplayFile:     replayPath,
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Greater(t, res.BytesSent, uint64(0))
+	})
+
+	t.Run("ReplayFileMissingFile", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ReplayFile:     filepath.Join(t.TempDir(), "does-not-exist.jsonl"),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.ErrorContains(t, err, "load replay")
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(2 * time.Second),
+		})
+
+		require.Zero(t, runner.Stats())
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- runner.Run(ctx, "1", io.Discard)
+		}()
+
+		require.Eventually(t, func() bool {
+			stats := runner.Stats()
+			return stats.BytesSent > 0 && stats.BytesRcvd > 0
+		}, testutil.WaitLong, testutil.IntervalFast)
+
+		require.NoError(t, <-done)
+
+		require.Zero(t, runner.Stats())
+	})
+
+	t.Run("ConnectRetries", func(t *testing.T) {
+		t.Parallel()
+
+		client, _ := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:                 []uuid.UUID{uuid.New()},
+			BytesPerSecond:           1024,
+			TicksPerSecond:           10,
+			Duration:                 httpapi.Duration(time.Second),
+			ConnectTimeout:           httpapi.Duration(100 * time.Millisecond),
+			ConnectRetries:           2,
+			ConnectRetryBackoffFloor: httpapi.Duration(time.Millisecond),
+			ConnectRetryBackoffCeil:  httpapi.Duration(time.Millisecond),
+			MetricsWriter:            metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.Error(t, err)
+		require.Contains(t, logs.String(), "initial connection failed, retrying")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Equal(t, 3, res.ConnectAttempts)
+	})
+
+	t.Run("RunErrorPhase", func(t *testing.T) {
+		t.Parallel()
+
+		client, _ := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ConnectTimeout: httpapi.Duration(100 * time.Millisecond),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.Error(t, err)
+
+		var runErr *trafficgen.RunError
+		require.True(t, xerrors.As(err, &runErr))
+		require.Equal(t, trafficgen.RunPhaseConnect, runErr.Phase)
+	})
+
+	t.Run("RunBatchFailFast", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		good := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(testutil.WaitSuperLong),
+		})
+		bad := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{uuid.New()},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			ConnectTimeout: httpapi.Duration(100 * time.Millisecond),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		start := time.Now()
+		errs := trafficgen.RunBatch(
+			ctx,
+			[]*trafficgen.Runner{good, bad},
+			[]string{"1", "2"},
+			[]io.Writer{io.Discard, io.Discard},
+			true,
+		)
+		elapsed := time.Since(start)
+
+		require.Error(t, errs[1])
+		var runErr *trafficgen.RunError
+		require.True(t, xerrors.As(errs[1], &runErr))
+		require.Equal(t, trafficgen.RunPhaseConnect, runErr.Phase)
+
+		require.ErrorIs(t, errs[0], trafficgen.ErrInterrupted)
+		require.Less(t, elapsed, testutil.WaitSuperLong)
+	})
+
+	t.Run("LineLength", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		echoCapture := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:            []uuid.UUID{agentID},
+			BytesPerSecond:      160,
+			TicksPerSecond:      1,
+			Duration:            httpapi.Duration(500 * time.Millisecond),
+			LineLength:          20,
+			EchoCapture:         echoCapture,
+			EchoCaptureMaxBytes: 4096,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		for _, line := range strings.Split(strings.TrimRight(echoCapture.String(), "\n"), "\n") {
+			require.LessOrEqual(t, len(line), 20)
+		}
+	})
+
+	t.Run("SelfProfile", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			SelfProfile:    true,
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.NotNil(t, res.SelfProfile)
+		require.Greater(t, res.SelfProfile.BytesAllocated, uint64(0))
+		require.Greater(t, res.SelfProfile.Mallocs, uint64(0))
+	})
+
+	t.Run("WireBytesSent", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.WireBytesSent, res.BytesSent)
+		require.Greater(t, res.FramingOverheadRatio, 0.0)
+		require.Greater(t, res.WireThroughputSent, res.ThroughputSent)
+	})
+
+	t.Run("KeystrokeDelay", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 100,
+			TicksPerSecond: 2,
+			Duration:       httpapi.Duration(time.Second),
+			KeystrokeDelay: httpapi.Duration(time.Millisecond),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.BytesSent, uint64(0))
+		require.Greater(t, res.BytesRcvd, uint64(0))
+	})
+
+	t.Run("LeakCheck", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		logs := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			LeakCheck:      true,
+			LeakCheckGrace: httpapi.Duration(testutil.WaitShort),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.NotContains(t, logStr, "leak check")
+	})
+
+	t.Run("PayloadEntropy", func(t *testing.T) {
+		t.Parallel()
+
+		for _, entropy := range []trafficgen.PayloadEntropy{trafficgen.PayloadEntropyRepeated, trafficgen.PayloadEntropyText} {
+			entropy := entropy
+
+			t.Run(string(entropy), func(t *testing.T) {
+				t.Parallel()
+
+				client, agentID := setupRunnerTest(t)
+
+				metrics := bytes.NewBuffer(nil)
+				runner := trafficgen.NewRunner(client, trafficgen.Config{
+					AgentIDs:       []uuid.UUID{agentID},
+					BytesPerSecond: 1024,
+					TicksPerSecond: 10,
+					Duration:       httpapi.Duration(time.Second),
+					PayloadEntropy: entropy,
+					MetricsWriter:  metrics,
+				})
+
+				ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+				defer cancel()
+
+				logs := bytes.NewBuffer(nil)
+				err := runner.Run(ctx, "1", logs)
+				require.NoError(t, err)
+
+				var res trafficgen.Results
+				err = json.Unmarshal(metrics.Bytes(), &res)
+				require.NoError(t, err)
+				require.NotZero(t, res.BytesSent)
+				require.NotZero(t, res.BytesRcvd)
+			})
+		}
+	})
+
+	t.Run("WriteBlockedRatio", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), "write_blocked_ratio")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, res.WriteBlockedRatio, float64(0))
+		require.Less(t, res.WriteBlockedRatio, float64(1))
+	})
+
+	t.Run("OverrunTicks", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 2000,
+			TicksPerSecond: 1000,
+			Duration:       httpapi.Duration(200 * time.Millisecond),
+			KeystrokeDelay: httpapi.Duration(5 * time.Millisecond),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), "overrun_tick_ratio")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.Greater(t, res.OverrunTicks, int64(0))
+		require.Greater(t, res.OverrunTickRatio, float64(0))
+	})
+
+	t.Run("GlobalLimiter", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		limiter := rate.NewLimiter(rate.Limit(100), 100)
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 100000,
+			TicksPerSecond: 100,
+			Duration:       httpapi.Duration(300 * time.Millisecond),
+			GlobalLimiter:  limiter,
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		// Without GlobalLimiter this would send roughly
+		// 100000 * 0.3 = 30000 bytes; capped at 100 bytes/sec it should
+		// send only a small fraction of that.
+		require.Less(t, res.BytesSent, uint64(1000))
+	})
+
+	t.Run("LogLevel", func(t *testing.T) {
+		t.Parallel()
+
+		t.Run("DefaultsToDebug", func(t *testing.T) {
+			t.Parallel()
+
+			client, agentID := setupRunnerTest(t)
+
+			runner := trafficgen.NewRunner(client, trafficgen.Config{
+				AgentIDs:       []uuid.UUID{agentID},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Second),
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+			defer cancel()
+
+			logs := bytes.NewBuffer(nil)
+			err := runner.Run(ctx, "1", logs)
+			require.NoError(t, err)
+			require.Contains(t, logs.String(), "results")
+		})
+
+		t.Run("SuppressesDebugAtInfo", func(t *testing.T) {
+			t.Parallel()
+
+			client, agentID := setupRunnerTest(t)
+
+			runner := trafficgen.NewRunner(client, trafficgen.Config{
+				AgentIDs:       []uuid.UUID{agentID},
+				BytesPerSecond: 1024,
+				TicksPerSecond: 10,
+				Duration:       httpapi.Duration(time.Second),
+				LogLevel:       slog.LevelInfo,
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+			defer cancel()
+
+			logs := bytes.NewBuffer(nil)
+			err := runner.Run(ctx, "1", logs)
+			require.NoError(t, err)
+			require.NotContains(t, logs.String(), "[DEBUG]")
+			require.Contains(t, logs.String(), "results")
+		})
+	})
+
+	t.Run("ChunkSizeHistogram", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), "chunk_size_buckets")
+
+		var res trafficgen.Results
+		err = json.Unmarshal(metrics.Bytes(), &res)
+		require.NoError(t, err)
+		require.NotEmpty(t, res.ChunkSizeBuckets)
+
+		var total int64
+		for _, b := range res.ChunkSizeBuckets {
+			total += b.Count
+		}
+		require.EqualValues(t, res.ChunkSizeBuckets[len(res.ChunkSizeBuckets)-1].UpperBound, -1)
+		require.Greater(t, total, int64(0))
+	})
+
+	t.Run("PrometheusMetrics", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		reg := prometheus.NewRegistry()
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:        []uuid.UUID{agentID},
+			BytesPerSecond:  1024,
+			TicksPerSecond:  10,
+			Duration:        httpapi.Duration(time.Second),
+			MetricsRegistry: reg,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		require.NoError(t, err)
+
+		metricFamilies, err := reg.Gather()
+		require.NoError(t, err)
+		require.NotEmpty(t, metricFamilies)
+
+		var found bool
+		for _, mf := range metricFamilies {
+			if mf.GetName() == "coder_trafficgen_bytes_written_total" {
+				found = true
+			}
+		}
+		require.True(t, found, "expected coder_trafficgen_bytes_written_total to be registered")
+	})
+
+	t.Run("CleanupAfterNormalRunIsNoop", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		err = runner.Cleanup(ctx, "1")
+		require.NoError(t, err)
+	})
+
+	t.Run("CleanupClosesLingeringConnection", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(testutil.WaitSuperLong),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- runner.Run(ctx, "1", io.Discard)
+		}()
+
+		// Give the agent time to dial and register its connection before
+		// we yank it out from under the in-flight Run.
+		time.Sleep(200 * time.Millisecond)
+
+		err := runner.Cleanup(ctx, "1")
+		require.NoError(t, err)
+
+		select {
+		case err := <-done:
+			require.Error(t, err)
+		case <-time.After(testutil.WaitShort):
+			t.Fatal("Run did not return after Cleanup closed its connection")
+		}
+	})
+
+	t.Run("SSH", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			ConnectionType: trafficgen.ConnectionTypeSSH,
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		logs := bytes.NewBuffer(nil)
+		err := runner.Run(ctx, "1", logs)
+		logStr := logs.String()
+		t.Log("Runner logs:\n\n" + logStr)
+		require.NoError(t, err)
+
+		require.Contains(t, logStr, "results")
+	})
+
+	t.Run("SSHTransportRTT", func(t *testing.T) {
+		t.Parallel()
+
+		client, agentID := setupRunnerTest(t)
+
+		metrics := bytes.NewBuffer(nil)
+		runner := trafficgen.NewRunner(client, trafficgen.Config{
+			AgentIDs:       []uuid.UUID{agentID},
+			ConnectionType: trafficgen.ConnectionTypeSSH,
+			BytesPerSecond: 1024,
+			TicksPerSecond: 10,
+			Duration:       httpapi.Duration(time.Second),
+			TransportRTT:   true,
+			MetricsWriter:  metrics,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitSuperLong)
+		defer cancel()
+
+		err := runner.Run(ctx, "1", io.Discard)
+		require.NoError(t, err)
+
+		var res trafficgen.Results
+		require.NoError(t, json.Unmarshal(metrics.Bytes(), &res))
+		require.Greater(t, res.TransportRTTSamples, 0)
+		require.GreaterOrEqual(t, res.TransportRTTP50, time.Duration(0))
+		require.GreaterOrEqual(t, res.TransportRTTMax, res.TransportRTTP50)
+	})
+}
+
+func setupRunnerTest(t *testing.T) (client *codersdk.Client, agentID uuid.UUID) {
+	t.Helper()
+
+	client, agentID, _ = setupRunnerTestWithAgentCloser(t)
+	return client, agentID
+}
+
+// setupRunnerTestWithWorkspace is like setupRunnerTest, but also returns the
+// workspace and agent name so the caller can exercise Config.WorkspaceName /
+// Config.AgentName resolution.
+func setupRunnerTestWithWorkspace(t *testing.T) (client *codersdk.Client, workspace codersdk.Workspace, agentName string) {
+	t.Helper()
+
+	client = coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerDaemon: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+
+	authToken := uuid.NewString()
+	agentName = "agent"
+	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, &echo.Responses{
+		Parse:         echo.ParseComplete,
+		ProvisionPlan: echo.ProvisionComplete,
+		ProvisionApply: []*proto.Provision_Response{{
+			Type: &proto.Provision_Response_Complete{
+				Complete: &proto.Provision_Complete{
+					Resources: []*proto.Resource{{
+						Name: "example",
+						Type: "aws_instance",
+						Agents: []*proto.Agent{{
+							Id:   uuid.NewString(),
+							Name: agentName,
+							Auth: &proto.Agent_Token{
+								Token: authToken,
+							},
+							Apps: []*proto.App{},
+						}},
+					}},
+				},
+			},
+		}},
+	})
+
+	template := coderdtest.CreateTemplate(t, client, user.OrganizationID, version.ID)
+	coderdtest.AwaitTemplateVersionJob(t, client, version.ID)
+
+	workspace = coderdtest.CreateWorkspace(t, client, user.OrganizationID, template.ID)
+	coderdtest.AwaitWorkspaceBuildJob(t, client, workspace.LatestBuild.ID)
+
+	agentClient := agentsdk.New(client.URL)
+	agentClient.SetSessionToken(authToken)
+	agentCloser := agent.New(agent.Options{
+		Client: agentClient,
+		Logger: slogtest.Make(t, &slogtest.Options{IgnoreErrors: true}).Named("agent"),
+	})
+	t.Cleanup(func() {
+		_ = agentCloser.Close()
+	})
+
+	coderdtest.AwaitWorkspaceAgents(t, client, workspace.ID)
+	return client, workspace, agentName
+}
+
+func setupRunnerTestWithAgentCloser(t *testing.T) (client *codersdk.Client, agentID uuid.UUID, agentCloser io.Closer) {
+	t.Helper()
+
+	client = coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerDaemon: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+
+	authToken := uuid.NewString()
+	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, &echo.Responses{
+		Parse:         echo.ParseComplete,
+		ProvisionPlan: echo.ProvisionComplete,
+		ProvisionApply: []*proto.Provision_Response{{
+			Type: &proto.Provision_Response_Complete{
+				Complete: &proto.Provision_Complete{
+					Resources: []*proto.Resource{{
+						Name: "example",
+						Type: "aws_instance",
+						Agents: []*proto.Agent{{
+							Id:   uuid.NewString(),
+							Name: "agent",
+							Auth: &proto.Agent_Token{
+								Token: authToken,
+							},
+							Apps: []*proto.App{},
+						}},
+					}},
+				},
+			},
+		}},
+	})
+
+	template := coderdtest.CreateTemplate(t, client, user.OrganizationID, version.ID)
+	coderdtest.AwaitTemplateVersionJob(t, client, version.ID)
+
+	workspace := coderdtest.CreateWorkspace(t, client, user.OrganizationID, template.ID)
+	coderdtest.AwaitWorkspaceBuildJob(t, client, workspace.LatestBuild.ID)
+
+	agentClient := agentsdk.New(client.URL)
+	agentClient.SetSessionToken(authToken)
+	agentCloser = agent.New(agent.Options{
+		Client: agentClient,
+		Logger: slogtest.Make(t, &slogtest.Options{IgnoreErrors: true}).Named("agent"),
+	})
+	t.Cleanup(func() {
+		_ = agentCloser.Close()
+	})
+
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.ID)
+	return client, resources[0].Agents[0].ID, agentCloser
+}
+
+// setupMultiAgentRunnerTest is like setupRunnerTest, but brings up n agents
+// within a single workspace resource.
+func setupMultiAgentRunnerTest(t *testing.T, n int) (client *codersdk.Client, agentIDs []uuid.UUID) {
+	t.Helper()
+
+	client = coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerDaemon: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+
+	authTokens := make([]string, n)
+	agents := make([]*proto.Agent, n)
+	for i := range agents {
+		authTokens[i] = uuid.NewString()
+		agents[i] = &proto.Agent{
+			Id:   uuid.NewString(),
+			Name: fmt.Sprintf("agent%d", i),
+			Auth: &proto.Agent_Token{
+				Token: authTokens[i],
+			},
+			Apps: []*proto.App{},
+		}
+	}
+
+	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, &echo.Responses{
+		Parse:         echo.ParseComplete,
+		ProvisionPlan: echo.ProvisionComplete,
+		ProvisionApply: []*proto.Provision_Response{{
+			Type: &proto.Provision_Response_Complete{
+				Complete: &proto.Provision_Complete{
+					Resources: []*proto.Resource{{
+						Name:   "example",
+						Type:   "aws_instance",
+						Agents: agents,
+					}},
+				},
+			},
+		}},
+	})
+
+	template := coderdtest.CreateTemplate(t, client, user.OrganizationID, version.ID)
+	coderdtest.AwaitTemplateVersionJob(t, client, version.ID)
+
+	workspace := coderdtest.CreateWorkspace(t, client, user.OrganizationID, template.ID)
+	coderdtest.AwaitWorkspaceBuildJob(t, client, workspace.LatestBuild.ID)
+
+	for _, authToken := range authTokens {
+		agentClient := agentsdk.New(client.URL)
+		agentClient.SetSessionToken(authToken)
+		agentCloser := agent.New(agent.Options{
+			Client: agentClient,
+			Logger: slogtest.Make(t, &slogtest.Options{IgnoreErrors: true}).Named("agent"),
+		})
+		t.Cleanup(func() {
+			_ = agentCloser.Close()
+		})
+	}
+
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.ID)
+	agentIDs = make([]uuid.UUID, len(resources[0].Agents))
+	for i, a := range resources[0].Agents {
+		agentIDs[i] = a.ID
+	}
+	return client, agentIDs
+}
+
+// erroringWriter is an io.Writer that always fails, used to exercise the
+// MetricsWriter error path without needing a real broken pipe.
+type erroringWriter struct{}
+
+func (erroringWriter) Write([]byte) (int, error) {
+	return 0, xerrors.New("erroringWriter: write failed")
+}
@@ -0,0 +1,50 @@
+package trafficgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_throughputPercentiles(t *testing.T) {
+	t.Parallel()
+
+	bytesWritten := func(s ThroughputSample) int64 { return s.BytesWritten }
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+
+		p5, p50, p95 := throughputPercentiles(nil, time.Second, bytesWritten)
+		require.Zero(t, p5)
+		require.Zero(t, p50)
+		require.Zero(t, p95)
+	})
+
+	t.Run("NonPositiveWindowWidth", func(t *testing.T) {
+		t.Parallel()
+
+		samples := []ThroughputSample{{BytesWritten: 1024}}
+		p5, p50, p95 := throughputPercentiles(samples, 0, bytesWritten)
+		require.Zero(t, p5)
+		require.Zero(t, p50)
+		require.Zero(t, p95)
+	})
+
+	t.Run("SurfacesSlowWindows", func(t *testing.T) {
+		t.Parallel()
+
+		// 19 windows at 1000 B/s, one stalled window at 0 B/s: the p5 should
+		// land on the stall rather than being averaged away.
+		samples := make([]ThroughputSample, 0, 20)
+		for i := 0; i < 19; i++ {
+			samples = append(samples, ThroughputSample{BytesWritten: 1000})
+		}
+		samples = append(samples, ThroughputSample{BytesWritten: 0})
+
+		p5, p50, p95 := throughputPercentiles(samples, time.Second, bytesWritten)
+		require.Zero(t, p5)
+		require.Equal(t, 1000.0, p50)
+		require.Equal(t, 1000.0, p95)
+	})
+}
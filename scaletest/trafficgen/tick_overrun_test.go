@@ -0,0 +1,77 @@
+package trafficgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_tickedWriteLoop_overrunTicks(t *testing.T) {
+	t.Parallel()
+
+	t.Run("SlowWritesCountAsOverruns", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := Config{
+			BytesPerSecond: 100,
+			TicksPerSecond: 100,
+		}
+		rw := &fakeReadWriter{writeDelay: 15 * time.Millisecond}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, overrunTicks, totalTicks, _, err := tickedWriteLoop(ctx, rw, cfg, time.Now(), mustPayloadGenerator(), nil, "")
+		require.NoError(t, err)
+		require.Greater(t, totalTicks, int64(0))
+		require.Equal(t, totalTicks, overrunTicks)
+	})
+
+	t.Run("FastWritesDoNotOverrun", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := Config{
+			BytesPerSecond: 100,
+			TicksPerSecond: 100,
+		}
+		rw := &fakeReadWriter{}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, overrunTicks, totalTicks, _, err := tickedWriteLoop(ctx, rw, cfg, time.Now(), mustPayloadGenerator(), nil, "")
+		require.NoError(t, err)
+		require.Greater(t, totalTicks, int64(0))
+		require.Zero(t, overrunTicks)
+	})
+
+	t.Run("StalledWriteIsAbandonedNotBlockedOnForever", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := Config{
+			BytesPerSecond: 100,
+			TicksPerSecond: 100,
+		}
+		// writeDelay is far longer than the test's own context timeout, so
+		// without a real per-write deadline this write would never return
+		// and tickedWriteLoop would hang well past when the test itself
+		// fails, instead of coming back promptly with every tick counted
+		// as an overrun.
+		rw := &fakeReadWriter{writeDelay: time.Hour}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, overrunTicks, totalTicks, _, err := tickedWriteLoop(ctx, rw, cfg, time.Now(), mustPayloadGenerator(), nil, "")
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), time.Second)
+		require.Greater(t, totalTicks, int64(0))
+		require.Equal(t, totalTicks, overrunTicks)
+	})
+}
+
+// mustPayloadGenerator returns a payload generator suitable for tests that
+// don't care about the payload's contents, only that writes succeed.
+func mustPayloadGenerator() func(dst []byte, n int64) ([]byte, error) {
+	return newPayloadGenerator(1, DefaultPayloadPrefix, PayloadEntropyRandom, nil)
+}
@@ -0,0 +1,108 @@
+package trafficgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_countReadWriter_verifyEcho(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, false)
+		crw.Write([]byte("hello"))
+
+		crw.mu.Lock()
+		crw.verifyEchoLocked([]byte("world"))
+		crw.mu.Unlock()
+
+		require.Zero(t, crw.corruptedBytes())
+		require.Zero(t, crw.missingBytes())
+	})
+
+	t.Run("EchoedCleanly", func(t *testing.T) {
+		t.Parallel()
+
+		crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, true)
+		crw.Write([]byte("hello"))
+
+		crw.mu.Lock()
+		crw.verifyEchoLocked([]byte("hello"))
+		crw.mu.Unlock()
+
+		require.Zero(t, crw.corruptedBytes())
+		require.Zero(t, crw.missingBytes())
+	})
+
+	t.Run("CorruptedBytesCounted", func(t *testing.T) {
+		t.Parallel()
+
+		crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, true)
+		crw.Write([]byte("hello"))
+
+		crw.mu.Lock()
+		crw.verifyEchoLocked([]byte("hXllo"))
+		crw.mu.Unlock()
+
+		require.EqualValues(t, 1, crw.corruptedBytes())
+		require.Zero(t, crw.missingBytes())
+	})
+
+	t.Run("UnechoedBytesAreMissing", func(t *testing.T) {
+		t.Parallel()
+
+		crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, true)
+		crw.Write([]byte("hello"))
+
+		crw.mu.Lock()
+		crw.verifyEchoLocked([]byte("he"))
+		crw.mu.Unlock()
+
+		require.Zero(t, crw.corruptedBytes())
+		require.EqualValues(t, 3, crw.missingBytes())
+	})
+
+	t.Run("MatchesAcrossMultipleReads", func(t *testing.T) {
+		t.Parallel()
+
+		crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, true)
+		crw.Write([]byte("hello"))
+		crw.Write([]byte("world"))
+
+		crw.mu.Lock()
+		crw.verifyEchoLocked([]byte("hel"))
+		crw.verifyEchoLocked([]byte("loworld"))
+		crw.mu.Unlock()
+
+		require.Zero(t, crw.corruptedBytes())
+		require.Zero(t, crw.missingBytes())
+	})
+
+	t.Run("PTYLineEndingTranslationIsNotCorruption", func(t *testing.T) {
+		t.Parallel()
+
+		crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, true)
+		crw.Write([]byte("#hello\n"))
+
+		crw.mu.Lock()
+		// A PTY echoes the trailing '\n' as "\r\n", then redisplays its
+		// prompt; neither should count as corruption.
+		crw.verifyEchoLocked([]byte("#hello\r\n# "))
+		crw.mu.Unlock()
+
+		require.Zero(t, crw.corruptedBytes())
+		require.Zero(t, crw.missingBytes())
+	})
+}
+
+func Test_stripEchoedCRLF(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, []byte("ab\ncd"), stripEchoedCRLF([]byte("ab\r\ncd")))
+	require.Equal(t, []byte("abcd"), stripEchoedCRLF([]byte("abcd")))
+	require.Equal(t, []byte("ab\r"), stripEchoedCRLF([]byte("ab\r")))
+}
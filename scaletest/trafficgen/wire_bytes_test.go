@@ -0,0 +1,99 @@
+package trafficgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// discardReadWriter is an io.ReadWriter that discards every write and never
+// has anything to read, standing in for a real net.Conn in benchmarks and
+// tests that only care about what reconnectingPTYWriter sends.
+type discardReadWriter struct{}
+
+func (discardReadWriter) Read(p []byte) (int, error) { return 0, nil }
+
+func (discardReadWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func Test_reconnectingPTYWriter_Write(t *testing.T) {
+	t.Parallel()
+
+	w := newReconnectingPTYWriter(discardReadWriter{})
+
+	n, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	first := w.wireBytes
+	require.Greater(t, first, n)
+
+	// A second Write reuses the same buffer; it should frame independently
+	// of whatever the first Write left behind.
+	n, err = w.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Greater(t, w.wireBytes, n)
+	require.NotEqual(t, first, w.wireBytes)
+}
+
+func Benchmark_reconnectingPTYWriter_Write(b *testing.B) {
+	w := newReconnectingPTYWriter(discardReadWriter{})
+	payload := []byte("the quick brown fox jumps over the lazy dog")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := w.Write(payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// framedFakeWriter implements wireByteWriter, reporting a fixed overhead on
+// top of whatever payload length it's given, standing in for
+// reconnectingPTYWriter's JSON framing without needing to construct one.
+type framedFakeWriter struct {
+	overhead int
+	lastLen  int
+}
+
+func (w *framedFakeWriter) Read(p []byte) (int, error) { return 0, nil }
+
+func (w *framedFakeWriter) Write(p []byte) (int, error) {
+	w.lastLen = len(p) + w.overhead
+	return len(p), nil
+}
+
+func (w *framedFakeWriter) wireBytesWritten() int {
+	return w.lastLen
+}
+
+func Test_countReadWriter_wireWriteBytes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("TracksFramingOverhead", func(t *testing.T) {
+		t.Parallel()
+
+		crw := newCountReadWriter(&framedFakeWriter{overhead: 10}, nil, 0, time.Now(), time.Second, false)
+
+		_, err := crw.Write([]byte("hello"))
+		require.NoError(t, err)
+		_, err = crw.Write([]byte("world!"))
+		require.NoError(t, err)
+
+		require.EqualValues(t, 11, crw.writeBytes())
+		require.EqualValues(t, 31, crw.wireWriteBytes())
+	})
+
+	t.Run("NoOverheadWhenUnreported", func(t *testing.T) {
+		t.Parallel()
+
+		crw := newCountReadWriter(&fakeReadWriter{}, nil, 0, time.Now(), time.Second, false)
+
+		_, err := crw.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		require.Equal(t, crw.writeBytes(), crw.wireWriteBytes())
+	})
+}
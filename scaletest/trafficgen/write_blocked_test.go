@@ -0,0 +1,22 @@
+package trafficgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_countReadWriter_writeBlockedDuration(t *testing.T) {
+	t.Parallel()
+
+	const delay = 20 * time.Millisecond
+	crw := newCountReadWriter(&fakeReadWriter{writeDelay: delay}, nil, 0, time.Now(), time.Second, false)
+
+	_, err := crw.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = crw.Write([]byte("world"))
+	require.NoError(t, err)
+
+	require.GreaterOrEqual(t, crw.writeBlockedDuration(), 2*delay)
+}